@@ -2,97 +2,259 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
-	"net/url"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/auth"
 	"github.com/liju-github/internal/config"
-	"github.com/liju-github/internal/controller"
-	"github.com/liju-github/internal/middleware"
+	"github.com/liju-github/internal/mailer"
+	"github.com/liju-github/internal/migration"
+	"github.com/liju-github/internal/realtime"
 	"github.com/liju-github/internal/repository"
+	"github.com/liju-github/internal/router"
+	"github.com/liju-github/internal/scheduler"
+	"github.com/liju-github/internal/server"
 	"github.com/liju-github/internal/service"
+	"github.com/liju-github/internal/storage"
 )
 
 func main() {
-	password := url.QueryEscape("Qwerty@123")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
 
-	mongoURI := "mongodb+srv://admin:" + password + "@olx.ibbnf.mongodb.net/?retryWrites=true&w=majority&appName=olx"
-	dbName := "olxDB"
-	db, err := config.NewMongoDB(mongoURI, dbName)
+	cfg, err := config.Load(*configFile)
 	if err != nil {
-		log.Fatalf("Failed to initialize MongoDB: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
-	defer db.Client.Disconnect(context.TODO())
-
-	// Repositories
-	userRepo := repository.UserRepository{Collection: db.Database.Collection("users")}
-	productRepo := repository.ProductRepository{Collection: db.Database.Collection("products")}
 
-	userService := &service.UserService{UserRepo: userRepo}
-	productService := &service.ProductService{ProductRepo: productRepo}
-
-	// Controllers
-	userController := &controller.UserController{
-		UserService:    userService,
-		ProductService: productService,
+	// The standard logger used throughout the code base writes through slog.
+	slog.SetDefault(cfg.Log.NewLogger(os.Stderr))
+	if !cfg.Log.Debug() {
+		gin.SetMode(gin.ReleaseMode)
 	}
-	productController := &controller.ProductController{ProductService: productService}
 
-	router := gin.Default()
-	config := cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+	db, err := config.NewMongoDB(cfg.Mongo.URI, cfg.Mongo.Database)
+	if err != nil {
+		log.Fatalf("Failed to initialize MongoDB: %v", err)
 	}
 
-	router.Use(cors.New(config))
+	// Start-up work is not bounded by the per-operation timeouts, since
+	// migrations and index builds can take a while on a large collection.
+	startup := context.Background()
 
-	router.POST("/signup", userController.Signup)
-	router.POST("/login", userController.Login)
+	migrationSettings := migration.Settings{
+		ListingTTL:        cfg.Listing.TTL,
+		ListingWarnBefore: cfg.Listing.WarnBefore,
+	}
+	if err := migration.Run(startup, db.Database, migration.All, migrationSettings); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
 
-	authRoutes := router.Group("/")
-	authRoutes.Use(middleware.AuthMiddleware(userRepo))
+	// Repositories
+	timeouts := repository.Timeouts{
+		Read:  cfg.Mongo.ReadTimeout,
+		Write: cfg.Mongo.WriteTimeout,
+		Query: cfg.Mongo.QueryTimeout,
+	}
+	userRepo := &repository.MongoUserRepository{Collection: db.Database.Collection("users"), Timeouts: timeouts}
+	productRepo := &repository.MongoProductRepository{Collection: db.Database.Collection("products"), Timeouts: timeouts}
+	sessionRepo := &repository.MongoSessionRepository{
+		Sessions:      db.Database.Collection("sessions"),
+		RefreshTokens: db.Database.Collection("refresh_tokens"),
+		Timeouts:      timeouts,
+	}
+	chatRepo := &repository.MongoChatRepository{
+		Conversations: db.Database.Collection("conversations"),
+		Messages:      db.Database.Collection("messages"),
+		Timeouts:      timeouts,
+	}
+	favoriteRepo := &repository.MongoFavoriteRepository{Collection: db.Database.Collection("favorites"), Timeouts: timeouts}
+	savedSearchRepo := &repository.MongoSavedSearchRepository{Collection: db.Database.Collection("saved_searches"), Timeouts: timeouts}
+	notificationRepo := &repository.MongoNotificationRepository{
+		Notifications: db.Database.Collection("notifications"),
+		Preferences:   db.Database.Collection("notification_preferences"),
+		Timeouts:      timeouts,
+	}
 
-	authRoutes.POST("/addproduct", productController.AddProduct)
-	authRoutes.GET("/getproducts", productController.GetAllProducts)
-	authRoutes.GET("/allusers", userController.GetAllUsers)
-	authRoutes.GET("/profile",userController.GetProfile)
-	authRoutes.POST("/uploadprofile",userController.UpdateImage)
-	authRoutes.GET("/sellerprofile",userController.GetSellerProfile)
+	if err := userRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create user indexes: %v", err)
+	}
+	if err := productRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create product indexes: %v", err)
+	}
+	if err := sessionRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create session indexes: %v", err)
+	}
+	if err := chatRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create chat indexes: %v", err)
+	}
+	if err := favoriteRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create favorite indexes: %v", err)
+	}
+	if err := savedSearchRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create saved search indexes: %v", err)
+	}
+	if err := notificationRepo.EnsureIndexes(startup); err != nil {
+		log.Fatalf("Failed to create notification indexes: %v", err)
+	}
 
-	gracefulShutdown(router)
+	// Services react to listings changing through productEvents, and push
+	// events to connected clients through hub.
+	productEvents := &service.ProductEvents{}
+	hub := realtime.NewInProcessHub(cfg.Realtime.SendBuffer)
+	gateway := realtime.NewGateway(hub, cfg.CORS.AllowedOrigins, cfg.Realtime.PingInterval)
 
-	log.Println("Server started on port 8080")
-}
+	// Every service notifies users through notificationService, which
+	// delivers through the channels each user chose.
+	var mail mailer.Mailer = mailer.Log{}
+	if cfg.Notification.SMTPAddr != "" {
+		mail = &mailer.SMTP{
+			Addr:     cfg.Notification.SMTPAddr,
+			From:     cfg.Notification.MailFrom,
+			Username: cfg.Notification.SMTPUsername,
+			Password: cfg.Notification.SMTPPassword,
+		}
+	}
+	deliveries := service.NewDeliveryQueue(cfg.Notification.DeliveryWorkers, cfg.Notification.QueueSize, cfg.Notification.DeliveryTimeout)
+	notificationService := &service.NotificationService{
+		Repo: notificationRepo,
+		Channels: []service.DeliveryChannel{
+			service.InAppChannel{Repo: notificationRepo, Hub: hub},
+			service.EmailChannel{Mailer: mail},
+			service.WebhookChannel{Client: service.NewWebhookClient(cfg.Notification.WebhookTimeout)},
+		},
+		Queue: deliveries,
+	}
+
+	userService := &service.UserService{UserRepo: userRepo, BcryptCost: cfg.Bcrypt.Cost}
+	adminService := &service.AdminService{
+		UserRepo:         userRepo,
+		ProductRepo:      productRepo,
+		SessionRepo:      sessionRepo,
+		ChatRepo:         chatRepo,
+		FavoriteRepo:     favoriteRepo,
+		SearchRepo:       savedSearchRepo,
+		NotificationRepo: notificationRepo,
+		Events:           productEvents,
+		Notifier:         notificationService,
+	}
+	keys, err := auth.NewKeySet(cfg.Auth.Keys, cfg.Auth.SigningKeyID)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	sessionService := &service.SessionService{
+		SessionRepo:     sessionRepo,
+		UserRepo:        userRepo,
+		AccessTokenTTL:  cfg.Auth.AccessTokenTTL,
+		RefreshTokenTTL: cfg.Auth.RefreshTokenTTL,
+		Keys:            keys,
+	}
 
-func gracefulShutdown(router *gin.Engine) {
-	// Run the server in a goroutine so that it doesn't block.
-	go func() {
-		if err := router.Run(":8080"); err != nil {
-			log.Fatalf("Server stopped unexpectedly: %v", err)
+	// The first admin is promoted from an existing account on start-up.
+	if email := cfg.Admin.BootstrapEmail; email != "" {
+		if err := userService.BootstrapAdmin(startup, email); err != nil {
+			log.Printf("Failed to make %s an admin: %v", email, err)
+		} else {
+			log.Printf("%s is an admin", email)
 		}
-	}()
+	}
+	productService := &service.ProductService{
+		ProductRepo: productRepo,
+		ListingTTL:  cfg.Listing.TTL,
+		Notifier:    notificationService,
+		Events:      productEvents,
+	}
+	chatService := &service.ChatService{
+		ChatRepo:    chatRepo,
+		ProductRepo: productRepo,
+		Notifier:    notificationService,
+		Hub:         hub,
+	}
+	favoriteService := &service.FavoriteService{
+		FavoriteRepo: favoriteRepo,
+		ProductRepo:  productRepo,
+		Notifier:     notificationService,
+		Hub:          hub,
+	}
+	savedSearchService := &service.SavedSearchService{
+		SearchRepo:     savedSearchRepo,
+		ProductRepo:    productRepo,
+		Notifier:       notificationService,
+		Hub:            hub,
+		DigestInterval: cfg.SavedSearch.DigestInterval,
+	}
+	productEvents.Subscribe(chatService.HandleProductEvent)
+	productEvents.Subscribe(favoriteService.HandleProductEvent)
+	productEvents.Subscribe(savedSearchService.HandleProductEvent)
+	productEvents.Subscribe(service.ProductStatusPublisher(hub))
+
+	mediaService := &service.MediaService{
+		Storage:        &storage.FileSystem{Dir: cfg.Media.Dir, BaseURL: cfg.Media.BaseURL},
+		MaxUploadBytes: cfg.Media.MaxUploadBytes,
+	}
 
-	// Listen for interrupt signals (Ctrl+C, kill, etc.)
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	// Background jobs
+	jobs := scheduler.NewRunner(
+		scheduler.Job{
+			Name:     "expire-listings",
+			Interval: cfg.Listing.CheckInterval,
+			Run:      productService.ExpireListings,
+		},
+		scheduler.Job{
+			Name:     "warn-expiring-listings",
+			Interval: cfg.Listing.CheckInterval,
+			Run: func(ctx context.Context) error {
+				return productService.WarnExpiringListings(ctx, cfg.Listing.WarnBefore)
+			},
+		},
+		scheduler.Job{
+			Name:     "send-saved-search-digests",
+			Interval: cfg.SavedSearch.CheckInterval,
+			Run:      savedSearchService.SendDigests,
+		},
+	)
 
-	<-quit
+	handler := router.New(router.Deps{
+		UserRepo:            userRepo,
+		UserService:         userService,
+		ProductService:      productService,
+		SessionService:      sessionService,
+		AdminService:        adminService,
+		MediaService:        mediaService,
+		ChatService:         chatService,
+		FavoriteService:     favoriteService,
+		SavedSearchService:  savedSearchService,
+		NotificationService: notificationService,
+		Gateway:             gateway,
+		Keys:                keys,
+		CORS:                cfg.CORS,
+	})
 
-	log.Println("Shutting down server...")
+	jobs.Start(context.Background())
 
-	// Shutdown the server with a timeout.
-	timeout := 5 * time.Second
-	_, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	// Stop on Ctrl+C or when the process manager asks us to.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Server gracefully stopped")
+	// The server drains in-flight requests first. WebSockets are not part of
+	// that, so they are closed next, then the background jobs stop and the
+	// queued emails and webhooks go out, and the database goes last because
+	// all of them use it.
+	srv := server.New(handler, cfg.HTTP,
+		server.Step{Name: "WebSockets", Stop: gateway.Close},
+		server.Step{Name: "background jobs", Stop: func(context.Context) error {
+			jobs.Stop()
+			return nil
+		}},
+		server.Step{Name: "notification deliveries", Stop: deliveries.Close},
+		server.Step{Name: "MongoDB", Stop: db.Disconnect},
+	)
+	if err := srv.ListenAndServe(ctx); err != nil {
+		log.Fatalf("Server did not shut down cleanly: %v", err)
+	}
 }
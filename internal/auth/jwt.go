@@ -1,27 +1,42 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/liju-github/internal/model"
 )
 
-var JwtKey = []byte("olxsecret")
+var ErrInvalidToken = errors.New("invalid token")
 
 type Claims struct {
-	UserEmail string `json:"useremail"`
+	UserEmail string     `json:"useremail"`
+	Role      model.Role `json:"role"`
+	SessionID string     `json:"sid"`
 	jwt.StandardClaims
 }
 
-func GenerateJWT(UserEmail string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWT issues an access token for a session that is valid for ttl.
+func (set *KeySet) GenerateJWT(UserEmail string, role model.Role, sessionID string, ttl time.Duration) (string, error) {
+	expirationTime := time.Now().Add(ttl)
 	claims := &Claims{
 		UserEmail: UserEmail,
+		Role:      role,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
+			IssuedAt:  time.Now().Unix(),
 		},
 	}
+	return set.Sign(claims)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(JwtKey)
+// ParseJWT verifies an access token and returns its claims.
+func (set *KeySet) ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := set.Parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
 }
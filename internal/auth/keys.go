@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Supported values of KeyConfig.Algorithm.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minSecretLength is the shortest HS256 secret accepted, 256 bits.
+const minSecretLength = 32
+
+var (
+	ErrNoSigningKey = errors.New("no signing key is configured")
+	ErrUnknownKey   = errors.New("token was signed with an unknown key")
+)
+
+// KeyConfig describes one key of a KeySet. HS256 keys need a Secret. RS256
+// and EdDSA keys need a PEM private key to sign, or just a PEM public key
+// when they are only kept around to verify tokens issued before a rotation.
+type KeyConfig struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKey     string `json:"private_key,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	PublicKey      string `json:"public_key,omitempty"`
+	PublicKeyFile  string `json:"public_key_file,omitempty"`
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{} // nil for keys that only verify
+	verifyKey interface{}
+	public    crypto.PublicKey // nil for HMAC keys, which are never published
+}
+
+// KeySet holds every key tokens may be signed with. New tokens are signed
+// with one key and carry its id in the kid header; tokens signed with any
+// other key of the set are still accepted. To rotate, add the new key, make
+// it the signing key, and drop the old one once its tokens have expired.
+type KeySet struct {
+	signing *signingKey
+	keys    map[string]*signingKey
+	order   []string
+}
+
+// NewKeySet builds a KeySet from configs and signs with the key signingID.
+func NewKeySet(configs []KeyConfig, signingID string) (*KeySet, error) {
+	set := &KeySet{keys: make(map[string]*signingKey, len(configs))}
+	for _, config := range configs {
+		if config.ID == "" {
+			return nil, errors.New("every JWT key needs a kid")
+		}
+		if _, ok := set.keys[config.ID]; ok {
+			return nil, fmt.Errorf("JWT key %q is configured twice", config.ID)
+		}
+		key, err := loadKey(config)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", config.ID, err)
+		}
+		set.keys[key.id] = key
+		set.order = append(set.order, key.id)
+	}
+
+	signing, ok := set.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q is not one of the configured keys", ErrNoSigningKey, signingID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("JWT key %q has no private key and cannot sign", signingID)
+	}
+	set.signing = signing
+	return set, nil
+}
+
+// SigningKeyID is the kid of the key new tokens are signed with.
+func (set *KeySet) SigningKeyID() string {
+	return set.signing.id
+}
+
+// Sign signs claims with the current signing key.
+func (set *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(set.signing.method, claims)
+	token.Header["kid"] = set.signing.id
+	return token.SignedString(set.signing.signKey)
+}
+
+// Parse verifies a token with the key named by its kid header and decodes
+// its claims. The token's alg must match the one configured for that key.
+func (set *KeySet) Parse(tokenString string, claims jwt.Claims) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := set.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, ErrInvalidToken
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set so that other services can verify
+// tokens themselves. HS256 secrets are never included.
+func (set *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, id := range set.order {
+		key := set.keys[id]
+		jwk := JWK{ID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func loadKey(config KeyConfig) (*signingKey, error) {
+	key := &signingKey{id: config.ID}
+
+	if config.Algorithm == AlgorithmHS256 {
+		if len(config.Secret) < minSecretLength {
+			return nil, fmt.Errorf("HS256 secrets must be at least %d bytes", minSecretLength)
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(config.Secret)
+		key.verifyKey = key.signKey
+		return key, nil
+	}
+
+	privatePEM, err := pemFrom(config.PrivateKey, config.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := pemFrom(config.PublicKey, config.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if privatePEM == nil && publicPEM == nil {
+		return nil, errors.New("a private or public key is required")
+	}
+
+	switch config.Algorithm {
+	case AlgorithmRS256:
+		key.method = jwt.SigningMethodRS256
+		if privatePEM != nil {
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.public = &privateKey.PublicKey
+		} else {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.public = publicKey
+		}
+	case AlgorithmEdDSA:
+		key.method = SigningMethodEdDSA
+		if privatePEM != nil {
+			privateKey, err := parseEd25519PrivateKey(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey = privateKey
+			key.public = privateKey.Public()
+		} else {
+			publicKey, err := parseEd25519PublicKey(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.public = publicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", config.Algorithm)
+	}
+
+	key.verifyKey = key.public
+	return key, nil
+}
+
+// pemFrom returns the inline PEM, or the contents of file when there is none.
+func pemFrom(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+func parseEd25519PrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an Ed25519 key")
+	}
+	return privateKey, nil
+}
+
+func parseEd25519PublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an Ed25519 key")
+	}
+	return publicKey, nil
+}
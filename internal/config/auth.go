@@ -0,0 +1,74 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/liju-github/internal/auth"
+)
+
+// defaultKeyID is the kid given to a key configured through JWT_SECRET.
+const defaultKeyID = "default"
+
+// AuthConfig controls the lifetime of access and refresh tokens and the keys
+// they are signed with.
+type AuthConfig struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Keys            []auth.KeyConfig
+	SigningKeyID    string
+}
+
+// load reads ACCESS_TOKEN_TTL, REFRESH_TOKEN_TTL and the JWT keys. The keys
+// are a JSON array of auth.KeyConfig in JWT_KEYS, or in the file named by
+// JWT_KEYS_FILE, and JWT_SIGNING_KEY_ID picks the one new tokens are signed
+// with. A single HS256 secret can be given in JWT_SECRET instead.
+func (config *AuthConfig) load(s *source) {
+	s.duration(&config.AccessTokenTTL, "auth.access_token_ttl", "ACCESS_TOKEN_TTL")
+	s.duration(&config.RefreshTokenTTL, "auth.refresh_token_ttl", "REFRESH_TOKEN_TTL")
+	s.string(&config.SigningKeyID, "auth.signing_key_id", "JWT_SIGNING_KEY_ID")
+	s.json(&config.Keys, "auth.keys", "JWT_KEYS")
+
+	var keysFile, secret string
+	s.string(&keysFile, "auth.keys_file", "JWT_KEYS_FILE")
+	s.string(&secret, "auth.secret", "JWT_SECRET")
+
+	if len(config.Keys) == 0 && keysFile != "" {
+		data, err := os.ReadFile(keysFile)
+		if err != nil {
+			s.fail("JWT_KEYS_FILE", err)
+		} else if err := json.Unmarshal(data, &config.Keys); err != nil {
+			s.fail("JWT_KEYS_FILE", fmt.Errorf("parsing %s: %w", keysFile, err))
+		}
+	}
+	if len(config.Keys) == 0 && secret != "" {
+		config.Keys = []auth.KeyConfig{{
+			ID:        defaultKeyID,
+			Algorithm: auth.AlgorithmHS256,
+			Secret:    secret,
+		}}
+		config.SigningKeyID = defaultKeyID
+	}
+	if config.SigningKeyID == "" && len(config.Keys) == 1 {
+		config.SigningKeyID = config.Keys[0].ID
+	}
+}
+
+func (config AuthConfig) validate() []error {
+	var errs []error
+	if config.AccessTokenTTL <= 0 || config.RefreshTokenTTL <= 0 {
+		errs = append(errs, errors.New("token lifetimes must be positive"))
+	}
+	if config.AccessTokenTTL > config.RefreshTokenTTL {
+		errs = append(errs, errors.New("access tokens cannot outlive refresh tokens"))
+	}
+	if len(config.Keys) == 0 {
+		errs = append(errs, errors.New("no JWT signing keys configured, set auth.keys (JWT_KEYS), auth.keys_file (JWT_KEYS_FILE) or auth.secret (JWT_SECRET)"))
+	} else if config.SigningKeyID == "" {
+		errs = append(errs, errors.New("auth.signing_key_id (JWT_SIGNING_KEY_ID) is required when several keys are configured"))
+	}
+	return errs
+}
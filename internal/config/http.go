@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// HTTPConfig controls the address the API listens on and the server
+// timeouts.
+type HTTPConfig struct {
+	Addr              string // host:port, or :port to listen on every interface
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration // How long in-flight requests get to finish on shutdown
+}
+
+func (config *HTTPConfig) load(s *source) {
+	s.string(&config.Addr, "http.addr", "HTTP_ADDR")
+	s.duration(&config.ReadTimeout, "http.read_timeout", "HTTP_READ_TIMEOUT")
+	s.duration(&config.ReadHeaderTimeout, "http.read_header_timeout", "HTTP_READ_HEADER_TIMEOUT")
+	s.duration(&config.WriteTimeout, "http.write_timeout", "HTTP_WRITE_TIMEOUT")
+	s.duration(&config.IdleTimeout, "http.idle_timeout", "HTTP_IDLE_TIMEOUT")
+	s.duration(&config.ShutdownTimeout, "http.shutdown_timeout", "HTTP_SHUTDOWN_TIMEOUT")
+}
+
+func (config HTTPConfig) validate() []error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+		errs = append(errs, errors.New("http.addr (HTTP_ADDR) must look like \":8080\" or \"127.0.0.1:8080\""))
+	}
+	if config.ReadTimeout < 0 || config.ReadHeaderTimeout < 0 || config.WriteTimeout < 0 || config.IdleTimeout < 0 {
+		errs = append(errs, errors.New("http timeouts cannot be negative, use 0 for no timeout"))
+	}
+	if config.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("http.shutdown_timeout (HTTP_SHUTDOWN_TIMEOUT) must be positive"))
+	}
+	return errs
+}
+
+// CORSConfig controls which browser origins may call the API.
+type CORSConfig struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+	MaxAge           time.Duration // How long browsers may cache preflight responses
+}
+
+func (config *CORSConfig) load(s *source) {
+	s.strings(&config.AllowedOrigins, "cors.allowed_origins", "CORS_ALLOWED_ORIGINS")
+	s.bool(&config.AllowCredentials, "cors.allow_credentials", "CORS_ALLOW_CREDENTIALS")
+	s.duration(&config.MaxAge, "cors.max_age", "CORS_MAX_AGE")
+}
+
+func (config CORSConfig) validate() []error {
+	var errs []error
+	if len(config.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("cors.allowed_origins (CORS_ALLOWED_ORIGINS) needs at least one origin"))
+	}
+	if config.MaxAge < 0 {
+		errs = append(errs, errors.New("cors.max_age (CORS_MAX_AGE) cannot be negative"))
+	}
+	return errs
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// ListingConfig controls how long listings stay active and how often the
+// expiry job runs.
+type ListingConfig struct {
+	TTL           time.Duration // How long a listing stays active; 0 disables expiry
+	WarnBefore    time.Duration // How long before expiry the seller is warned
+	CheckInterval time.Duration // How often expiry and warnings are checked
+}
+
+// load reads LISTING_TTL, LISTING_EXPIRY_WARNING and
+// LISTING_EXPIRY_CHECK_INTERVAL, which use time.ParseDuration syntax such as
+// "720h".
+func (config *ListingConfig) load(s *source) {
+	s.duration(&config.TTL, "listing.ttl", "LISTING_TTL")
+	s.duration(&config.WarnBefore, "listing.expiry_warning", "LISTING_EXPIRY_WARNING")
+	s.duration(&config.CheckInterval, "listing.expiry_check_interval", "LISTING_EXPIRY_CHECK_INTERVAL")
+}
+
+func (config ListingConfig) validate() []error {
+	var errs []error
+	if config.TTL < 0 || config.WarnBefore < 0 || config.CheckInterval < 0 {
+		errs = append(errs, errors.New("listing durations cannot be negative"))
+	}
+	return errs
+}
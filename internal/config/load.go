@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Config is the complete configuration of the server. Settings come from
+// environment variables, then from an optional YAML or TOML file, then from
+// the defaults in Default.
+type Config struct {
+	Mongo        MongoConfig
+	HTTP         HTTPConfig
+	CORS         CORSConfig
+	Auth         AuthConfig
+	Bcrypt       BcryptConfig
+	Log          LogConfig
+	Listing      ListingConfig
+	Media        MediaConfig
+	Realtime     RealtimeConfig
+	SavedSearch  SavedSearchConfig
+	Notification NotificationConfig
+	Admin        AdminConfig
+}
+
+// MongoConfig holds the database connection settings. The timeouts bound
+// single operations on top of the deadline of the request they run for.
+type MongoConfig struct {
+	URI          string
+	Database     string
+	ReadTimeout  time.Duration // Lookups of a single document
+	WriteTimeout time.Duration // Inserts, updates and deletes
+	QueryTimeout time.Duration // Listings, searches and counts
+}
+
+// BcryptConfig controls how expensive password hashes are to compute.
+type BcryptConfig struct {
+	Cost int
+}
+
+// AdminConfig names an existing account that is promoted to admin on
+// start-up, which is how the first admin is created.
+type AdminConfig struct {
+	BootstrapEmail string
+}
+
+// Default returns the configuration used for every setting that is not set.
+// There is no default for the Mongo URI or the JWT keys.
+func Default() Config {
+	return Config{
+		Mongo: MongoConfig{
+			Database:     "olxDB",
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+			QueryTimeout: 10 * time.Second,
+		},
+		HTTP: HTTPConfig{
+			Addr:              ":8080",
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+			MaxAge:           12 * time.Hour,
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 30 * 24 * time.Hour,
+		},
+		Bcrypt: BcryptConfig{Cost: 14},
+		Log:    LogConfig{Level: "info", Format: "text"},
+		Listing: ListingConfig{
+			TTL:           30 * 24 * time.Hour,
+			WarnBefore:    3 * 24 * time.Hour,
+			CheckInterval: time.Hour,
+		},
+		Media: MediaConfig{
+			Dir:            "uploads",
+			BaseURL:        "http://localhost:8080/media",
+			MaxUploadBytes: 5 << 20,
+		},
+		Realtime: RealtimeConfig{
+			PingInterval: 30 * time.Second,
+			SendBuffer:   64,
+		},
+		SavedSearch: SavedSearchConfig{
+			DigestInterval: 24 * time.Hour,
+			CheckInterval:  time.Hour,
+		},
+		Notification: NotificationConfig{
+			WebhookTimeout:  5 * time.Second,
+			DeliveryWorkers: 4,
+			QueueSize:       1000,
+			DeliveryTimeout: 30 * time.Second,
+		},
+	}
+}
+
+// Load reads the configuration from the environment and, when file is not
+// empty, from a .yaml, .yml or .toml file. Environment variables win over
+// the file. Every problem found is reported at once in the returned error.
+func Load(file string) (Config, error) {
+	config := Default()
+
+	s, err := newSource(file)
+	if err != nil {
+		return config, err
+	}
+
+	s.string(&config.Mongo.URI, "mongo.uri", "MONGO_URI")
+	s.string(&config.Mongo.Database, "mongo.database", "MONGO_DATABASE")
+	s.duration(&config.Mongo.ReadTimeout, "mongo.read_timeout", "MONGO_READ_TIMEOUT")
+	s.duration(&config.Mongo.WriteTimeout, "mongo.write_timeout", "MONGO_WRITE_TIMEOUT")
+	s.duration(&config.Mongo.QueryTimeout, "mongo.query_timeout", "MONGO_QUERY_TIMEOUT")
+	config.HTTP.load(s)
+	config.CORS.load(s)
+	config.Auth.load(s)
+	s.int(&config.Bcrypt.Cost, "bcrypt.cost", "BCRYPT_COST")
+	config.Log.load(s)
+	config.Listing.load(s)
+	config.Media.load(s)
+	config.Realtime.load(s)
+	config.SavedSearch.load(s)
+	config.Notification.load(s)
+	s.string(&config.Admin.BootstrapEmail, "admin.bootstrap_email", "BOOTSTRAP_ADMIN_EMAIL")
+
+	errs := s.errs
+	if unknown := s.unknownKeys(); len(unknown) > 0 {
+		errs = append(errs, fmt.Errorf("unknown settings in %s: %s", file, strings.Join(unknown, ", ")))
+	}
+	errs = append(errs, config.validate()...)
+	return config, errors.Join(errs...)
+}
+
+func (config Config) validate() []error {
+	var errs []error
+	if config.Mongo.URI == "" {
+		errs = append(errs, errors.New("mongo.uri (MONGO_URI) is required"))
+	}
+	if config.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo.database (MONGO_DATABASE) is required"))
+	}
+	if config.Mongo.ReadTimeout < 0 || config.Mongo.WriteTimeout < 0 || config.Mongo.QueryTimeout < 0 {
+		errs = append(errs, errors.New("mongo timeouts cannot be negative, use 0 for no timeout"))
+	}
+	if config.Bcrypt.Cost < bcrypt.MinCost || config.Bcrypt.Cost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt.cost (BCRYPT_COST) must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if email := config.Admin.BootstrapEmail; email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			errs = append(errs, fmt.Errorf("admin.bootstrap_email (BOOTSTRAP_ADMIN_EMAIL) %q is not an email address", email))
+		}
+	}
+	errs = append(errs, config.HTTP.validate()...)
+	errs = append(errs, config.CORS.validate()...)
+	errs = append(errs, config.Auth.validate()...)
+	errs = append(errs, config.Log.validate()...)
+	errs = append(errs, config.Listing.validate()...)
+	errs = append(errs, config.Media.validate()...)
+	errs = append(errs, config.Realtime.validate()...)
+	errs = append(errs, config.SavedSearch.validate()...)
+	errs = append(errs, config.Notification.validate()...)
+	return errs
+}
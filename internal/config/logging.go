@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// LogConfig controls the level and format of the server logs.
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+}
+
+func (config *LogConfig) load(s *source) {
+	s.string(&config.Level, "log.level", "LOG_LEVEL")
+	s.string(&config.Format, "log.format", "LOG_FORMAT")
+}
+
+func (config LogConfig) validate() []error {
+	var errs []error
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level (LOG_LEVEL) %q must be debug, info, warn or error", config.Level))
+	}
+	switch strings.ToLower(config.Format) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format (LOG_FORMAT) %q must be text or json", config.Format))
+	}
+	return errs
+}
+
+// NewLogger builds a logger that writes to w in the configured format,
+// dropping messages below the configured level.
+func (config LogConfig) NewLogger(w io.Writer) *slog.Logger {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(config.Level))
+	options := &slog.HandlerOptions{Level: level}
+	if strings.ToLower(config.Format) == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// Debug reports whether debug logging is enabled.
+func (config LogConfig) Debug() bool {
+	return strings.EqualFold(config.Level, "debug")
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"errors"
+	"net/url"
+)
+
+// MediaConfig controls where uploaded images are stored and how large they
+// may be.
+type MediaConfig struct {
+	Dir            string // Directory of the filesystem storage backend
+	BaseURL        string // Public URL the stored files are served from
+	MaxUploadBytes int64
+}
+
+// load reads MEDIA_DIR, MEDIA_BASE_URL and MEDIA_MAX_UPLOAD_BYTES.
+func (config *MediaConfig) load(s *source) {
+	s.string(&config.Dir, "media.dir", "MEDIA_DIR")
+	s.string(&config.BaseURL, "media.base_url", "MEDIA_BASE_URL")
+	s.int64(&config.MaxUploadBytes, "media.max_upload_bytes", "MEDIA_MAX_UPLOAD_BYTES")
+}
+
+func (config MediaConfig) validate() []error {
+	var errs []error
+	if config.Dir == "" {
+		errs = append(errs, errors.New("media.dir (MEDIA_DIR) is required"))
+	}
+	if u, err := url.Parse(config.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, errors.New("media.base_url (MEDIA_BASE_URL) must be an absolute URL"))
+	}
+	if config.MaxUploadBytes <= 0 {
+		errs = append(errs, errors.New("media.max_upload_bytes (MEDIA_MAX_UPLOAD_BYTES) must be positive"))
+	}
+	return errs
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"time"
+)
+
+// NotificationConfig controls how notifications leave the server. Emails
+// are only logged when SMTPAddr is empty. Emails and webhooks are delivered
+// in the background by DeliveryWorkers, from a queue of up to QueueSize
+// notifications.
+type NotificationConfig struct {
+	SMTPAddr        string // host:port of the SMTP server
+	SMTPUsername    string
+	SMTPPassword    string
+	MailFrom        string
+	WebhookTimeout  time.Duration // How long a user's webhook gets to answer
+	DeliveryWorkers int
+	QueueSize       int
+	DeliveryTimeout time.Duration // How long a single email or webhook delivery may take
+}
+
+// load reads SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM,
+// NOTIFY_WEBHOOK_TIMEOUT, NOTIFY_WORKERS, NOTIFY_QUEUE_SIZE and
+// NOTIFY_DELIVERY_TIMEOUT.
+func (config *NotificationConfig) load(s *source) {
+	s.string(&config.SMTPAddr, "notification.smtp_addr", "SMTP_ADDR")
+	s.string(&config.SMTPUsername, "notification.smtp_username", "SMTP_USERNAME")
+	s.string(&config.SMTPPassword, "notification.smtp_password", "SMTP_PASSWORD")
+	s.string(&config.MailFrom, "notification.mail_from", "MAIL_FROM")
+	s.duration(&config.WebhookTimeout, "notification.webhook_timeout", "NOTIFY_WEBHOOK_TIMEOUT")
+	s.int(&config.DeliveryWorkers, "notification.delivery_workers", "NOTIFY_WORKERS")
+	s.int(&config.QueueSize, "notification.queue_size", "NOTIFY_QUEUE_SIZE")
+	s.duration(&config.DeliveryTimeout, "notification.delivery_timeout", "NOTIFY_DELIVERY_TIMEOUT")
+}
+
+func (config NotificationConfig) validate() []error {
+	var errs []error
+	if config.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(config.SMTPAddr); err != nil {
+			errs = append(errs, fmt.Errorf("notification.smtp_addr (SMTP_ADDR) must be host:port: %v", err))
+		}
+		if _, err := mail.ParseAddress(config.MailFrom); err != nil {
+			errs = append(errs, errors.New("notification.mail_from (MAIL_FROM) must be an email address when SMTP is configured"))
+		}
+	}
+	if config.WebhookTimeout <= 0 {
+		errs = append(errs, errors.New("notification.webhook_timeout (NOTIFY_WEBHOOK_TIMEOUT) must be positive"))
+	}
+	if config.DeliveryWorkers < 1 {
+		errs = append(errs, errors.New("notification.delivery_workers (NOTIFY_WORKERS) must be at least 1"))
+	}
+	if config.QueueSize < 1 {
+		errs = append(errs, errors.New("notification.queue_size (NOTIFY_QUEUE_SIZE) must be at least 1"))
+	}
+	if config.DeliveryTimeout <= 0 {
+		errs = append(errs, errors.New("notification.delivery_timeout (NOTIFY_DELIVERY_TIMEOUT) must be positive"))
+	}
+	return errs
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// RealtimeConfig controls the WebSocket connections.
+type RealtimeConfig struct {
+	PingInterval time.Duration // Clients that miss two pings in a row are dropped
+	SendBuffer   int           // Events a client may fall behind by before it is dropped
+}
+
+// load reads WS_PING_INTERVAL and WS_SEND_BUFFER.
+func (config *RealtimeConfig) load(s *source) {
+	s.duration(&config.PingInterval, "realtime.ping_interval", "WS_PING_INTERVAL")
+	s.int(&config.SendBuffer, "realtime.send_buffer", "WS_SEND_BUFFER")
+}
+
+func (config RealtimeConfig) validate() []error {
+	var errs []error
+	if config.PingInterval <= 0 {
+		errs = append(errs, errors.New("realtime.ping_interval (WS_PING_INTERVAL) must be positive"))
+	}
+	if config.SendBuffer < 1 {
+		errs = append(errs, errors.New("realtime.send_buffer (WS_SEND_BUFFER) must be at least 1"))
+	}
+	return errs
+}
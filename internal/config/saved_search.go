@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// SavedSearchConfig controls the daily digests of saved search matches.
+type SavedSearchConfig struct {
+	DigestInterval time.Duration // Least time between two digests of a search
+	CheckInterval  time.Duration // How often due digests are looked for
+}
+
+// load reads SAVED_SEARCH_DIGEST_INTERVAL and
+// SAVED_SEARCH_DIGEST_CHECK_INTERVAL.
+func (config *SavedSearchConfig) load(s *source) {
+	s.duration(&config.DigestInterval, "saved_search.digest_interval", "SAVED_SEARCH_DIGEST_INTERVAL")
+	s.duration(&config.CheckInterval, "saved_search.digest_check_interval", "SAVED_SEARCH_DIGEST_CHECK_INTERVAL")
+}
+
+func (config SavedSearchConfig) validate() []error {
+	var errs []error
+	if config.DigestInterval <= 0 || config.CheckInterval <= 0 {
+		errs = append(errs, errors.New("saved search digest intervals must be positive"))
+	}
+	return errs
+}
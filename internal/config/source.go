@@ -0,0 +1,194 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// source looks settings up in the environment first and then in the config
+// file. Every setting has a dotted key, such as "listing.ttl", under which it
+// appears in the file, and an environment variable, such as LISTING_TTL.
+// Values that cannot be parsed are collected in errs rather than replaced by
+// defaults, so that a typo stops the server instead of going unnoticed.
+type source struct {
+	file   string
+	values map[string]any // Settings of the config file by dotted key
+	used   map[string]bool
+	errs   []error
+}
+
+func newSource(file string) (*source, error) {
+	s := &source{file: file, values: map[string]any{}, used: map[string]bool{}}
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	tree := map[string]any{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", file, err)
+	}
+	flatten("", tree, s.values)
+	return s, nil
+}
+
+// flatten turns nested tables into dotted keys. Lists are kept as values.
+func flatten(prefix string, tree map[string]any, values map[string]any) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if table, ok := value.(map[string]any); ok {
+			flatten(key, table, values)
+			continue
+		}
+		values[key] = value
+	}
+}
+
+// lookup returns the raw value of a setting and a description of where it
+// came from for error messages.
+func (s *source) lookup(key, env string) (any, string, bool) {
+	s.used[key] = true
+	if value, ok := os.LookupEnv(env); ok && value != "" {
+		return value, env, true
+	}
+	if value, ok := s.values[key]; ok {
+		return value, fmt.Sprintf("%s in %s", key, s.file), true
+	}
+	return nil, "", false
+}
+
+func (s *source) fail(origin string, err error) {
+	s.errs = append(s.errs, fmt.Errorf("%s: %w", origin, err))
+}
+
+func (s *source) string(dst *string, key, env string) {
+	if value, _, ok := s.lookup(key, env); ok {
+		*dst = fmt.Sprint(value)
+	}
+}
+
+func (s *source) int(dst *int, key, env string) {
+	var number int64
+	if s.int64(&number, key, env) {
+		*dst = int(number)
+	}
+}
+
+func (s *source) int64(dst *int64, key, env string) bool {
+	value, origin, ok := s.lookup(key, env)
+	if !ok {
+		return false
+	}
+	number, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	if err != nil {
+		s.fail(origin, fmt.Errorf("%q is not a whole number", fmt.Sprint(value)))
+		return false
+	}
+	*dst = number
+	return true
+}
+
+func (s *source) bool(dst *bool, key, env string) {
+	value, origin, ok := s.lookup(key, env)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(fmt.Sprint(value))
+	if err != nil {
+		s.fail(origin, fmt.Errorf("%q is not true or false", fmt.Sprint(value)))
+		return
+	}
+	*dst = b
+}
+
+// duration reads a duration in time.ParseDuration syntax, such as "720h".
+func (s *source) duration(dst *time.Duration, key, env string) {
+	value, origin, ok := s.lookup(key, env)
+	if !ok {
+		return
+	}
+	duration, err := time.ParseDuration(fmt.Sprint(value))
+	if err != nil {
+		s.fail(origin, fmt.Errorf("%q is not a duration such as \"30s\" or \"720h\"", fmt.Sprint(value)))
+		return
+	}
+	*dst = duration
+}
+
+// strings reads a list, which is comma separated in the environment.
+func (s *source) strings(dst *[]string, key, env string) {
+	value, _, ok := s.lookup(key, env)
+	if !ok {
+		return
+	}
+	var list []string
+	switch value := value.(type) {
+	case []any:
+		for _, item := range value {
+			list = append(list, fmt.Sprint(item))
+		}
+	default:
+		for _, item := range strings.Split(fmt.Sprint(value), ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	*dst = list
+}
+
+// json reads structured settings, which are JSON in the environment.
+func (s *source) json(dst any, key, env string) {
+	value, origin, ok := s.lookup(key, env)
+	if !ok {
+		return
+	}
+	data, isString := value.(string)
+	if !isString {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			s.fail(origin, err)
+			return
+		}
+		data = string(encoded)
+	}
+	if err := json.Unmarshal([]byte(data), dst); err != nil {
+		s.fail(origin, err)
+	}
+}
+
+// unknownKeys reports settings in the config file that were never read,
+// which are most likely misspelled.
+func (s *source) unknownKeys() []string {
+	var unknown []string
+	for key := range s.values {
+		if !s.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/service"
+)
+
+type AdminController struct {
+	AdminService *service.AdminService
+	UserService  *service.UserService
+}
+
+type SetRoleRequest struct {
+	Role model.Role `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+// ListUsers returns every user, one page at a time.
+func (ctrl *AdminController) ListUsers(c *gin.Context) {
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
+	users, next, err := ctrl.UserService.AllUsers(c.Request.Context(), page)
+	if err != nil {
+		log.Println("Error fetching users in ListUsers: ", err)
+		respondListError(c, err, "Failed to retrieve users")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": dto.NewUserResponses(users), "next_cursor": next})
+}
+
+func (ctrl *AdminController) SuspendUser(c *gin.Context) {
+	ctrl.setSuspended(c, true)
+}
+
+func (ctrl *AdminController) UnsuspendUser(c *gin.Context) {
+	ctrl.setSuspended(c, false)
+}
+
+func (ctrl *AdminController) SetUserRole(c *gin.Context) {
+	actor, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding JSON in SetUserRole: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in SetUserRole: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	if err := ctrl.AdminService.SetUserRole(c.Request.Context(), actor, c.Param("email"), req.Role); err != nil {
+		log.Println("Failed to set role in SetUserRole: ", err)
+		respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User role updated"})
+}
+
+// DeleteUser removes a user and all of their listings.
+func (ctrl *AdminController) DeleteUser(c *gin.Context) {
+	actor, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.AdminService.DeleteUser(c.Request.Context(), actor, c.Param("email")); err != nil {
+		log.Println("Failed to delete user in DeleteUser: ", err)
+		respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// RemoveProduct deletes any listing, regardless of who posted it.
+func (ctrl *AdminController) RemoveProduct(c *gin.Context) {
+	actor, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.AdminService.RemoveProduct(c.Request.Context(), actor, c.Param("id")); err != nil {
+		log.Println("Failed to remove product in RemoveProduct: ", err)
+		respondAdminError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product removed"})
+}
+
+func (ctrl *AdminController) setSuspended(c *gin.Context, suspended bool) {
+	actor, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.AdminService.SetUserSuspended(c.Request.Context(), actor, c.Param("email"), suspended); err != nil {
+		log.Println("Failed to change suspension in setSuspended: ", err)
+		respondAdminError(c, err)
+		return
+	}
+
+	message := "User reinstated"
+	if suspended {
+		message = "User suspended"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+func respondAdminError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, service.ErrCannotTargetSelf), errors.Is(err, service.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondProductError(c, err)
+	}
+}
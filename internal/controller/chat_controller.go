@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/service"
+)
+
+type ChatController struct {
+	ChatService *service.ChatService
+}
+
+// StartConversation opens a conversation with the seller of a product, or
+// returns the one the buyer already has, optionally sending a first message.
+func (ctrl *ChatController) StartConversation(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	var req dto.StartConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding JSON in StartConversation: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in StartConversation: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	conversation, err := ctrl.ChatService.StartConversation(c.Request.Context(), req.ProductID, email, req.Message)
+	if err != nil {
+		log.Println("Failed to start conversation in StartConversation: ", err)
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": dto.NewConversationResponse(*conversation)})
+}
+
+// Inbox lists the conversations of the user, most recently active first,
+// with the number of unread messages in each.
+func (ctrl *ChatController) Inbox(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
+	entries, next, err := ctrl.ChatService.Inbox(c.Request.Context(), email, page)
+	if err != nil {
+		log.Println("Failed to fetch inbox in Inbox: ", err)
+		respondListError(c, err, "Failed to fetch conversations")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversations": dto.NewInboxResponses(entries), "next_cursor": next})
+}
+
+func (ctrl *ChatController) GetConversation(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	conversation, err := ctrl.ChatService.Conversation(c.Request.Context(), c.Param("id"), email)
+	if err != nil {
+		log.Println("Failed to fetch conversation in GetConversation: ", err)
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": dto.NewConversationResponse(*conversation)})
+}
+
+// GetMessages returns the messages of a conversation, newest first. Pass the
+// next_cursor of a response as cursor to load older messages.
+func (ctrl *ChatController) GetMessages(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
+	messages, next, err := ctrl.ChatService.Messages(c.Request.Context(), c.Param("id"), email, page)
+	if err != nil {
+		log.Println("Failed to fetch messages in GetMessages: ", err)
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": dto.NewMessageResponses(messages), "next_cursor": next})
+}
+
+func (ctrl *ChatController) SendMessage(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	var req dto.SendMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding JSON in SendMessage: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in SendMessage: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	message, err := ctrl.ChatService.SendMessage(c.Request.Context(), c.Param("id"), email, req.Body)
+	if err != nil {
+		log.Println("Failed to send message in SendMessage: ", err)
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": dto.NewMessageResponse(*message)})
+}
+
+// MarkRead marks every message the user received in a conversation as read.
+func (ctrl *ChatController) MarkRead(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	marked, err := ctrl.ChatService.MarkRead(c.Request.Context(), c.Param("id"), email)
+	if err != nil {
+		log.Println("Failed to mark messages read in MarkRead: ", err)
+		respondChatError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked_read": marked})
+}
+
+func respondChatError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidConversationID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+	case errors.Is(err, service.ErrConversationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+	case errors.Is(err, service.ErrNotParticipant):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not part of this conversation"})
+	case errors.Is(err, service.ErrConversationArchived):
+		c.JSON(http.StatusConflict, gin.H{"error": "This conversation is archived"})
+	case errors.Is(err, service.ErrOwnProduct), errors.Is(err, service.ErrEmptyMessage),
+		errors.Is(err, service.ErrMessageTooLong):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrProductUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "This product is no longer available"})
+	case errors.Is(err, service.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+	default:
+		respondProductError(c, err)
+	}
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/service"
+)
+
+type FavoriteController struct {
+	FavoriteService *service.FavoriteService
+}
+
+// AddFavorite saves a listing. Saving it again changes nothing.
+func (ctrl *FavoriteController) AddFavorite(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.FavoriteService.AddFavorite(c.Request.Context(), c.Param("id"), email); err != nil {
+		log.Println("Failed to save product in AddFavorite: ", err)
+		respondFavoriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product added to favorites"})
+}
+
+func (ctrl *FavoriteController) RemoveFavorite(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.FavoriteService.RemoveFavorite(c.Request.Context(), c.Param("id"), email); err != nil {
+		log.Println("Failed to remove favorite in RemoveFavorite: ", err)
+		respondFavoriteError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product removed from favorites"})
+}
+
+// ListFavorites returns the listings the user saved, most recently saved
+// first, with their current status and price.
+func (ctrl *FavoriteController) ListFavorites(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
+	favorites, next, err := ctrl.FavoriteService.Favorites(c.Request.Context(), email, page)
+	if err != nil {
+		log.Println("Failed to fetch favorites in ListFavorites: ", err)
+		respondListError(c, err, "Failed to fetch favorites")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"favorites": dto.NewFavoriteResponses(favorites), "next_cursor": next})
+}
+
+func respondFavoriteError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrFavoriteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product is not in your favorites"})
+	case errors.Is(err, service.ErrOwnFavorite):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrProductUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "This product is no longer available"})
+	default:
+		respondProductError(c, err)
+	}
+}
@@ -0,0 +1,19 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/auth"
+)
+
+type KeysController struct {
+	Keys *auth.KeySet
+}
+
+// JWKS publishes the public keys access tokens are signed with so that other
+// services can verify them without calling us.
+func (ctrl *KeysController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctrl.Keys.JWKS())
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/imaging"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/service"
+	"github.com/liju-github/internal/storage"
+)
+
+// multipartOverhead allows for the multipart headers around the file.
+const multipartOverhead = 64 << 10
+
+type MediaController struct {
+	MediaService   *service.MediaService
+	UserService    *service.UserService
+	ProductService *service.ProductService
+}
+
+// UploadProfileImage stores the multipart "image" file and makes it the
+// profile image of the logged in user.
+func (ctrl *MediaController) UploadProfileImage(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	uploaded, ok := ctrl.upload(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.UserService.UpdateUserImage(c.Request.Context(), email, uploaded.URL); err != nil {
+		log.Println("Failed to update profile image in UploadProfileImage: ", err)
+		ctrl.MediaService.DeleteImage(c.Request.Context(), uploaded)
+		respondServerError(c, err, "Failed to update profile image")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User image updated successfully.", "image": uploaded})
+}
+
+// UploadProductImage stores the multipart "image" file and adds it to the
+// gallery of the seller's product.
+func (ctrl *MediaController) UploadProductImage(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	// Check ownership and the gallery size before storing anything.
+	product, err := ctrl.ProductService.GetOwnedProduct(c.Request.Context(), c.Param("id"), email)
+	if err != nil {
+		log.Println("Failed to load product in UploadProductImage: ", err)
+		respondProductError(c, err)
+		return
+	}
+	product.NormalizeImages()
+	if len(product.Images) >= model.MaxProductImages {
+		respondProductError(c, service.ErrTooManyImages)
+		return
+	}
+
+	uploaded, ok := ctrl.upload(c)
+	if !ok {
+		return
+	}
+
+	images, err := ctrl.ProductService.AddImage(c.Request.Context(), c.Param("id"), email, uploaded.URL)
+	if err != nil {
+		log.Println("Failed to add uploaded image in UploadProductImage: ", err)
+		// Nothing points at the stored files, so they are not kept.
+		ctrl.MediaService.DeleteImage(c.Request.Context(), uploaded)
+		respondProductError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"image": uploaded, "images": images})
+}
+
+// ServeMedia serves stored files. File names are random and never reused, so
+// clients and proxies may cache them for good.
+func (ctrl *MediaController) ServeMedia(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	file, err := ctrl.MediaService.OpenMedia(c.Request.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to open media file in ServeMedia: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer file.Close()
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", `"`+key+`"`)
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, file)
+}
+
+// upload reads the "image" form file, stores it and writes an error response
+// when it is missing, too large or not a supported image.
+func (ctrl *MediaController) upload(c *gin.Context) (*service.UploadedImage, bool) {
+	maxBytes := ctrl.MediaService.MaxUploadBytes
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes+multipartOverhead)
+
+	header, err := c.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An image file is required in the image field"})
+		return nil, false
+	}
+	if header.Size > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is too large"})
+		return nil, false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		log.Println("Failed to open uploaded file: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to read the uploaded file"})
+		return nil, false
+	}
+	defer file.Close()
+
+	uploaded, err := ctrl.MediaService.UploadImage(c.Request.Context(), file)
+	switch {
+	case err == nil:
+		return uploaded, true
+	case errors.Is(err, service.ErrImageTooLarge), errors.Is(err, imaging.ErrTooManyPixels):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, imaging.ErrUnsupportedType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, imaging.ErrInvalidImage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		log.Println("Failed to store uploaded image: ", err)
+		respondServerError(c, err, "Failed to store image")
+	}
+	return nil, false
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/service"
+)
+
+type NotificationController struct {
+	NotificationService *service.NotificationService
+}
+
+// ListNotifications returns the user's in-app notifications, newest first.
+// With ?unread=true, read notifications are left out.
+func (ctrl *NotificationController) ListNotifications(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+	unreadOnly := false
+	if raw := c.Query("unread"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unread must be true or false"})
+			return
+		}
+		unreadOnly = parsed
+	}
+
+	notifications, next, err := ctrl.NotificationService.List(c.Request.Context(), email, unreadOnly, page)
+	if err != nil {
+		log.Println("Failed to fetch notifications in ListNotifications: ", err)
+		respondListError(c, err, "Failed to fetch notifications")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"notifications": dto.NewNotificationResponses(notifications), "next_cursor": next})
+}
+
+func (ctrl *NotificationController) UnreadCount(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	count, err := ctrl.NotificationService.UnreadCount(c.Request.Context(), email)
+	if err != nil {
+		log.Println("Failed to count notifications in UnreadCount: ", err)
+		respondServerError(c, err, "Failed to count notifications")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": count})
+}
+
+func (ctrl *NotificationController) MarkRead(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.NotificationService.MarkRead(c.Request.Context(), c.Param("id"), email); err != nil {
+		log.Println("Failed to mark notification read in MarkRead: ", err)
+		respondNotificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+func (ctrl *NotificationController) MarkAllRead(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	marked, err := ctrl.NotificationService.MarkAllRead(c.Request.Context(), email)
+	if err != nil {
+		log.Println("Failed to mark notifications read in MarkAllRead: ", err)
+		respondServerError(c, err, "Failed to mark notifications as read")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"marked_read": marked})
+}
+
+// GetPreferences returns the channels of every notification category,
+// defaults included.
+func (ctrl *NotificationController) GetPreferences(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	preferences, err := ctrl.NotificationService.Preferences(c.Request.Context(), email)
+	if err != nil {
+		log.Println("Failed to fetch notification preferences in GetPreferences: ", err)
+		respondServerError(c, err, "Failed to fetch notification preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": dto.NewNotificationPreferencesResponse(*preferences)})
+}
+
+// UpdatePreferences changes the channels of the categories in the body and,
+// when it is given, the webhook URL.
+func (ctrl *NotificationController) UpdatePreferences(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	var req dto.NotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding notification preferences: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in notification preferences: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	preferences, err := ctrl.NotificationService.UpdatePreferences(c.Request.Context(), email, req.Channels, req.WebhookURL)
+	if err != nil {
+		log.Println("Failed to update notification preferences in UpdatePreferences: ", err)
+		respondNotificationError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"preferences": dto.NewNotificationPreferencesResponse(*preferences)})
+}
+
+func respondNotificationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidNotificationID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+	case errors.Is(err, service.ErrNotificationNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+	case errors.Is(err, service.ErrUnknownCategory), errors.Is(err, service.ErrUnknownChannel),
+		errors.Is(err, service.ErrInvalidWebhookURL), errors.Is(err, service.ErrWebhookURLRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		respondServerError(c, err, "Internal server error")
+	}
+}
@@ -1,16 +1,21 @@
 package controller
 
 import (
+    "context"
+    "errors"
     "log"
     "net/http"
+    "strings"
 
     "github.com/gin-gonic/gin"
+    "github.com/liju-github/internal/dto"
     "github.com/liju-github/internal/model"
     "github.com/liju-github/internal/service"
 )
 
 type ProductController struct {
-    ProductService *service.ProductService
+    ProductService  *service.ProductService
+    FavoriteService *service.FavoriteService
 }
 
 func (ctrl *ProductController) AddProduct(c *gin.Context) {
@@ -21,6 +26,8 @@ func (ctrl *ProductController) AddProduct(c *gin.Context) {
         return
     }
 
+    product.NormalizeImages()
+
     // Validate the product fields
     if err := validate.Struct(product); err != nil {
         log.Println("Validation error in AddProduct: ", err)
@@ -44,9 +51,13 @@ func (ctrl *ProductController) AddProduct(c *gin.Context) {
 
     product.Email = email
 
-    if err := ctrl.ProductService.AddProduct(product); err != nil {
+    if err := ctrl.ProductService.AddProduct(c.Request.Context(), product); err != nil {
         log.Println("Failed to add product in AddProduct: ", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+        if errors.Is(err, service.ErrInvalidLocation) || errors.Is(err, service.ErrInvalidStatus) {
+            respondProductError(c, err)
+            return
+        }
+        respondServerError(c, err, "Failed to add product")
         return
     }
 
@@ -56,23 +67,349 @@ func (ctrl *ProductController) AddProduct(c *gin.Context) {
 func (ctrl *ProductController) GetProduct(c *gin.Context) {
     id := c.Param("id")
 
-    product, err := ctrl.ProductService.ProductRepo.GetProductByID(id)
+    product, err := ctrl.ProductService.GetProductByID(c.Request.Context(), id)
     if err != nil {
         log.Println("Failed to find product in GetProduct: ", err)
-        c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+        respondProductError(c, err)
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"product": product})
+    c.JSON(http.StatusOK, gin.H{"product": dto.NewProductResponse(*product)})
 }
 
+// GetAllProducts lists products. Supported query parameters are category,
+// state, pincode, min_price, max_price, q, sort (newest, price_asc,
+// price_desc), page and page_size. Passing the next_cursor of a previous
+// response as cursor returns the following page without skipping or
+// repeating products when new ones are listed in between. Every product comes
+// with the number of users who saved it and whether the caller did.
 func (ctrl *ProductController) GetAllProducts(c *gin.Context) {
-    products, err := ctrl.ProductService.ProductRepo.GetAllProducts()
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+    query, ok := bindProductQuery(c)
+    if !ok {
+        return
+    }
+
+    products, pagination, err := ctrl.ProductService.GetAllProducts(c.Request.Context(), query)
     if err != nil {
         log.Println("Failed to fetch products in GetAllProducts: ", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+        respondListError(c, err, "Failed to fetch products")
+        return
+    }
+
+    stats, err := ctrl.FavoriteService.Stats(c.Request.Context(), email, products)
+    if err != nil {
+        log.Println("Failed to fetch favorite stats in GetAllProducts: ", err)
+        respondServerError(c, err, "Failed to fetch products")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"products": dto.NewProductListings(products, stats), "pagination": pagination})
+}
+
+// SearchProducts runs a full-text search for q, ranked by relevance. It takes
+// the same filters and paging parameters as GetAllProducts, except sort and
+// cursor.
+func (ctrl *ProductController) SearchProducts(c *gin.Context) {
+    query, ok := bindProductQuery(c)
+    if !ok {
+        return
+    }
+
+    if strings.TrimSpace(query.Keyword) == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Search query q is required"})
+        return
+    }
+
+    results, pagination, err := ctrl.ProductService.SearchProducts(c.Request.Context(), query)
+    if err != nil {
+        log.Println("Failed to search products in SearchProducts: ", err)
+        respondServerError(c, err, "Failed to search products")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"results": dto.NewSearchResultResponses(results), "pagination": pagination})
+}
+
+// NearbyProducts lists products within radius_km (default 10) of lat and lng,
+// nearest first. The filters and paging parameters of GetAllProducts apply
+// too, except sort and cursor.
+func (ctrl *ProductController) NearbyProducts(c *gin.Context) {
+    var query model.NearbyQuery
+    if err := c.ShouldBindQuery(&query); err != nil {
+        log.Println("Error binding query in NearbyProducts: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+        return
+    }
+
+    if err := validate.Struct(query); err != nil {
+        log.Println("Validation error in NearbyProducts: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed: " + err.Error()})
+        return
+    }
+
+    products, pagination, err := ctrl.ProductService.NearbyProducts(c.Request.Context(), query)
+    if err != nil {
+        log.Println("Failed to fetch nearby products in NearbyProducts: ", err)
+        respondServerError(c, err, "Failed to fetch nearby products")
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"products": dto.NewNearbyProductResponses(products), "pagination": pagination})
+}
+
+// UpdateProduct handles both PUT and PATCH. A PUT replaces every editable
+// field, while a PATCH is applied on top of the stored listing. In both cases
+// the result has to pass the same validation rules as AddProduct.
+func (ctrl *ProductController) UpdateProduct(c *gin.Context) {
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+
+    existing, err := ctrl.ProductService.GetOwnedProduct(c.Request.Context(), c.Param("id"), email)
+    if err != nil {
+        log.Println("Failed to load product in UpdateProduct: ", err)
+        respondProductError(c, err)
+        return
+    }
+
+    var product model.Product
+    if c.Request.Method == http.MethodPatch {
+        product = *existing
+        // Copy the location so decoding the body cannot modify existing.
+        if existing.Location != nil {
+            location := *existing.Location
+            product.Location = &location
+        }
+        product.Images = append([]string(nil), existing.Images...)
+    }
+    if err := c.ShouldBindJSON(&product); err != nil {
+        log.Println("Error binding JSON in UpdateProduct: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+        return
+    }
+
+    // The identity and owner of a listing cannot be changed through the body.
+    product.ID = existing.ID
+    product.Email = existing.Email
+    product.NormalizeImages()
+
+    if err := validate.Struct(product); err != nil {
+        log.Println("Validation error in UpdateProduct: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed: " + err.Error()})
+        return
+    }
+
+    updated, err := ctrl.ProductService.UpdateProduct(c.Request.Context(), existing, product)
+    if err != nil {
+        log.Println("Failed to update product in UpdateProduct: ", err)
+        respondProductError(c, err)
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"products": products})
+    c.JSON(http.StatusOK, gin.H{"message": "Product updated successfully", "product": dto.NewProductResponse(*updated)})
+}
+
+// ChangeStatus returns a handler that performs a lifecycle action, such as
+// publishing a draft or marking a listing as sold, on the seller's product.
+func (ctrl *ProductController) ChangeStatus(action model.ProductAction) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        email, ok := sellerEmail(c)
+        if !ok {
+            return
+        }
+
+        product, err := ctrl.ProductService.ChangeProductStatus(c.Request.Context(), c.Param("id"), email, action)
+        if err != nil {
+            log.Printf("Failed to %s product in ChangeStatus: %v", action, err)
+            respondProductError(c, err)
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "Product status updated", "product": dto.NewProductResponse(*product)})
+    }
+}
+
+type ImageRequest struct {
+    URL string `json:"url" validate:"required,url"`
+}
+
+type ReorderImagesRequest struct {
+    Images []string `json:"images" validate:"required,min=1,dive,required,url"`
+}
+
+// AddImage appends an image URL to the gallery of the seller's product.
+func (ctrl *ProductController) AddImage(c *gin.Context) {
+    ctrl.changeImage(c, ctrl.ProductService.AddImage)
+}
+
+// SetCoverImage makes an image of the gallery the cover image.
+func (ctrl *ProductController) SetCoverImage(c *gin.Context) {
+    ctrl.changeImage(c, ctrl.ProductService.SetCoverImage)
+}
+
+// RemoveImage removes the image given by the url query parameter.
+func (ctrl *ProductController) RemoveImage(c *gin.Context) {
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+
+    url := c.Query("url")
+    if url == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Image url is required"})
+        return
+    }
+
+    images, err := ctrl.ProductService.RemoveImage(c.Request.Context(), c.Param("id"), email, url)
+    if err != nil {
+        log.Println("Failed to remove image in RemoveImage: ", err)
+        respondProductError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+// ReorderImages sets the order of the gallery. The first image becomes the
+// cover image.
+func (ctrl *ProductController) ReorderImages(c *gin.Context) {
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+
+    var req ReorderImagesRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        log.Println("Error binding JSON in ReorderImages: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+        return
+    }
+    if err := validate.Struct(req); err != nil {
+        log.Println("Validation error in ReorderImages: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed: " + err.Error()})
+        return
+    }
+
+    images, err := ctrl.ProductService.ReorderImages(c.Request.Context(), c.Param("id"), email, req.Images)
+    if err != nil {
+        log.Println("Failed to reorder images in ReorderImages: ", err)
+        respondProductError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+func (ctrl *ProductController) changeImage(c *gin.Context, change func(ctx context.Context, id, email, url string) ([]string, error)) {
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+
+    var req ImageRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        log.Println("Error binding image request: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input: " + err.Error()})
+        return
+    }
+    if err := validate.Struct(req); err != nil {
+        log.Println("Validation error in image request: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed: " + err.Error()})
+        return
+    }
+
+    images, err := change(c.Request.Context(), c.Param("id"), email, req.URL)
+    if err != nil {
+        log.Println("Failed to change product images: ", err)
+        respondProductError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"images": images})
+}
+
+func (ctrl *ProductController) DeleteProduct(c *gin.Context) {
+    email, ok := sellerEmail(c)
+    if !ok {
+        return
+    }
+
+    if err := ctrl.ProductService.DeleteProduct(c.Request.Context(), c.Param("id"), email); err != nil {
+        log.Println("Failed to delete product in DeleteProduct: ", err)
+        respondProductError(c, err)
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Product deleted successfully"})
+}
+
+// bindProductQuery reads and validates the listing filters from the query
+// string and writes an error response when they are invalid.
+func bindProductQuery(c *gin.Context) (model.ProductQuery, bool) {
+    var query model.ProductQuery
+    if err := c.ShouldBindQuery(&query); err != nil {
+        log.Println("Error binding product query: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+        return query, false
+    }
+
+    if err := validate.Struct(query); err != nil {
+        log.Println("Validation error in product query: ", err)
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed: " + err.Error()})
+        return query, false
+    }
+
+    if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "min_price cannot be greater than max_price"})
+        return query, false
+    }
+    return query, true
+}
+
+// sellerEmail reads the email stored by AuthMiddleware and writes an error
+// response when it is missing.
+func sellerEmail(c *gin.Context) (string, bool) {
+    userEmail, exists := c.Get("useremail")
+    if !exists {
+        log.Println("User email missing from context")
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+        return "", false
+    }
+
+    email, ok := userEmail.(string)
+    if !ok {
+        log.Println("Invalid user email type in context")
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+        return "", false
+    }
+    return email, true
+}
+
+func respondProductError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, service.ErrInvalidProductID):
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+    case errors.Is(err, service.ErrProductNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+    case errors.Is(err, service.ErrInvalidLocation):
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location coordinates"})
+    case errors.Is(err, service.ErrInvalidStatus):
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be draft or active"})
+    case errors.Is(err, service.ErrInvalidAction):
+        c.JSON(http.StatusConflict, gin.H{"error": "This action is not allowed in the product's current status"})
+    case errors.Is(err, service.ErrImageNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Image not found in the gallery"})
+    case errors.Is(err, service.ErrTooManyImages), errors.Is(err, service.ErrLastImage),
+        errors.Is(err, service.ErrInvalidOrder):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    case errors.Is(err, service.ErrProductNotOwned):
+        c.JSON(http.StatusForbidden, gin.H{"error": "You can only modify your own products"})
+    default:
+        respondServerError(c, err, "Internal server error")
+    }
 }
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/service"
+)
+
+// errRequestFailed is what WebSocket clients are told when something fails
+// on our side.
+var errRequestFailed = errors.New("request failed, try again later")
+
+// RealtimeController serves the WebSocket endpoint and handles what clients
+// send over it.
+type RealtimeController struct {
+	Gateway        *realtime.Gateway
+	ChatService    *service.ChatService
+	ProductService *service.ProductService
+	SessionService *service.SessionService
+}
+
+// Connect upgrades an authenticated request to a WebSocket that receives
+// the user's chat events and the status changes of the products it watches.
+// The socket is closed once its session is logged out or revoked.
+func (ctrl *RealtimeController) Connect(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	sessionID := c.GetString("sessionid")
+	checkSession := func(ctx context.Context) error {
+		err := ctrl.SessionService.ValidateSession(ctx, sessionID)
+		if err != nil && !errors.Is(err, service.ErrSessionRevoked) {
+			// The session may well be fine, so the socket stays open.
+			log.Printf("Failed to check the session of %s's WebSocket: %v", email, err)
+			return nil
+		}
+		return err
+	}
+	ctrl.Gateway.Serve(c.Writer, c.Request, email, ctrl, checkSession)
+}
+
+func (ctrl *RealtimeController) Typing(ctx context.Context, email, conversationID string) error {
+	return clientError(ctrl.ChatService.Typing(ctx, conversationID, email))
+}
+
+// WatchProduct lets users follow any listing they can see.
+func (ctrl *RealtimeController) WatchProduct(ctx context.Context, email, productID string) (string, error) {
+	product, err := ctrl.ProductService.GetProductByID(ctx, productID)
+	if err != nil {
+		return "", clientError(err)
+	}
+	return realtime.ProductTopic(product.ID.Hex()), nil
+}
+
+// clientError keeps the errors clients can act on and hides the others.
+func clientError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrInvalidConversationID), errors.Is(err, service.ErrConversationNotFound),
+		errors.Is(err, service.ErrNotParticipant), errors.Is(err, service.ErrConversationArchived),
+		errors.Is(err, service.ErrInvalidProductID), errors.Is(err, service.ErrProductNotFound):
+		return err
+	default:
+		log.Println("WebSocket request failed: ", err)
+		return errRequestFailed
+	}
+}
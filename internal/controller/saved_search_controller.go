@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/service"
+)
+
+type SavedSearchController struct {
+	SavedSearchService *service.SavedSearchService
+}
+
+// CreateSavedSearch saves a search. New listings that match it are sent to
+// the user right away, or in a daily digest when frequency is "daily".
+func (ctrl *SavedSearchController) CreateSavedSearch(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	req, ok := bindSavedSearch(c)
+	if !ok {
+		return
+	}
+
+	search, err := ctrl.SavedSearchService.Create(c.Request.Context(), email, req.SavedSearch())
+	if err != nil {
+		log.Println("Failed to save search in CreateSavedSearch: ", err)
+		respondSavedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_search": dto.NewSavedSearchResponse(*search)})
+}
+
+func (ctrl *SavedSearchController) ListSavedSearches(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	searches, err := ctrl.SavedSearchService.List(c.Request.Context(), email)
+	if err != nil {
+		log.Println("Failed to fetch saved searches in ListSavedSearches: ", err)
+		respondServerError(c, err, "Failed to fetch saved searches")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_searches": dto.NewSavedSearchResponses(searches)})
+}
+
+func (ctrl *SavedSearchController) GetSavedSearch(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	search, err := ctrl.SavedSearchService.Get(c.Request.Context(), c.Param("id"), email)
+	if err != nil {
+		log.Println("Failed to fetch saved search in GetSavedSearch: ", err)
+		respondSavedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_search": dto.NewSavedSearchResponse(*search)})
+}
+
+// UpdateSavedSearch replaces the name, filters and frequency of a saved
+// search.
+func (ctrl *SavedSearchController) UpdateSavedSearch(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+	req, ok := bindSavedSearch(c)
+	if !ok {
+		return
+	}
+
+	search, err := ctrl.SavedSearchService.Update(c.Request.Context(), c.Param("id"), email, req.SavedSearch())
+	if err != nil {
+		log.Println("Failed to update saved search in UpdateSavedSearch: ", err)
+		respondSavedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"saved_search": dto.NewSavedSearchResponse(*search)})
+}
+
+func (ctrl *SavedSearchController) DeleteSavedSearch(c *gin.Context) {
+	email, ok := sellerEmail(c)
+	if !ok {
+		return
+	}
+
+	if err := ctrl.SavedSearchService.Delete(c.Request.Context(), c.Param("id"), email); err != nil {
+		log.Println("Failed to delete saved search in DeleteSavedSearch: ", err)
+		respondSavedSearchError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Saved search deleted successfully"})
+}
+
+// bindSavedSearch reads and validates the body of a saved search request and
+// writes an error response when it is invalid.
+func bindSavedSearch(c *gin.Context) (dto.SavedSearchRequest, bool) {
+	var req dto.SavedSearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding saved search: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return req, false
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in saved search: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return req, false
+	}
+	return req, true
+}
+
+func respondSavedSearchError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidSavedSearchID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved search ID"})
+	case errors.Is(err, service.ErrSavedSearchNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+	case errors.Is(err, service.ErrEmptySearchName), errors.Is(err, service.ErrEmptySearch),
+		errors.Is(err, service.ErrInvalidPriceRange):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrTooManySearches):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondServerError(c, err, "Internal server error")
+	}
+}
@@ -1,13 +1,13 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"github.com/liju-github/internal/auth"
+	"github.com/liju-github/internal/dto"
 	"github.com/liju-github/internal/model"
 	"github.com/liju-github/internal/service"
 )
@@ -17,27 +17,32 @@ var validate = validator.New()
 type UserController struct {
 	UserService    *service.UserService
 	ProductService *service.ProductService
+	SessionService *service.SessionService
 }
 
 func (ctrl *UserController) Signup(c *gin.Context) {
-	var user model.User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	var req dto.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		log.Println("Error binding JSON in Signup: ", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
 		return
 	}
 
-	fmt.Println(user)
-
-	if err := validate.Struct(user); err != nil {
+	if err := validate.Struct(req); err != nil {
 		log.Println("Validation error in Signup: ", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
 		return
 	}
 
-	if err := ctrl.UserService.RegisterUser(user); err != nil {
+	err := ctrl.UserService.RegisterUser(c.Request.Context(), req.ToUser())
+	if errors.Is(err, service.ErrDuplicateEmail) {
+		log.Println("User registration refused: ", err)
+		c.JSON(http.StatusConflict, gin.H{"error": "A user with this email already exists"})
+		return
+	}
+	if err != nil {
 		log.Println("User registration failed: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user", "details": err.Error()})
+		respondServerError(c, err, "Failed to register user")
 		return
 	}
 
@@ -62,50 +67,146 @@ func (ctrl *UserController) Login(c *gin.Context) {
 		return
 	}
 
-	user, err := ctrl.UserService.Login(credentials.Email, credentials.Password)
-	if err != nil {
+	user, err := ctrl.UserService.Login(c.Request.Context(), credentials.Email, credentials.Password)
+	if errors.Is(err, service.ErrUserSuspended) {
+		log.Println("Login refused: ", err)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+		return
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
 		log.Println("Login failed: ", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
-
-	token, err := auth.GenerateJWT(user.Email)
 	if err != nil {
-		log.Println("JWT generation failed: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		log.Println("Login failed: ", err)
+		respondServerError(c, err, "Failed to log in")
 		return
 	}
 
-	products, err := ctrl.ProductService.GetAllProductsByUserEmail(user.Email)
+	products, next, err := ctrl.ProductService.GetAllProductsByUserEmail(c.Request.Context(), user.Email, model.CursorPage{})
 	if err != nil {
 		log.Println("Failed to fetch products for user: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products for the user"})
+		respondServerError(c, err, "Failed to fetch products for the user")
+		return
+	}
+
+	tokens, err := ctrl.SessionService.StartSession(c.Request.Context(), user)
+	if err != nil {
+		log.Println("Session creation failed: ", err)
+		respondServerError(c, err, "Failed to generate token")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user":     user,
-		"products": products,
-		"token":    token,
+		"user":          dto.NewUserResponse(*user),
+		"products":      dto.NewProductSummaries(products),
+		"next_cursor":   next,
+		"token":         tokens.AccessToken, // Kept for clients that predate refresh tokens
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token. The
+// old refresh token cannot be used again.
+func (ctrl *UserController) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Println("Error binding JSON in Refresh: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input, unable to parse request body"})
+		return
+	}
+	if err := validate.Struct(req); err != nil {
+		log.Println("Validation error in Refresh: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return
+	}
+
+	tokens, err := ctrl.SessionService.Refresh(c.Request.Context(), req.RefreshToken)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, tokens)
+	case errors.Is(err, service.ErrRefreshTokenReused):
+		log.Println("Refresh refused: ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, please log in again"})
+	case errors.Is(err, service.ErrInvalidRefreshToken), errors.Is(err, service.ErrSessionRevoked):
+		log.Println("Refresh refused: ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+	default:
+		log.Println("Refresh failed: ", err)
+		respondServerError(c, err, "Failed to refresh token")
+	}
+}
+
+// Logout revokes the current session, including its refresh tokens.
+func (ctrl *UserController) Logout(c *gin.Context) {
+	sessionID := c.GetString("sessionid")
+	if err := ctrl.SessionService.Logout(c.Request.Context(), sessionID); err != nil {
+		log.Println("Logout failed: ", err)
+		respondServerError(c, err, "Failed to log out")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
 func (ctrl *UserController) GetAllUsers(c *gin.Context) {
-	users, err := ctrl.UserService.AllUsers()
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
+	users, next, err := ctrl.UserService.AllUsers(c.Request.Context(), page)
 	if err != nil {
 		log.Println("Error fetching all users: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+		respondListError(c, err, "Failed to retrieve users")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"users": users})
+	c.JSON(http.StatusOK, gin.H{"users": dto.NewUserResponses(users), "next_cursor": next})
+}
+
+// bindCursorPage reads the cursor and limit query parameters and writes an
+// error response when they are invalid.
+func bindCursorPage(c *gin.Context) (model.CursorPage, bool) {
+	var page model.CursorPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		log.Println("Error binding cursor page: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters"})
+		return page, false
+	}
+	if err := validate.Struct(page); err != nil {
+		log.Println("Validation error in cursor page: ", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Validation failed", "details": err.Error()})
+		return page, false
+	}
+	return page, true
+}
+
+// respondListError reports a rejected cursor as a client error and anything
+// else as a server error.
+func respondListError(c *gin.Context, err error, message string) {
+	if errors.Is(err, service.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return
+	}
+	respondServerError(c, err, message)
 }
 
-type UserProfileResponse struct {
-	Name     string          `json:"name"`
-	ImageURL string          `json:"image_url"`
-	Email    string          `json:"email"`
-	Products []model.Product `json:"products"`
+// respondServerError reports an unexpected error. A database operation that
+// ran out of time is reported as 504 rather than 500.
+func respondServerError(c *gin.Context, err error, message string) {
+	if service.IsTimeout(err) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
 }
 
 func (ctrl *UserController) GetSellerProfile(c *gin.Context) {
@@ -115,25 +216,31 @@ func (ctrl *UserController) GetSellerProfile(c *gin.Context) {
         return
     }
 
-    sellerProfile, err := ctrl.UserService.GetUserByEmail(email)
-    if err != nil {
+    page, ok := bindCursorPage(c)
+    if !ok {
+        return
+    }
+
+    sellerProfile, err := ctrl.UserService.GetUserByEmail(c.Request.Context(), email)
+    if errors.Is(err, service.ErrUserNotFound) {
         c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
         return
     }
+    if err != nil {
+        log.Println("Failed to retrieve seller in GetSellerProfile: ", err)
+        respondServerError(c, err, "Failed to retrieve seller")
+        return
+    }
 
-    products, err := ctrl.ProductService.GetAllProductsByUserEmail(email)
+    // Other users only get to see the seller's active listings.
+    products, next, err := ctrl.ProductService.GetAllProductsByUserEmail(c.Request.Context(), email, page, model.StatusActive)
     if err != nil {
         log.Println("Failed to retrieve products for user:", err)
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+        respondListError(c, err, "Failed to retrieve products")
         return
     }
 
-    response := UserProfileResponse{
-        Name:     sellerProfile.Name,
-        ImageURL: sellerProfile.ImageURL,
-        Email:    sellerProfile.Email,
-        Products: products,
-    }
+    response := dto.NewProfileResponse(*sellerProfile, products, next)
 
     c.JSON(http.StatusOK, gin.H{
         "data": response,
@@ -147,29 +254,29 @@ func (ctrl *UserController) GetProfile(c *gin.Context) {
 		return
 	}
 
+	page, ok := bindCursorPage(c)
+	if !ok {
+		return
+	}
+
 	// Fetch the user by email
-	user, err := ctrl.UserService.GetUserByEmail(userEmail.(string))
+	user, err := ctrl.UserService.GetUserByEmail(c.Request.Context(), userEmail.(string))
 	if err != nil {
 		log.Println("Failed to retrieve user by email:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		respondServerError(c, err, "Failed to retrieve user")
 		return
 	}
 
-	// Fetch the total number of products associated with the user
-	products, err := ctrl.ProductService.GetAllProductsByUserEmail(user.Email)
+	// Fetch a page of the products associated with the user, in every status
+	products, next, err := ctrl.ProductService.GetAllProductsByUserEmail(c.Request.Context(), user.Email, page)
 	if err != nil {
 		log.Println("Failed to retrieve products for user:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve products"})
+		respondListError(c, err, "Failed to retrieve products")
 		return
 	}
 
 	// Create the response struct
-	response := UserProfileResponse{
-		Name:     user.Name,
-		ImageURL: user.ImageURL,
-		Email:    user.Email,
-		Products: products,
-	}
+	response := dto.NewProfileResponse(*user, products, next)
 
 	c.JSON(http.StatusOK, gin.H{"profile": response})
 }
@@ -210,7 +317,8 @@ func (controller *UserController) UpdateImage(c *gin.Context) {
 
     // Call the service method, passing the Gin context as context.Context
     if err := controller.UserService.UpdateUserImage(c.Request.Context(), email, req.ImageUrl); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        log.Println("Failed to update user image in UpdateImage: ", err)
+        respondServerError(c, err, "Failed to update user image")
         return
     }
 
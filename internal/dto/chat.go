@@ -0,0 +1,94 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// StartConversationRequest is the body of POST /conversations. Message is
+// optional and sent as the first message when given.
+type StartConversationRequest struct {
+	ProductID string `json:"product_id" validate:"required"`
+	Message   string `json:"message"`
+}
+
+// SendMessageRequest is the body of POST /conversations/:id/messages.
+type SendMessageRequest struct {
+	Body string `json:"body" validate:"required"`
+}
+
+// ConversationResponse is a conversation as returned by the API.
+type ConversationResponse struct {
+	ID             string     `json:"id"`
+	ProductID      string     `json:"product_id"`
+	ProductName    string     `json:"product_name"`
+	BuyerEmail     string     `json:"buyer_email"`
+	SellerEmail    string     `json:"seller_email"`
+	LastMessage    string     `json:"last_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastActivityAt time.Time  `json:"last_activity_at"`
+	ArchivedAt     *time.Time `json:"archived_at,omitempty"`
+	ArchivedReason string     `json:"archived_reason,omitempty"`
+}
+
+func NewConversationResponse(conversation model.Conversation) ConversationResponse {
+	return ConversationResponse{
+		ID:             conversation.ID.Hex(),
+		ProductID:      conversation.ProductID.Hex(),
+		ProductName:    conversation.ProductName,
+		BuyerEmail:     conversation.BuyerEmail,
+		SellerEmail:    conversation.SellerEmail,
+		LastMessage:    conversation.LastMessage,
+		CreatedAt:      conversation.CreatedAt,
+		LastActivityAt: conversation.LastActivityAt,
+		ArchivedAt:     conversation.ArchivedAt,
+		ArchivedReason: conversation.ArchivedReason,
+	}
+}
+
+// InboxEntryResponse is a conversation in the inbox with its unread count.
+type InboxEntryResponse struct {
+	ConversationResponse
+	UnreadCount int64 `json:"unread_count"`
+}
+
+func NewInboxResponses(entries []model.InboxEntry) []InboxEntryResponse {
+	responses := make([]InboxEntryResponse, len(entries))
+	for i, entry := range entries {
+		responses[i] = InboxEntryResponse{
+			ConversationResponse: NewConversationResponse(entry.Conversation),
+			UnreadCount:          entry.UnreadCount,
+		}
+	}
+	return responses
+}
+
+// MessageResponse is a chat message as returned by the API.
+type MessageResponse struct {
+	ID             string     `json:"id"`
+	ConversationID string     `json:"conversation_id"`
+	SenderEmail    string     `json:"sender_email"`
+	Body           string     `json:"body"`
+	SentAt         time.Time  `json:"sent_at"`
+	ReadAt         *time.Time `json:"read_at,omitempty"`
+}
+
+func NewMessageResponse(message model.Message) MessageResponse {
+	return MessageResponse{
+		ID:             message.ID.Hex(),
+		ConversationID: message.ConversationID.Hex(),
+		SenderEmail:    message.SenderEmail,
+		Body:           message.Body,
+		SentAt:         message.SentAt,
+		ReadAt:         message.ReadAt,
+	}
+}
+
+func NewMessageResponses(messages []model.Message) []MessageResponse {
+	responses := make([]MessageResponse, len(messages))
+	for i, message := range messages {
+		responses[i] = NewMessageResponse(message)
+	}
+	return responses
+}
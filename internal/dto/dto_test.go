@@ -0,0 +1,86 @@
+package dto
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const passwordHash = "$2a$14$abcdefghijklmnopqrstuuvwxyz0123456789ABCDEFGHIJKLMNOPQ"
+
+func TestResponsesNeverContainPasswords(t *testing.T) {
+	user := model.User{
+		ID:       primitive.NewObjectID(),
+		Name:     "Asha",
+		Email:    "asha@example.com",
+		Password: passwordHash,
+		ImageURL: "https://example.com/asha.png",
+		Audit:    model.NewAudit("asha@example.com", time.Now()),
+	}
+	product := model.Product{
+		ID:       primitive.NewObjectID(),
+		Email:    user.Email,
+		Name:     "Bicycle",
+		ImageURL: "https://example.com/bike.png",
+		Images:   []string{"https://example.com/bike.png"},
+		Status:   model.StatusActive,
+	}
+
+	responses := map[string]interface{}{
+		"user":     NewUserResponse(user),
+		"users":    NewUserResponses([]model.User{user}),
+		"profile":  NewProfileResponse(user, []model.Product{product}, "cursor"),
+		"product":  NewProductResponse(product),
+		"products": NewProductSummaries([]model.Product{product}),
+		"search":   NewSearchResultResponses([]model.ProductSearchResult{{Product: product}}),
+		"nearby":   NewNearbyProductResponses([]model.NearbyProduct{{Product: product}}),
+		// The domain model itself must not leak the hash either, in case it
+		// is ever serialized by mistake.
+		"model": user,
+	}
+
+	for name, response := range responses {
+		data, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		if strings.Contains(string(data), passwordHash) {
+			t.Errorf("%s: response contains the password hash: %s", name, data)
+		}
+
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("%s: unmarshal: %v", name, err)
+		}
+		if path := findPasswordKey(decoded, name); path != "" {
+			t.Errorf("response has a password key at %s", path)
+		}
+	}
+}
+
+// findPasswordKey returns the path of the first object key that mentions a
+// password, or "" when there is none.
+func findPasswordKey(value interface{}, path string) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if strings.Contains(strings.ToLower(key), "password") {
+				return path + "." + key
+			}
+			if found := findPasswordKey(child, path+"."+key); found != "" {
+				return found
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if found := findPasswordKey(child, path+"[]"); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FavoriteResponse is a saved listing with its current status and price.
+type FavoriteResponse struct {
+	ProductSummary
+	FavoritedAt time.Time `json:"favorited_at"`
+}
+
+func NewFavoriteResponses(favorites []model.FavoriteProduct) []FavoriteResponse {
+	responses := make([]FavoriteResponse, len(favorites))
+	for i, favorite := range favorites {
+		responses[i] = FavoriteResponse{
+			ProductSummary: NewProductSummary(favorite.Product),
+			FavoritedAt:    favorite.FavoritedAt,
+		}
+	}
+	return responses
+}
+
+// ProductListing is a product in /getproducts with how many users saved it
+// and whether the user asking did.
+type ProductListing struct {
+	ProductSummary
+	FavoriteCount int64 `json:"favorite_count"`
+	IsFavorite    bool  `json:"is_favorite"`
+}
+
+func NewProductListings(products []model.Product, stats map[primitive.ObjectID]model.FavoriteStats) []ProductListing {
+	listings := make([]ProductListing, len(products))
+	for i, product := range products {
+		listings[i] = ProductListing{
+			ProductSummary: NewProductSummary(product),
+			FavoriteCount:  stats[product.ID].Count,
+			IsFavorite:     stats[product.ID].Favorited,
+		}
+	}
+	return listings
+}
@@ -0,0 +1,62 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// NotificationResponse is a notification as returned by the API and pushed
+// over WebSockets.
+type NotificationResponse struct {
+	ID        string                     `json:"id"`
+	Category  model.NotificationCategory `json:"category"`
+	Subject   string                     `json:"subject"`
+	Message   string                     `json:"message"`
+	Link      string                     `json:"link,omitempty"`
+	CreatedAt time.Time                  `json:"created_at"`
+	ReadAt    *time.Time                 `json:"read_at,omitempty"`
+}
+
+func NewNotificationResponse(notification model.Notification) NotificationResponse {
+	return NotificationResponse{
+		ID:        notification.ID.Hex(),
+		Category:  notification.Category,
+		Subject:   notification.Subject,
+		Message:   notification.Message,
+		Link:      notification.Link,
+		CreatedAt: notification.CreatedAt,
+		ReadAt:    notification.ReadAt,
+	}
+}
+
+func NewNotificationResponses(notifications []model.Notification) []NotificationResponse {
+	responses := make([]NotificationResponse, len(notifications))
+	for i, notification := range notifications {
+		responses[i] = NewNotificationResponse(notification)
+	}
+	return responses
+}
+
+// NotificationPreferencesRequest is the body of PUT /notifications/preferences.
+// Channels replaces the channels of the categories it names; the others keep
+// their current channels. An empty list turns a category off, except for
+// moderation notices, which are always kept in-app.
+type NotificationPreferencesRequest struct {
+	Channels   map[model.NotificationCategory][]model.NotificationChannel `json:"channels"`
+	WebhookURL *string                                                    `json:"webhook_url" validate:"omitempty,max=2048"`
+}
+
+// NotificationPreferencesResponse lists the channels of every category.
+type NotificationPreferencesResponse struct {
+	Channels   map[model.NotificationCategory][]model.NotificationChannel `json:"channels"`
+	WebhookURL string                                                     `json:"webhook_url,omitempty"`
+}
+
+func NewNotificationPreferencesResponse(preferences model.NotificationPreferences) NotificationPreferencesResponse {
+	channels := make(map[model.NotificationCategory][]model.NotificationChannel, len(model.NotificationCategories))
+	for _, category := range model.NotificationCategories {
+		channels[category] = append([]model.NotificationChannel{}, preferences.ChannelsFor(category)...)
+	}
+	return NotificationPreferencesResponse{Channels: channels, WebhookURL: preferences.WebhookURL}
+}
@@ -0,0 +1,105 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// ProductSummary is a product as shown in listings: only the cover image,
+// without the gallery.
+type ProductSummary struct {
+	ID          string              `json:"id"`
+	Email       string              `json:"email"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Category    string              `json:"category"`
+	Price       float64             `json:"price"`
+	ImageURL    string              `json:"image_url"`
+	Address     string              `json:"address"`
+	State       string              `json:"state"`
+	Pincode     string              `json:"pincode"`
+	Location    *model.GeoPoint     `json:"location,omitempty"`
+	Status      model.ProductStatus `json:"status"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+	ExpiresAt   *time.Time          `json:"expires_at,omitempty"`
+}
+
+func NewProductSummary(product model.Product) ProductSummary {
+	return ProductSummary{
+		ID:          product.ID.Hex(),
+		Email:       product.Email,
+		Name:        product.Name,
+		Description: product.Description,
+		Category:    product.Category,
+		Price:       product.Price,
+		ImageURL:    product.ImageURL,
+		Address:     product.Address,
+		State:       product.State,
+		Pincode:     product.Pincode,
+		Location:    product.Location,
+		Status:      product.Status.OrDefault(),
+		CreatedAt:   product.CreatedAt,
+		UpdatedAt:   product.UpdatedAt,
+		ExpiresAt:   product.ExpiresAt,
+	}
+}
+
+func NewProductSummaries(products []model.Product) []ProductSummary {
+	summaries := make([]ProductSummary, len(products))
+	for i, product := range products {
+		summaries[i] = NewProductSummary(product)
+	}
+	return summaries
+}
+
+// ProductResponse is a single product with its full image gallery.
+type ProductResponse struct {
+	ProductSummary
+	Images []string `json:"images"`
+}
+
+func NewProductResponse(product model.Product) ProductResponse {
+	product.NormalizeImages()
+	return ProductResponse{
+		ProductSummary: NewProductSummary(product),
+		Images:         product.Images,
+	}
+}
+
+// SearchResultResponse is a full-text search hit.
+type SearchResultResponse struct {
+	ProductSummary
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+func NewSearchResultResponses(results []model.ProductSearchResult) []SearchResultResponse {
+	responses := make([]SearchResultResponse, len(results))
+	for i, result := range results {
+		responses[i] = SearchResultResponse{
+			ProductSummary: NewProductSummary(result.Product),
+			Score:          result.Score,
+			Snippet:        result.Snippet,
+		}
+	}
+	return responses
+}
+
+// NearbyProductResponse is a distance search hit.
+type NearbyProductResponse struct {
+	ProductSummary
+	DistanceKm float64 `json:"distance_km"`
+}
+
+func NewNearbyProductResponses(products []model.NearbyProduct) []NearbyProductResponse {
+	responses := make([]NearbyProductResponse, len(products))
+	for i, product := range products {
+		responses[i] = NearbyProductResponse{
+			ProductSummary: NewProductSummary(product.Product),
+			DistanceKm:     product.DistanceKm,
+		}
+	}
+	return responses
+}
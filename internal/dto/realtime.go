@@ -0,0 +1,53 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// TypingEvent tells a participant that the other one is typing.
+type TypingEvent struct {
+	ConversationID string `json:"conversation_id"`
+	Email          string `json:"email"`
+}
+
+// ReadReceipt tells a sender that their messages up to ReadAt were read.
+type ReadReceipt struct {
+	ConversationID string    `json:"conversation_id"`
+	Reader         string    `json:"reader"`
+	ReadAt         time.Time `json:"read_at"`
+}
+
+// ProductStatusEvent tells the users watching a product that its status
+// changed.
+type ProductStatusEvent struct {
+	ProductID      string              `json:"product_id"`
+	Name           string              `json:"name"`
+	Status         model.ProductStatus `json:"status"`
+	PreviousStatus model.ProductStatus `json:"previous_status,omitempty"`
+}
+
+// ProductDeletedEvent tells the users watching a product that it is gone.
+type ProductDeletedEvent struct {
+	ProductID string `json:"product_id"`
+}
+
+// FavoriteAlert tells a user that a listing they saved got cheaper or was
+// sold. PreviousPrice is only set on price drops.
+type FavoriteAlert struct {
+	ProductID     string              `json:"product_id"`
+	Name          string              `json:"name"`
+	Reason        string              `json:"reason"`
+	Status        model.ProductStatus `json:"status"`
+	Price         float64             `json:"price"`
+	PreviousPrice float64             `json:"previous_price,omitempty"`
+}
+
+// SavedSearchMatch tells a user about new listings matching one of their
+// saved searches: a single listing for instant alerts, several in a digest.
+type SavedSearchMatch struct {
+	SearchID   string           `json:"search_id"`
+	SearchName string           `json:"search_name"`
+	Products   []ProductSummary `json:"products"`
+}
@@ -0,0 +1,79 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// SavedSearchRequest is the body of POST /saved-searches and
+// PUT /saved-searches/:id. The filters mean the same as the query parameters
+// of /getproducts, and at least one of them is required.
+type SavedSearchRequest struct {
+	Name      string               `json:"name" validate:"required,max=100"`
+	Category  string               `json:"category" validate:"max=100"`
+	State     string               `json:"state" validate:"max=100"`
+	Pincode   string               `json:"pincode" validate:"omitempty,len=6"`
+	MinPrice  *float64             `json:"min_price" validate:"omitempty,gte=0"`
+	MaxPrice  *float64             `json:"max_price" validate:"omitempty,gte=0"`
+	Keyword   string               `json:"q" validate:"max=200"`
+	Frequency model.AlertFrequency `json:"frequency" validate:"omitempty,oneof=instant daily"`
+}
+
+func (req SavedSearchRequest) SavedSearch() model.SavedSearch {
+	return model.SavedSearch{
+		Name: req.Name,
+		Filters: model.SearchFilters{
+			Category: req.Category,
+			State:    req.State,
+			Pincode:  req.Pincode,
+			MinPrice: req.MinPrice,
+			MaxPrice: req.MaxPrice,
+			Keyword:  req.Keyword,
+		},
+		Frequency: req.Frequency,
+	}
+}
+
+// SavedSearchResponse is a saved search as returned by the API.
+type SavedSearchResponse struct {
+	ID           string               `json:"id"`
+	Name         string               `json:"name"`
+	Category     string               `json:"category,omitempty"`
+	State        string               `json:"state,omitempty"`
+	Pincode      string               `json:"pincode,omitempty"`
+	MinPrice     *float64             `json:"min_price,omitempty"`
+	MaxPrice     *float64             `json:"max_price,omitempty"`
+	Keyword      string               `json:"q,omitempty"`
+	Frequency    model.AlertFrequency `json:"frequency"`
+	CreatedAt    time.Time            `json:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at"`
+	PendingCount int                  `json:"pending_matches"`
+	LastDigestAt *time.Time           `json:"last_digest_at,omitempty"`
+}
+
+func NewSavedSearchResponse(search model.SavedSearch) SavedSearchResponse {
+	return SavedSearchResponse{
+		ID:           search.ID.Hex(),
+		Name:         search.Name,
+		Category:     search.Filters.Category,
+		State:        search.Filters.State,
+		Pincode:      search.Filters.Pincode,
+		MinPrice:     search.Filters.MinPrice,
+		MaxPrice:     search.Filters.MaxPrice,
+		Keyword:      search.Filters.Keyword,
+		Frequency:    search.Frequency,
+		CreatedAt:    search.CreatedAt,
+		UpdatedAt:    search.UpdatedAt,
+		PendingCount: len(search.PendingMatches),
+		LastDigestAt: search.LastDigestAt,
+	}
+}
+
+func NewSavedSearchResponses(searches []model.SavedSearch) []SavedSearchResponse {
+	responses := make([]SavedSearchResponse, len(searches))
+	for i, search := range searches {
+		responses[i] = NewSavedSearchResponse(search)
+	}
+	return responses
+}
@@ -0,0 +1,77 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+// SignupRequest is the body of POST /signup.
+type SignupRequest struct {
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	ImageURL string `json:"image_url"`
+}
+
+func (r SignupRequest) ToUser() model.User {
+	return model.User{
+		Name:     r.Name,
+		Email:    r.Email,
+		Password: r.Password,
+		ImageURL: r.ImageURL,
+	}
+}
+
+// UserResponse is a user as returned by the API. It never carries the
+// password hash.
+type UserResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	ImageURL  string     `json:"image_url"`
+	Role      model.Role `json:"role"`
+	Suspended bool       `json:"suspended"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+func NewUserResponse(user model.User) UserResponse {
+	return UserResponse{
+		ID:        user.ID.Hex(),
+		Name:      user.Name,
+		Email:     user.Email,
+		ImageURL:  user.ImageURL,
+		Role:      user.Role.OrDefault(),
+		Suspended: user.Suspended,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
+}
+
+func NewUserResponses(users []model.User) []UserResponse {
+	responses := make([]UserResponse, len(users))
+	for i, user := range users {
+		responses[i] = NewUserResponse(user)
+	}
+	return responses
+}
+
+// ProfileResponse is a user's public profile with a page of their listings.
+type ProfileResponse struct {
+	Name       string           `json:"name"`
+	ImageURL   string           `json:"image_url"`
+	Email      string           `json:"email"`
+	Products   []ProductSummary `json:"products"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func NewProfileResponse(user model.User, products []model.Product, nextCursor string) ProfileResponse {
+	return ProfileResponse{
+		Name:       user.Name,
+		ImageURL:   user.ImageURL,
+		Email:      user.Email,
+		Products:   NewProductSummaries(products),
+		NextCursor: nextCursor,
+	}
+}
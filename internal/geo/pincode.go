@@ -0,0 +1,51 @@
+package geo
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// pincodes.csv maps a pincode to the approximate centre of its delivery area.
+// It only ships with a small set of pincodes; a fuller dataset can replace it
+// as long as it keeps the pincode,latitude,longitude,place columns.
+//
+//go:embed pincodes.csv
+var pincodeData string
+
+type Coordinates struct {
+	Latitude  float64
+	Longitude float64
+}
+
+var pincodes = loadPincodes(pincodeData)
+
+// LookupPincode returns the coordinates of a pincode from the bundled dataset.
+func LookupPincode(pincode string) (Coordinates, bool) {
+	coordinates, ok := pincodes[strings.TrimSpace(pincode)]
+	return coordinates, ok
+}
+
+func loadPincodes(data string) map[string]Coordinates {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		log.Fatalf("Failed to read bundled pincode data: %v", err)
+	}
+
+	result := make(map[string]Coordinates, len(records))
+	for i, record := range records {
+		if i == 0 {
+			continue // header
+		}
+		lat, latErr := strconv.ParseFloat(record[1], 64)
+		lng, lngErr := strconv.ParseFloat(record[2], 64)
+		if latErr != nil || lngErr != nil {
+			log.Printf("Skipping invalid pincode row %d: %v", i+1, record)
+			continue
+		}
+		result[record[0]] = Coordinates{Latitude: lat, Longitude: lng}
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	ThumbnailSize = 320
+	jpegQuality   = 85
+	// maxPixels stops small files that decode into huge images from using
+	// up memory.
+	maxPixels = 40_000_000
+)
+
+var (
+	ErrUnsupportedType = errors.New("only JPEG and PNG images are supported")
+	ErrInvalidImage    = errors.New("file is not a valid image")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+// Processed is an uploaded image after it has been cleaned up. Data and
+// Thumbnail are encoded in ContentType.
+type Processed struct {
+	ContentType string
+	Extension   string
+	Data        []byte
+	Thumbnail   []byte
+}
+
+// Process checks that data is a JPEG or PNG image, judged by its content and
+// not by any client supplied type, and re-encodes it. Re-encoding drops EXIF
+// and any other metadata; the EXIF orientation of a JPEG is applied to the
+// pixels first so the image still shows the right way up. It also produces a
+// thumbnail that fits in ThumbnailSize x ThumbnailSize.
+func Process(data []byte) (*Processed, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+
+	processed := &Processed{ContentType: contentType}
+	encode := func(w io.Writer, img image.Image) error {
+		return png.Encode(w, img)
+	}
+	processed.Extension = ".png"
+	if contentType == "image/jpeg" {
+		encode = func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+		}
+		processed.Extension = ".jpg"
+	}
+
+	var full, thumb bytes.Buffer
+	if err := encode(&full, img); err != nil {
+		return nil, err
+	}
+	if err := encode(&thumb, Fit(img, ThumbnailSize, ThumbnailSize)); err != nil {
+		return nil, err
+	}
+	processed.Data = full.Bytes()
+	processed.Thumbnail = thumb.Bytes()
+	return processed, nil
+}
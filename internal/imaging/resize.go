@@ -0,0 +1,55 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Fit scales img down, keeping its aspect ratio, so that it fits in maxWidth
+// x maxHeight. Images that already fit are returned unchanged. Every target
+// pixel is the average of the source pixels it covers, which keeps
+// thumbnails smooth without an external imaging library.
+func Fit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	targetWidth, targetHeight := maxWidth, height*maxWidth/width
+	if targetHeight > maxHeight {
+		targetWidth, targetHeight = width*maxHeight/height, maxHeight
+	}
+	if targetWidth < 1 {
+		targetWidth = 1
+	}
+	if targetHeight < 1 {
+		targetHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := bounds.Min.Y + y*height/targetHeight
+		y1 := bounds.Min.Y + (y+1)*height/targetHeight
+		for x := 0; x < targetWidth; x++ {
+			x0 := bounds.Min.X + x*width/targetWidth
+			x1 := bounds.Min.X + (x+1)*width/targetWidth
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
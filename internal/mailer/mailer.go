@@ -0,0 +1,21 @@
+// Package mailer sends plain text emails.
+package mailer
+
+import (
+	"context"
+	"log"
+)
+
+// Mailer sends an email to a single recipient.
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// Log writes emails to the server log instead of sending them. It is used
+// when no SMTP server is configured.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("Email to %s: %s: %s", to, subject, body)
+	return nil
+}
@@ -1,23 +1,19 @@
 package middleware
 
 import (
-	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	"github.com/liju-github/internal/auth"
 	"github.com/liju-github/internal/repository"
+	"github.com/liju-github/internal/service"
 )
 
-func AuthMiddleware(repo repository.UserRepository) gin.HandlerFunc {
+func AuthMiddleware(repo repository.UserRepository, sessions *service.SessionService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
-		// Log the authHeader to see what we're getting
-		fmt.Println("Authorization Header:", authHeader)
-
 		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization token required"})
 			c.Abort()
@@ -26,33 +22,68 @@ func AuthMiddleware(repo repository.UserRepository) gin.HandlerFunc {
 
 		// Extract the token after "Bearer " and ensure there are no leading/trailing spaces
 		tokenString := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
-		
-		// Log the token string after trimming to verify
-		fmt.Println("Extracted Token:", tokenString)
-
-		claims := &auth.Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return auth.JwtKey, nil
-		})
 
-		if err != nil || !token.Valid {
-			fmt.Println("Invalid token:", err.Error())
+		claims, err := sessions.ParseAccessToken(tokenString)
+		if err != nil {
+			log.Printf("Invalid token in AuthMiddleware: %v", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		// Tokens of a session that was logged out or revoked are refused
+		// even if they have not expired yet.
+		if err := sessions.ValidateSession(c.Request.Context(), claims.SessionID); err != nil {
+			log.Printf("Session rejected in AuthMiddleware: %v", err)
+			if service.IsTimeout(err) {
+				c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+
 		// Check if the user exists in the database
 		userEmail := claims.UserEmail
-		user, err := repo.GetUserByEmail(userEmail)
+		user, err := repo.GetUserByEmail(c.Request.Context(), userEmail)
+		if service.IsTimeout(err) {
+			c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+			c.Abort()
+			return
+		}
 		if err != nil || user == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "User does not exist"})
 			c.Abort()
 			return
 		}
 
-		// Store the user email in the context
+		if user.Suspended {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account suspended"})
+			c.Abort()
+			return
+		}
+
+		// Store the user email and role in the context. The role comes from
+		// the database so that role changes apply to tokens already issued.
 		c.Set("useremail", userEmail)
+		c.Set("role", user.Role.OrDefault())
+		c.Set("sessionid", claims.SessionID)
 		c.Next() // Proceed to the next handler
 	}
 }
+
+// TokenFromQuery lets clients that cannot set headers, such as browsers
+// opening a WebSocket, pass their access token as the param query parameter.
+// It must run before AuthMiddleware and only on the routes that need it, as
+// URLs end up in logs more easily than headers; the access log hides param
+// with RedactedLogger.
+func TokenFromQuery(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query(param); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
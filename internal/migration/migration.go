@@ -0,0 +1,68 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a one-off change to the data in the database. Migrations must
+// be safe to run again, because two servers starting at the same time may
+// both apply one before either records it.
+type Migration struct {
+	ID          string
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database, settings Settings) error
+}
+
+// Settings carries the configuration that some migrations depend on.
+type Settings struct {
+	ListingTTL        time.Duration // How long a listing stays active; 0 disables expiry
+	ListingWarnBefore time.Duration // How long before expiry the seller is warned
+}
+
+// ErrDeferred is returned by a migration that cannot be applied with the
+// current settings. It is not recorded, so it is tried again on the next start.
+var ErrDeferred = errors.New("migration deferred")
+
+type appliedMigration struct {
+	ID        string    `bson:"_id"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Run applies, in order, the migrations that are not yet recorded in the
+// migrations collection.
+func Run(ctx context.Context, db *mongo.Database, migrations []Migration, settings Settings) error {
+	collection := db.Collection("migrations")
+
+	for _, m := range migrations {
+		err := collection.FindOne(ctx, bson.M{"_id": m.ID}).Err()
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("checking migration %s: %w", m.ID, err)
+		}
+
+		log.Printf("Applying migration %s: %s", m.ID, m.Description)
+		err = m.Up(ctx, db, settings)
+		if errors.Is(err, ErrDeferred) {
+			log.Printf("Deferred migration %s", m.ID)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.ID, err)
+		}
+
+		_, err = collection.InsertOne(ctx, appliedMigration{ID: m.ID, AppliedAt: time.Now()})
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("recording migration %s: %w", m.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/liju-github/internal/geo"
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// All lists every migration in the order it must be applied. New migrations
+// are appended; applied ones are never edited or removed.
+var All = []Migration{
+	{
+		ID:          "0001_backfill_audit_fields",
+		Description: "fill in created/updated metadata on existing users and products",
+		Up: func(ctx context.Context, db *mongo.Database, _ Settings) error {
+			for _, name := range []string{"users", "products"} {
+				if err := backfillAudit(ctx, db.Collection(name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		ID:          "0002_backfill_listing_expiry",
+		Description: "give active listings created before expiry existed an expiry date",
+		Up:          backfillListingExpiry,
+	},
+	{
+		ID:          "0003_backfill_product_location",
+		Description: "resolve the location of products that only have a pincode",
+		Up: func(ctx context.Context, db *mongo.Database, _ Settings) error {
+			return backfillLocation(ctx, db.Collection("products"))
+		},
+	},
+	{
+		ID:          "0004_check_duplicate_emails",
+		Description: "make sure no two users share an email before it becomes unique",
+		Up: func(ctx context.Context, db *mongo.Database, _ Settings) error {
+			return checkDuplicateEmails(ctx, db.Collection("users"))
+		},
+	},
+}
+
+// backfillAudit sets any missing audit field. The creation time comes from
+// the ObjectID, and the author is the document's owner email, falling back to
+// the system actor.
+func backfillAudit(ctx context.Context, collection *mongo.Collection) error {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{"$exists": false}},
+		bson.M{"updated_at": bson.M{"$exists": false}},
+		bson.M{"created_by": bson.M{"$exists": false}},
+		bson.M{"updated_by": bson.M{"$exists": false}},
+	}}
+
+	createdAt := bson.M{"$ifNull": bson.A{"$created_at", bson.M{"$toDate": "$_id"}}}
+	owner := bson.M{"$ifNull": bson.A{"$email", model.SystemActor}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"created_at": createdAt,
+			"updated_at": bson.M{"$ifNull": bson.A{"$updated_at", createdAt}},
+			"created_by": bson.M{"$ifNull": bson.A{"$created_by", owner}},
+			"updated_by": bson.M{"$ifNull": bson.A{"$updated_by", owner}},
+		}}},
+	}
+
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfilled audit fields on %d %s", result.ModifiedCount, collection.Name())
+	return nil
+}
+
+// backfillListingExpiry sets expires_at on active products that have none, to
+// their creation time plus the listing TTL. Listings that would already be
+// past that date expire once the seller has had the usual warning instead of
+// on the next expiry run. It is deferred while expiry is disabled, so the
+// listings still get a date once a TTL is configured.
+func backfillListingExpiry(ctx context.Context, db *mongo.Database, settings Settings) error {
+	if settings.ListingTTL <= 0 {
+		return ErrDeferred
+	}
+
+	filter := bson.M{
+		"expires_at": bson.M{"$exists": false},
+		"status":     bson.M{"$in": bson.A{model.StatusActive, nil}},
+	}
+
+	createdAt := bson.M{"$ifNull": bson.A{"$created_at", bson.M{"$toDate": "$_id"}}}
+	earliest := time.Now().Add(settings.ListingWarnBefore)
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"expires_at": bson.M{"$max": bson.A{
+				bson.M{"$add": bson.A{createdAt, settings.ListingTTL.Milliseconds()}},
+				earliest,
+			}},
+		}}},
+	}
+
+	result, err := db.Collection("products").UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfilled expiry on %d products", result.ModifiedCount)
+	return nil
+}
+
+// backfillLocation sets the location of products that have none from their
+// pincode, the same way new listings are resolved. Products whose pincode is
+// unknown are left without a location.
+func backfillLocation(ctx context.Context, collection *mongo.Collection) error {
+	missing := bson.M{
+		"location": bson.M{"$exists": false},
+		"pincode":  bson.M{"$nin": bson.A{"", nil}},
+	}
+	pincodes, err := collection.Distinct(ctx, "pincode", missing)
+	if err != nil {
+		return err
+	}
+
+	var updated, unknown int64
+	for _, value := range pincodes {
+		pincode, _ := value.(string)
+		coordinates, ok := geo.LookupPincode(pincode)
+		if !ok {
+			unknown++
+			continue
+		}
+
+		filter := bson.M{"location": bson.M{"$exists": false}, "pincode": pincode}
+		location := model.NewGeoPoint(coordinates.Latitude, coordinates.Longitude)
+		result, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"location": location}})
+		if err != nil {
+			return err
+		}
+		updated += result.ModifiedCount
+	}
+	log.Printf("Backfilled location on %d products; %d pincodes are unknown", updated, unknown)
+	return nil
+}
+
+// duplicateEmailsShown caps how many duplicated emails the error lists.
+const duplicateEmailsShown = 20
+
+// checkDuplicateEmails fails when several users share an email, since the
+// unique email index cannot be built until they are cleaned up by hand. The
+// migration is not recorded, so the server refuses to start until the
+// duplicates are gone.
+func checkDuplicateEmails(ctx context.Context, collection *mongo.Collection) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$email", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var duplicates []struct {
+		Email string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &duplicates); err != nil {
+		return err
+	}
+	if len(duplicates) == 0 {
+		return nil
+	}
+
+	var shown []string
+	for _, duplicate := range duplicates {
+		if len(shown) == duplicateEmailsShown {
+			shown = append(shown, fmt.Sprintf("and %d more", len(duplicates)-duplicateEmailsShown))
+			break
+		}
+		shown = append(shown, fmt.Sprintf("%s (%d users)", duplicate.Email, duplicate.Count))
+	}
+	return fmt.Errorf("%d emails belong to more than one user: %s; delete or change the email of "+
+		"all but one user for each of them in the users collection, then restart",
+		len(duplicates), strings.Join(shown, ", "))
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// SystemActor is recorded as the author of changes made by the server itself,
+// such as background jobs and migrations.
+const SystemActor = "system"
+
+// Audit records when a document was created and last changed, and by whom.
+// It is embedded inline in every stored document and filled in by the
+// repositories.
+type Audit struct {
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	CreatedBy string    `bson:"created_by" json:"created_by"`
+	UpdatedBy string    `bson:"updated_by" json:"updated_by"`
+}
+
+func NewAudit(actor string, at time.Time) Audit {
+	return Audit{CreatedAt: at, UpdatedAt: at, CreatedBy: actor, UpdatedBy: actor}
+}
+
+// Touch records a change made by actor at the given time.
+func (a *Audit) Touch(actor string, at time.Time) {
+	a.UpdatedAt = at
+	a.UpdatedBy = actor
+}
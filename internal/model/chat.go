@@ -0,0 +1,62 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxMessageLength is the longest chat message accepted, in characters.
+const MaxMessageLength = 4000
+
+// Conversation is the thread between a buyer and the seller of one product.
+// There is at most one conversation per product and buyer.
+type Conversation struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	ProductID      primitive.ObjectID `bson:"product_id"`
+	ProductName    string             `bson:"product_name"` // As it was when the conversation started
+	BuyerEmail     string             `bson:"buyer_email"`
+	SellerEmail    string             `bson:"seller_email"`
+	CreatedAt      time.Time          `bson:"created_at"`
+	LastMessage    string             `bson:"last_message,omitempty"`
+	LastActivityAt time.Time          `bson:"last_activity_at"` // Orders the inbox
+	ArchivedAt     *time.Time         `bson:"archived_at,omitempty"`
+	ArchivedReason string             `bson:"archived_reason,omitempty"`
+}
+
+// HasParticipant reports whether email is the buyer or the seller.
+func (c Conversation) HasParticipant(email string) bool {
+	return email == c.BuyerEmail || email == c.SellerEmail
+}
+
+// OtherParticipant returns the buyer for the seller and the seller for the
+// buyer.
+func (c Conversation) OtherParticipant(email string) string {
+	if email == c.SellerEmail {
+		return c.BuyerEmail
+	}
+	return c.SellerEmail
+}
+
+// Archived reports whether the conversation no longer accepts messages.
+func (c Conversation) Archived() bool {
+	return c.ArchivedAt != nil
+}
+
+// Message is one message of a conversation. ReadAt is set once the other
+// participant has read it.
+type Message struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty"`
+	ConversationID primitive.ObjectID `bson:"conversation_id"`
+	SenderEmail    string             `bson:"sender_email"`
+	Body           string             `bson:"body"`
+	SentAt         time.Time          `bson:"sent_at"`
+	ReadAt         *time.Time         `bson:"read_at,omitempty"`
+}
+
+// InboxEntry is a conversation as listed in a user's inbox, with the number
+// of messages the other participant sent that the user has not read yet.
+type InboxEntry struct {
+	Conversation `bson:",inline"`
+	UnreadCount  int64 `bson:"unread_count"`
+}
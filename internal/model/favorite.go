@@ -0,0 +1,28 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Favorite is a listing a user saved. A user saves a product at most once.
+type Favorite struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserEmail string             `bson:"user_email"`
+	ProductID primitive.ObjectID `bson:"product_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+// FavoriteProduct is a saved listing as it is now.
+type FavoriteProduct struct {
+	Product
+	FavoritedAt time.Time
+}
+
+// FavoriteStats tells how many users saved a product and whether the user
+// asking is one of them.
+type FavoriteStats struct {
+	Count     int64 `bson:"count"`
+	Favorited bool  `bson:"favorited"`
+}
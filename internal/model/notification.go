@@ -0,0 +1,91 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NotificationCategory groups notifications for the preferences of users.
+type NotificationCategory string
+
+const (
+	CategoryChat          NotificationCategory = "chat"
+	CategoryFavorites     NotificationCategory = "favorites"
+	CategorySavedSearches NotificationCategory = "saved_searches"
+	CategoryListings      NotificationCategory = "listings"
+	CategoryModeration    NotificationCategory = "moderation"
+)
+
+// NotificationCategories lists every category.
+var NotificationCategories = []NotificationCategory{
+	CategoryChat, CategoryFavorites, CategorySavedSearches, CategoryListings, CategoryModeration,
+}
+
+// NotificationChannel is a way of delivering notifications.
+type NotificationChannel string
+
+const (
+	ChannelInApp   NotificationChannel = "in_app"
+	ChannelEmail   NotificationChannel = "email"
+	ChannelWebhook NotificationChannel = "webhook"
+)
+
+// NotificationRetention is how long notifications are kept.
+const NotificationRetention = 90 * 24 * time.Hour
+
+// Notification is something a user is told about. Link is the API path of
+// what it is about, such as a product or a conversation, when there is one.
+type Notification struct {
+	ID        primitive.ObjectID   `bson:"_id,omitempty"`
+	UserEmail string               `bson:"user_email"`
+	Category  NotificationCategory `bson:"category"`
+	Subject   string               `bson:"subject"`
+	Message   string               `bson:"message"`
+	Link      string               `bson:"link,omitempty"`
+	CreatedAt time.Time            `bson:"created_at"`
+	ReadAt    *time.Time           `bson:"read_at,omitempty"`
+}
+
+// NotificationPreferences are the channels a user wants each category of
+// notifications delivered through. Categories missing from Channels use the
+// defaults of DefaultNotificationChannels.
+type NotificationPreferences struct {
+	UserEmail  string                                         `bson:"_id"`
+	Channels   map[NotificationCategory][]NotificationChannel `bson:"channels"`
+	WebhookURL string                                         `bson:"webhook_url,omitempty"`
+	UpdatedAt  time.Time                                      `bson:"updated_at"`
+}
+
+// DefaultNotificationChannels are the channels of users who did not choose.
+// Chat only goes in-app, as clients already see messages arrive live.
+var DefaultNotificationChannels = map[NotificationCategory][]NotificationChannel{
+	CategoryChat:          {ChannelInApp},
+	CategoryFavorites:     {ChannelInApp, ChannelEmail},
+	CategorySavedSearches: {ChannelInApp, ChannelEmail},
+	CategoryListings:      {ChannelInApp, ChannelEmail},
+	CategoryModeration:    {ChannelInApp, ChannelEmail},
+}
+
+// ChannelsFor returns the channels notifications of category go through.
+// Moderation notices are always kept in-app, so users can see why their
+// account or listings were acted on.
+func (p NotificationPreferences) ChannelsFor(category NotificationCategory) []NotificationChannel {
+	channels, ok := p.Channels[category]
+	if !ok {
+		channels = DefaultNotificationChannels[category]
+	}
+	if category == CategoryModeration && !hasChannel(channels, ChannelInApp) {
+		channels = append([]NotificationChannel{ChannelInApp}, channels...)
+	}
+	return channels
+}
+
+func hasChannel(channels []NotificationChannel, channel NotificationChannel) bool {
+	for _, c := range channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -12,8 +14,70 @@ type Product struct {
 	Description string             `bson:"description" json:"description" validate:"required"`               // Product name is required
 	Category    string             `bson:"category" json:"category" validate:"required"`       // Category is required
 	Price       float64            `bson:"price" json:"price" validate:"required,gte=0"`       // Price is required and must be greater than or equal to 0
-	ImageURL    string             `bson:"image_url" json:"image_url" validate:"required,url"` // Image URL is required and must be a valid URL
+	ImageURL    string             `bson:"image_url" json:"image_url" validate:"required,url"` // Cover image; always the first entry of Images
+	Images      []string           `bson:"images,omitempty" json:"images,omitempty" validate:"max=10,dive,required,url"` // Gallery of at most MaxProductImages URLs
 	Address     string             `bson:"address" json:"address" validate:"required"`         // Address is required
 	State       string             `bson:"state" json:"state" validate:"required"`             // State is required
 	Pincode     string             `bson:"pincode" json:"pincode" validate:"required,len=6"`   // Pincode is required and must be exactly 6 characters long
+	Location    *GeoPoint          `bson:"location,omitempty" json:"location,omitempty"`      // Optional; resolved from the pincode when missing
+	Status      ProductStatus      `bson:"status" json:"status"`                               // Lifecycle state; new listings are active unless sent as draft
+
+	Audit          `bson:",inline"`
+	ExpiresAt      *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Set while the listing is active
+	ExpiryWarnedAt *time.Time `bson:"expiry_warned_at,omitempty" json:"-"`             // When the seller was told the listing is about to expire
+}
+
+// MaxProductImages is the size limit of a product gallery. It must match the
+// max rule on Product.Images.
+const MaxProductImages = 10
+
+// NormalizeImages keeps the cover image and the gallery consistent. An
+// ImageURL that is not in the gallery is added to the front, one that is
+// already in it is moved to the front, and ImageURL is then set to the first
+// image.
+func (p *Product) NormalizeImages() {
+	if p.ImageURL != "" {
+		images := []string{p.ImageURL}
+		for _, image := range p.Images {
+			if image != p.ImageURL {
+				images = append(images, image)
+			}
+		}
+		p.Images = images
+	}
+	if len(p.Images) > 0 {
+		p.ImageURL = p.Images[0]
+	}
+}
+
+// GeoPoint is a GeoJSON point. Coordinates are longitude then latitude, as
+// GeoJSON and MongoDB's 2dsphere index expect.
+type GeoPoint struct {
+	Type        string     `bson:"type" json:"type" validate:"eq=Point"`
+	Coordinates [2]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+func NewGeoPoint(latitude, longitude float64) *GeoPoint {
+	return &GeoPoint{Type: "Point", Coordinates: [2]float64{longitude, latitude}}
+}
+
+// Valid reports whether the coordinates are within the longitude and latitude
+// ranges.
+func (p GeoPoint) Valid() bool {
+	lng, lat := p.Coordinates[0], p.Coordinates[1]
+	return lng >= -180 && lng <= 180 && lat >= -90 && lat <= 90
+}
+
+// ProductSearchResult is a product returned by the full-text search together
+// with its relevance score and a highlighted excerpt of the matched text.
+type ProductSearchResult struct {
+	Product `bson:",inline"`
+	Score   float64 `bson:"score" json:"score"`
+	Snippet string  `bson:"-" json:"snippet"`
+}
+
+// NearbyProduct is a product returned by a distance search.
+type NearbyProduct struct {
+	Product    `bson:",inline"`
+	DistanceKm float64 `bson:"distance_km" json:"distance_km"`
 }
@@ -0,0 +1,87 @@
+package model
+
+// ProductSort is the order in which product listings are returned.
+type ProductSort string
+
+const (
+	SortNewest    ProductSort = "newest"
+	SortPriceAsc  ProductSort = "price_asc"
+	SortPriceDesc ProductSort = "price_desc"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ProductQuery holds the filters, sort order and page requested by a client
+// when listing products. It is bound from the URL query string. When Cursor
+// is set it takes precedence over Page.
+type ProductQuery struct {
+	Category string      `form:"category"`
+	State    string      `form:"state"`
+	Pincode  string      `form:"pincode" validate:"omitempty,len=6"`
+	MinPrice *float64    `form:"min_price" validate:"omitempty,gte=0"`
+	MaxPrice *float64    `form:"max_price" validate:"omitempty,gte=0"`
+	Keyword  string      `form:"q"`
+	Sort     ProductSort `form:"sort" validate:"omitempty,oneof=newest price_asc price_desc"`
+	Page     int         `form:"page" validate:"omitempty,gte=1"`
+	PageSize int         `form:"page_size" validate:"omitempty,gte=1,lte=100"`
+	Cursor   string      `form:"cursor"`
+}
+
+// Normalize fills in the default sort order and page values.
+func (q *ProductQuery) Normalize() {
+	if q.Sort == "" {
+		q.Sort = SortNewest
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 {
+		q.PageSize = DefaultPageSize
+	}
+	if q.PageSize > MaxPageSize {
+		q.PageSize = MaxPageSize
+	}
+}
+
+const (
+	DefaultNearbyRadiusKm = 10
+	MaxNearbyRadiusKm     = 500
+)
+
+// NearbyQuery asks for products within RadiusKm of a point, nearest first.
+// The filters and page of the embedded ProductQuery apply as well; its sort
+// and cursor are ignored.
+type NearbyQuery struct {
+	Latitude  *float64 `form:"lat" validate:"required,gte=-90,lte=90"`
+	Longitude *float64 `form:"lng" validate:"required,gte=-180,lte=180"`
+	RadiusKm  float64  `form:"radius_km" validate:"omitempty,gt=0,lte=500"`
+	ProductQuery
+}
+
+// Normalize fills in the default radius and page values.
+func (q *NearbyQuery) Normalize() {
+	if q.RadiusKm <= 0 {
+		q.RadiusKm = DefaultNearbyRadiusKm
+	}
+	q.ProductQuery.Normalize()
+}
+
+// CursorPage requests the page of results that follows Cursor. An empty
+// cursor requests the first page.
+type CursorPage struct {
+	Cursor string `form:"cursor"`
+	Limit  int    `form:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+// Normalize fills in the default page size.
+func (p *CursorPage) Normalize() {
+	if p.Limit < 1 {
+		p.Limit = DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+}
@@ -0,0 +1,64 @@
+package model
+
+// ProductStatus is the lifecycle state of a listing. Only active listings are
+// shown to buyers.
+type ProductStatus string
+
+const (
+	StatusDraft    ProductStatus = "draft"
+	StatusActive   ProductStatus = "active"
+	StatusSold     ProductStatus = "sold"
+	StatusExpired  ProductStatus = "expired"
+	StatusArchived ProductStatus = "archived"
+)
+
+// ProductAction is a named transition between product statuses.
+type ProductAction string
+
+const (
+	ActionPublish  ProductAction = "publish"
+	ActionMarkSold ProductAction = "mark_sold"
+	ActionRelist   ProductAction = "relist"
+	ActionArchive  ProductAction = "archive"
+	ActionRenew    ProductAction = "renew"
+	ActionExpire   ProductAction = "expire"
+)
+
+type productTransition struct {
+	from []ProductStatus
+	to   ProductStatus
+}
+
+// productTransitions is the listing state machine. ActionExpire is only
+// performed by the server, never by a seller.
+var productTransitions = map[ProductAction]productTransition{
+	ActionPublish:  {from: []ProductStatus{StatusDraft}, to: StatusActive},
+	ActionMarkSold: {from: []ProductStatus{StatusActive}, to: StatusSold},
+	ActionRelist:   {from: []ProductStatus{StatusSold, StatusExpired, StatusArchived}, to: StatusActive},
+	ActionArchive:  {from: []ProductStatus{StatusDraft, StatusActive, StatusSold, StatusExpired}, to: StatusArchived},
+	ActionRenew:    {from: []ProductStatus{StatusActive, StatusExpired}, to: StatusActive},
+	ActionExpire:   {from: []ProductStatus{StatusActive}, to: StatusExpired},
+}
+
+// Apply returns the status reached by performing action from s, or false
+// when the action is not allowed from s.
+func (s ProductStatus) Apply(action ProductAction) (ProductStatus, bool) {
+	transition, ok := productTransitions[action]
+	if !ok {
+		return "", false
+	}
+	for _, from := range transition.from {
+		if s.OrDefault() == from {
+			return transition.to, true
+		}
+	}
+	return "", false
+}
+
+// OrDefault treats listings created before statuses existed as active.
+func (s ProductStatus) OrDefault() ProductStatus {
+	if s == "" {
+		return StatusActive
+	}
+	return s
+}
@@ -1,6 +1,24 @@
 package model
 
-type UserWithProducts struct {
-	User     User      `json:"user"`
-	Products []Product `json:"products"`
+// Pagination describes the page of results returned by a listing endpoint.
+// Page is left out when the page was requested with a cursor.
+type Pagination struct {
+	Page       int    `json:"page,omitempty"`
+	PageSize   int    `json:"page_size"`
+	Total      int64  `json:"total"`
+	TotalPages int64  `json:"total_pages"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func NewPagination(page, pageSize int, total int64) Pagination {
+	totalPages := total / int64(pageSize)
+	if total%int64(pageSize) != 0 {
+		totalPages++
+	}
+	return Pagination{
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      total,
+		TotalPages: totalPages,
+	}
 }
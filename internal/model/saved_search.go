@@ -0,0 +1,82 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AlertFrequency is how often a user hears about new matches of a saved
+// search.
+type AlertFrequency string
+
+const (
+	AlertInstant AlertFrequency = "instant"
+	AlertDaily   AlertFrequency = "daily"
+)
+
+const (
+	// MaxSavedSearches is how many searches a user can save.
+	MaxSavedSearches = 20
+	// MaxPendingMatches is how many matches a digest lists. Older matches
+	// are dropped when more arrive before the digest is sent.
+	MaxPendingMatches = 50
+)
+
+// SearchFilters are the product filters a saved search keeps. They mean the
+// same as the matching fields of ProductQuery.
+type SearchFilters struct {
+	Category string   `bson:"category,omitempty"`
+	State    string   `bson:"state,omitempty"`
+	Pincode  string   `bson:"pincode,omitempty"`
+	MinPrice *float64 `bson:"min_price,omitempty"`
+	MaxPrice *float64 `bson:"max_price,omitempty"`
+	Keyword  string   `bson:"keyword,omitempty"`
+}
+
+// Empty reports whether the filters would match every product.
+func (f SearchFilters) Empty() bool {
+	return f.Category == "" && f.State == "" && f.Pincode == "" &&
+		f.MinPrice == nil && f.MaxPrice == nil && strings.TrimSpace(f.Keyword) == ""
+}
+
+// Matches reports whether product is an active listing that /getproducts
+// would return for these filters.
+func (f SearchFilters) Matches(product Product) bool {
+	switch {
+	case product.Status.OrDefault() != StatusActive:
+		return false
+	case f.Category != "" && !strings.EqualFold(product.Category, f.Category):
+		return false
+	case f.State != "" && !strings.EqualFold(product.State, f.State):
+		return false
+	case f.Pincode != "" && product.Pincode != f.Pincode:
+		return false
+	case f.MinPrice != nil && product.Price < *f.MinPrice:
+		return false
+	case f.MaxPrice != nil && product.Price > *f.MaxPrice:
+		return false
+	}
+
+	keyword := strings.ToLower(strings.TrimSpace(f.Keyword))
+	return keyword == "" ||
+		strings.Contains(strings.ToLower(product.Name), keyword) ||
+		strings.Contains(strings.ToLower(product.Description), keyword) ||
+		strings.Contains(strings.ToLower(product.Category), keyword)
+}
+
+// SavedSearch is a search a user wants to hear about new matches of, either
+// as soon as they are listed or in a daily digest. PendingMatches holds the
+// products waiting for the next digest.
+type SavedSearch struct {
+	ID             primitive.ObjectID   `bson:"_id,omitempty"`
+	UserEmail      string               `bson:"user_email"`
+	Name           string               `bson:"name"`
+	Filters        SearchFilters        `bson:"filters"`
+	Frequency      AlertFrequency       `bson:"frequency"`
+	CreatedAt      time.Time            `bson:"created_at"`
+	UpdatedAt      time.Time            `bson:"updated_at"`
+	PendingMatches []primitive.ObjectID `bson:"pending_matches,omitempty"`
+	LastDigestAt   *time.Time           `bson:"last_digest_at,omitempty"`
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login of a user. All refresh tokens issued for a session
+// form a token family: revoking the session invalidates every one of them
+// as well as the access tokens issued alongside them.
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	UserEmail     string             `bson:"user_email"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	ExpiresAt     time.Time          `bson:"expires_at"` // Moves forward every time a refresh token is rotated
+	RevokedAt     *time.Time         `bson:"revoked_at,omitempty"`
+	RevokedReason string             `bson:"revoked_reason,omitempty"`
+}
+
+// RefreshToken is a single use token that can be exchanged for a new access
+// and refresh token pair. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	TokenHash string             `bson:"token_hash"`
+	SessionID primitive.ObjectID `bson:"session_id"`
+	UserEmail string             `bson:"user_email"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"` // Set when the token is rotated
+}
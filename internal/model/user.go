@@ -3,9 +3,12 @@ package model
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty"`
-	Name     string             `bson:"name" validate:"required"`
-	ImageURL string             `bson:"image_url" json:"image_url"`
-	Email    string             `bson:"email" validate:"required,email"`
-	Password string             `bson:"password" validate:"required" json:"password"`
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Name      string             `bson:"name" validate:"required"`
+	ImageURL  string             `bson:"image_url" json:"image_url"`
+	Email     string             `bson:"email" validate:"required,email"`
+	Password  string             `bson:"password" validate:"required" json:"-"` // bcrypt hash once stored; never serialized
+	Role      Role               `bson:"role" json:"role"`
+	Suspended bool               `bson:"suspended" json:"suspended"` // Suspended users cannot log in or use their tokens
+	Audit     `bson:",inline"`
 }
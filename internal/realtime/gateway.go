@@ -0,0 +1,308 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of the messages clients send.
+const (
+	ClientTyping  = "typing"
+	ClientWatch   = "watch"
+	ClientUnwatch = "unwatch"
+)
+
+const (
+	// DefaultPingInterval is how often connections are pinged by default.
+	DefaultPingInterval = 30 * time.Second
+
+	writeWait      = 10 * time.Second
+	closeGrace     = time.Second // How long a client gets to answer our close frame
+	maxMessageSize = 4096
+	replyBuffer    = 8
+)
+
+// ClientMessage is what clients send over their connection.
+type ClientMessage struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id,omitempty"`
+	ProductID      string `json:"product_id,omitempty"`
+}
+
+// Handler acts on the messages a client sends. Errors it returns are sent
+// back to the client as they are, so they must not reveal internals.
+type Handler interface {
+	// Typing tells the other participant of a conversation that email is
+	// typing in it.
+	Typing(ctx context.Context, email, conversationID string) error
+	// WatchProduct checks that email may follow a product and returns the
+	// topic its status changes are published to.
+	WatchProduct(ctx context.Context, email, productID string) (string, error)
+}
+
+// Gateway serves WebSocket connections. Every connection receives the events
+// of its user's topic plus those of the products it watches. Connections are
+// pinged every PingInterval and dropped when the pongs stop or their session
+// ends.
+type Gateway struct {
+	Hub          Hub
+	PingInterval time.Duration // 0 means DefaultPingInterval
+	upgrader     websocket.Upgrader
+
+	mu      sync.Mutex
+	closing bool
+	conns   sync.WaitGroup
+}
+
+// NewGateway returns a gateway that accepts connections from pages served by
+// allowedOrigins, or from anywhere when they include "*". Clients that send
+// no Origin header, which browsers always do, are accepted too.
+func NewGateway(hub Hub, allowedOrigins []string, pingInterval time.Duration) *Gateway {
+	gateway := &Gateway{Hub: hub, PingInterval: pingInterval}
+	gateway.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	}
+	return gateway
+}
+
+// Serve upgrades the request to a WebSocket for email and serves it until
+// either side closes it or the gateway is closed. checkSession is called
+// before every ping; once it fails, the connection is closed, so that a
+// socket does not outlive the logout or suspension of its user.
+func (gateway *Gateway) Serve(w http.ResponseWriter, r *http.Request, email string, handler Handler, checkSession func(ctx context.Context) error) {
+	gateway.mu.Lock()
+	if gateway.closing {
+		gateway.mu.Unlock()
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	gateway.conns.Add(1)
+	gateway.mu.Unlock()
+	defer gateway.conns.Done()
+
+	sub, err := gateway.Hub.Subscribe(UserTopic(email))
+	if err != nil {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	// Upgrade writes the error response itself.
+	conn, err := gateway.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("WebSocket upgrade failed: ", err)
+		return
+	}
+	defer conn.Close()
+
+	c := &connection{
+		conn:         conn,
+		sub:          sub,
+		email:        email,
+		handler:      handler,
+		checkSession: checkSession,
+		interval:     gateway.pingInterval(),
+		replies:      make(chan Event, replyBuffer),
+		done:         make(chan struct{}),
+	}
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		c.writeLoop()
+		c.stopReading()
+	}()
+	c.readLoop(r.Context())
+	close(c.done)
+	<-written
+}
+
+// Close stops accepting connections, closes the hub, which sends every open
+// connection a close frame, and waits for the connections to finish or for
+// ctx to expire.
+func (gateway *Gateway) Close(ctx context.Context) error {
+	gateway.mu.Lock()
+	gateway.closing = true
+	gateway.mu.Unlock()
+
+	if err := gateway.Hub.Close(); err != nil {
+		return err
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		gateway.conns.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (gateway *Gateway) pingInterval() time.Duration {
+	if gateway.PingInterval <= 0 {
+		return DefaultPingInterval
+	}
+	return gateway.PingInterval
+}
+
+// connection is one client. Only writeLoop writes to conn, as gorilla
+// websocket connections support one concurrent writer.
+type connection struct {
+	conn         *websocket.Conn
+	sub          Subscription
+	email        string
+	handler      Handler
+	checkSession func(ctx context.Context) error
+	interval     time.Duration
+	replies      chan Event    // Answers to the client's own messages
+	done         chan struct{} // Closed when readLoop returns
+	stopping     atomic.Bool   // Set once writeLoop has returned
+}
+
+// readLoop handles client messages until the connection fails or closes. A
+// client that answers no ping within two intervals is considered gone.
+func (c *connection) readLoop(ctx context.Context) {
+	pongWait := 2 * c.interval
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		if c.stopping.Load() {
+			return nil
+		}
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket of %s failed: %v", c.email, err)
+			}
+			return
+		}
+
+		var message ClientMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			c.reply(errors.New("messages must be JSON objects"))
+			continue
+		}
+		c.reply(c.handle(ctx, message))
+	}
+}
+
+func (c *connection) handle(ctx context.Context, message ClientMessage) error {
+	switch message.Type {
+	case ClientTyping:
+		return c.handler.Typing(ctx, c.email, message.ConversationID)
+	case ClientWatch:
+		topic, err := c.handler.WatchProduct(ctx, c.email, message.ProductID)
+		if err != nil {
+			return err
+		}
+		return c.sub.Watch(topic)
+	case ClientUnwatch:
+		c.sub.Unwatch(ProductTopic(message.ProductID))
+		return nil
+	default:
+		return errors.New("unknown message type")
+	}
+}
+
+// reply sends err back to the client. Replies are dropped when the client
+// sends faster than it reads.
+func (c *connection) reply(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case c.replies <- Event{Type: EventError, Data: map[string]string{"error": err.Error()}}:
+	default:
+	}
+}
+
+// writeLoop sends events and pings until the subscription ends, the session
+// ends, a write fails or readLoop returns.
+func (c *connection) writeLoop() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.sub.Events():
+			if !ok {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+			if !c.write(event) {
+				return
+			}
+		case event := <-c.replies:
+			if !c.write(event) {
+				return
+			}
+		case <-ticker.C:
+			if !c.sessionActive() {
+				c.close(websocket.ClosePolicyViolation, "session ended")
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *connection) write(event Event) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := c.conn.WriteJSON(event); err != nil {
+		log.Printf("WebSocket write to %s failed: %v", c.email, err)
+		return false
+	}
+	return true
+}
+
+func (c *connection) sessionActive() bool {
+	if c.checkSession == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+	return c.checkSession(ctx) == nil
+}
+
+// close sends a close frame. readLoop ends when the client answers it.
+func (c *connection) close(code int, text string) {
+	message := websocket.FormatCloseMessage(code, text)
+	c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+}
+
+// stopReading gives readLoop a moment to receive the client's close frame
+// and then ends it, so that nothing keeps the connection open once events
+// can no longer be written to it.
+func (c *connection) stopReading() {
+	c.stopping.Store(true)
+	c.conn.SetReadDeadline(time.Now().Add(closeGrace))
+}
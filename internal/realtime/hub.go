@@ -0,0 +1,66 @@
+// Package realtime pushes events to connected clients over WebSockets.
+// Services publish events to topics on a Hub and every connection
+// subscribed to a topic receives them.
+package realtime
+
+import (
+	"context"
+	"errors"
+)
+
+// Types of the events pushed to clients.
+const (
+	EventMessage        = "message"
+	EventTyping         = "typing"
+	EventRead           = "read"
+	EventProductStatus  = "product_status"
+	EventProductDeleted = "product_deleted"
+	EventFavorite       = "favorite"
+	EventSearchMatch    = "search_match"
+	EventNotification   = "notification"
+	EventError          = "error"
+)
+
+// Event is what clients receive. Type tells them how to read Data.
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+var (
+	ErrHubClosed          = errors.New("hub is closed")
+	ErrSubscriptionClosed = errors.New("subscription is closed")
+)
+
+// Hub fans events out to the subscribers of a topic. InProcessHub only
+// reaches connections served by this process; a Hub backed by a message
+// broker can take its place when the API runs on several instances.
+type Hub interface {
+	// Subscribe starts a subscription to topics. It fails with ErrHubClosed
+	// once the hub is closed.
+	Subscribe(topics ...string) (Subscription, error)
+	// Publish sends event to every subscriber of topic.
+	Publish(ctx context.Context, topic string, event Event) error
+	// Close ends every subscription and refuses new ones.
+	Close() error
+}
+
+// Subscription receives the events published to its topics.
+type Subscription interface {
+	// Events is closed when the subscription or its hub is closed, or when
+	// the subscriber fell so far behind that events had to be dropped.
+	Events() <-chan Event
+	Watch(topic string) error
+	Unwatch(topic string)
+	Close()
+}
+
+// UserTopic carries the events meant for one user, on all of their devices.
+func UserTopic(email string) string {
+	return "user:" + email
+}
+
+// ProductTopic carries status changes of a product to the users watching it.
+func ProductTopic(id string) string {
+	return "product:" + id
+}
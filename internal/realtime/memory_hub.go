@@ -0,0 +1,146 @@
+package realtime
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// DefaultBuffer is how many events a subscriber may fall behind by default.
+const DefaultBuffer = 64
+
+// InProcessHub is a Hub for a single process. A subscriber whose buffer is
+// full when an event arrives is disconnected rather than slowing down the
+// publisher; clients are expected to reconnect and catch up over HTTP.
+type InProcessHub struct {
+	mu     sync.Mutex
+	buffer int
+	topics map[string]map[*subscription]struct{}
+	subs   map[*subscription]struct{}
+	closed bool
+}
+
+// NewInProcessHub returns a hub whose subscribers buffer up to buffer
+// events, or DefaultBuffer when buffer is not positive.
+func NewInProcessHub(buffer int) *InProcessHub {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	return &InProcessHub{
+		buffer: buffer,
+		topics: map[string]map[*subscription]struct{}{},
+		subs:   map[*subscription]struct{}{},
+	}
+}
+
+func (hub *InProcessHub) Subscribe(topics ...string) (Subscription, error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.closed {
+		return nil, ErrHubClosed
+	}
+	sub := &subscription{
+		hub:    hub,
+		events: make(chan Event, hub.buffer),
+		topics: map[string]struct{}{},
+	}
+	hub.subs[sub] = struct{}{}
+	for _, topic := range topics {
+		hub.watch(sub, topic)
+	}
+	return sub, nil
+}
+
+func (hub *InProcessHub) Publish(ctx context.Context, topic string, event Event) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	if hub.closed {
+		return ErrHubClosed
+	}
+	for sub := range hub.topics[topic] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Dropping slow %s subscriber", topic)
+			hub.drop(sub)
+		}
+	}
+	return nil
+}
+
+func (hub *InProcessHub) Close() error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+
+	hub.closed = true
+	for sub := range hub.subs {
+		hub.drop(sub)
+	}
+	return nil
+}
+
+func (hub *InProcessHub) watch(sub *subscription, topic string) {
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = map[*subscription]struct{}{}
+	}
+	hub.topics[topic][sub] = struct{}{}
+	sub.topics[topic] = struct{}{}
+}
+
+func (hub *InProcessHub) unwatch(sub *subscription, topic string) {
+	delete(hub.topics[topic], sub)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+	delete(sub.topics, topic)
+}
+
+// drop ends a subscription. hub.mu must be held.
+func (hub *InProcessHub) drop(sub *subscription) {
+	if _, ok := hub.subs[sub]; !ok {
+		return
+	}
+	for topic := range sub.topics {
+		hub.unwatch(sub, topic)
+	}
+	delete(hub.subs, sub)
+	close(sub.events)
+}
+
+type subscription struct {
+	hub    *InProcessHub
+	events chan Event
+	topics map[string]struct{} // Guarded by hub.mu
+}
+
+func (sub *subscription) Events() <-chan Event {
+	return sub.events
+}
+
+func (sub *subscription) Watch(topic string) error {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+
+	if _, ok := sub.hub.subs[sub]; !ok {
+		return ErrSubscriptionClosed
+	}
+	sub.hub.watch(sub, topic)
+	return nil
+}
+
+func (sub *subscription) Unwatch(topic string) {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+
+	if _, ok := sub.hub.subs[sub]; ok {
+		sub.hub.unwatch(sub, topic)
+	}
+}
+
+func (sub *subscription) Close() {
+	sub.hub.mu.Lock()
+	defer sub.hub.mu.Unlock()
+	sub.hub.drop(sub)
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrConversationArchived = errors.New("conversation is archived")
+)
+
+type MongoChatRepository struct {
+	Conversations *mongo.Collection
+	Messages      *mongo.Collection
+	Timeouts      Timeouts
+}
+
+// EnsureIndexes makes conversations unique per product and buyer and
+// supports the inbox and thread queries.
+func (repo *MongoChatRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Conversations.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "buyer_email", Value: 1}},
+			Options: options.Index().SetName("conversation_product_buyer").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "buyer_email", Value: 1}, {Key: "last_activity_at", Value: -1}},
+			Options: options.Index().SetName("conversation_buyer_inbox"),
+		},
+		{
+			Keys:    bson.D{{Key: "seller_email", Value: 1}, {Key: "last_activity_at", Value: -1}},
+			Options: options.Index().SetName("conversation_seller_inbox"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.Messages.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "conversation_id", Value: 1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName("message_conversation"),
+	})
+	return err
+}
+
+// StartConversation returns the conversation of conversation.BuyerEmail about
+// conversation.ProductID, and stores conversation when there is none yet.
+func (repo *MongoChatRepository) StartConversation(ctx context.Context, conversation model.Conversation) (*model.Conversation, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"product_id": conversation.ProductID, "buyer_email": conversation.BuyerEmail}
+	update := bson.M{"$setOnInsert": conversation}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var stored model.Conversation
+	err := repo.Conversations.FindOneAndUpdate(ctx, filter, update, opts).Decode(&stored)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request created it first, so it can now be found.
+		err = repo.Conversations.FindOne(ctx, filter).Decode(&stored)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (repo *MongoChatRepository) GetConversation(ctx context.Context, id primitive.ObjectID) (*model.Conversation, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	var conversation model.Conversation
+	err := repo.Conversations.FindOne(ctx, bson.M{"_id": id}).Decode(&conversation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrConversationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// GetInbox returns the conversations email takes part in, most recently
+// active first, with their unread counts. The returned cursor is empty on
+// the last page.
+func (repo *MongoChatRepository) GetInbox(ctx context.Context, email string, page model.CursorPage) ([]model.InboxEntry, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{bson.M{"buyer_email": email}, bson.M{"seller_email": email}}}
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil || cursor.Time == nil {
+			return nil, "", ErrInvalidCursor
+		}
+		filter = andFilter(filter, bson.M{"$or": bson.A{
+			bson.M{"last_activity_at": bson.M{"$lt": cursor.Time}},
+			bson.M{"last_activity_at": cursor.Time, "_id": bson.M{"$lt": cursor.ID}},
+		}})
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{{Key: "last_activity_at", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$limit", Value: page.Limit + 1}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": repo.Messages.Name(),
+			"let":  bson.M{"conversation": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{
+					"$expr":        bson.M{"$eq": bson.A{"$conversation_id", "$$conversation"}},
+					"sender_email": bson.M{"$ne": email},
+					"read_at":      bson.M{"$exists": false},
+				}},
+				bson.M{"$count": "count"},
+			},
+			"as": "unread",
+		}}},
+		{{Key: "$addFields", Value: bson.M{"unread_count": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$unread.count", 0}}, 0}}}}},
+		{{Key: "$project", Value: bson.M{"unread": 0}}},
+	}
+
+	cursor, err := repo.Conversations.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []model.InboxEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = encodeCursor(pageCursor{ID: last.ID, Time: &last.LastActivityAt})
+	}
+	return entries, next, nil
+}
+
+// ReopenConversation lets an archived conversation take messages again.
+func (repo *MongoChatRepository) ReopenConversation(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"archived_at": "", "archived_reason": ""}}
+	_, err := repo.Conversations.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// ArchiveProductConversations archives every open conversation about a
+// product and returns how many were archived.
+func (repo *MongoChatRepository) ArchiveProductConversations(ctx context.Context, productID primitive.ObjectID, reason string, at time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"product_id": productID, "archived_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"archived_at": at, "archived_reason": reason}}
+	result, err := repo.Conversations.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// ArchiveUserConversations archives every open conversation email takes part
+// in and returns how many were archived.
+func (repo *MongoChatRepository) ArchiveUserConversations(ctx context.Context, email, reason string, at time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{
+		"$or":         bson.A{bson.M{"buyer_email": email}, bson.M{"seller_email": email}},
+		"archived_at": bson.M{"$exists": false},
+	}
+	update := bson.M{"$set": bson.M{"archived_at": at, "archived_reason": reason}}
+	result, err := repo.Conversations.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// AddMessage stores a message and moves its conversation to the top of the
+// inbox. It fails with ErrConversationArchived once the conversation has
+// been archived.
+func (repo *MongoChatRepository) AddMessage(ctx context.Context, message model.Message) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	// Updating the conversation first makes archiving and sending exclude
+	// each other: a message is only stored if the update found it open.
+	filter := bson.M{"_id": message.ConversationID, "archived_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"last_message": message.Body, "last_activity_at": message.SentAt}}
+	result, err := repo.Conversations.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if result.MatchedCount == 0 {
+		return primitive.NilObjectID, ErrConversationArchived
+	}
+
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	if _, err := repo.Messages.InsertOne(ctx, message); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return message.ID, nil
+}
+
+// GetMessages returns the messages of a conversation, newest first, one page
+// at a time. The returned cursor is empty on the last page.
+func (repo *MongoChatRepository) GetMessages(ctx context.Context, conversationID primitive.ObjectID, page model.CursorPage) ([]model.Message, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{"conversation_id": conversationID}
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(page.Limit) + 1)
+
+	cursor, err := repo.Messages.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var messages []model.Message
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(messages) > page.Limit {
+		messages = messages[:page.Limit]
+		next = encodeCursor(pageCursor{ID: messages[len(messages)-1].ID})
+	}
+	return messages, next, nil
+}
+
+// MarkMessagesRead marks every message of a conversation that reader
+// received as read and returns how many were unread.
+func (repo *MongoChatRepository) MarkMessagesRead(ctx context.Context, conversationID primitive.ObjectID, reader string, at time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{
+		"conversation_id": conversationID,
+		"sender_email":    bson.M{"$ne": reader},
+		"read_at":         bson.M{"$exists": false},
+	}
+	result, err := repo.Messages.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": at}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageCursor marks the last item of a page. Clients only ever see it as an
+// opaque token, which lets the encoding change without breaking them.
+type pageCursor struct {
+	Sort  model.ProductSort  `json:"s,omitempty"`
+	ID    primitive.ObjectID `json:"id"`
+	Price float64            `json:"p,omitempty"`
+	Time  *time.Time         `json:"t,omitempty"` // Sort key of time ordered lists such as the inbox
+}
+
+func encodeCursor(cursor pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID.IsZero() {
+		return cursor, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// productCursorFilter matches the products that come after cursor in the
+// given sort order. The _id tie-breaker mirrors productFindOptions.
+func productCursorFilter(sort model.ProductSort, cursor pageCursor) bson.M {
+	switch sort {
+	case model.SortPriceAsc:
+		return bson.M{"$or": bson.A{
+			bson.M{"price": bson.M{"$gt": cursor.Price}},
+			bson.M{"price": cursor.Price, "_id": bson.M{"$gt": cursor.ID}},
+		}}
+	case model.SortPriceDesc:
+		return bson.M{"$or": bson.A{
+			bson.M{"price": bson.M{"$lt": cursor.Price}},
+			bson.M{"price": cursor.Price, "_id": bson.M{"$lt": cursor.ID}},
+		}}
+	default:
+		return bson.M{"_id": bson.M{"$lt": cursor.ID}}
+	}
+}
+
+// andFilter combines two filters without clobbering operators such as $or
+// that may appear in both.
+func andFilter(filter, extra bson.M) bson.M {
+	if len(filter) == 0 {
+		return extra
+	}
+	return bson.M{"$and": bson.A{filter, extra}}
+}
@@ -0,0 +1,189 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrFavoriteNotFound = errors.New("favorite not found")
+
+type MongoFavoriteRepository struct {
+	Collection *mongo.Collection
+	Timeouts   Timeouts
+}
+
+// EnsureIndexes makes favorites unique per user and product and supports
+// listing them by user and by product.
+func (repo *MongoFavoriteRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "product_id", Value: 1}},
+			Options: options.Index().SetName("favorite_user_product").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("favorite_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}},
+			Options: options.Index().SetName("favorite_product"),
+		},
+	})
+	return err
+}
+
+// AddFavorite stores favorite unless the user already saved the product. It
+// reports whether favorite was stored.
+func (repo *MongoFavoriteRepository) AddFavorite(ctx context.Context, favorite model.Favorite) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"user_email": favorite.UserEmail, "product_id": favorite.ProductID}
+	update := bson.M{"$setOnInsert": favorite}
+	result, err := repo.Collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request saved it first.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// RemoveFavorite returns ErrFavoriteNotFound when email did not save the
+// product.
+func (repo *MongoFavoriteRepository) RemoveFavorite(ctx context.Context, email string, productID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteOne(ctx, bson.M{"user_email": email, "product_id": productID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrFavoriteNotFound
+	}
+	return nil
+}
+
+// GetFavorites returns the favorites of email, most recently saved first,
+// and the cursor of the next page, which is empty on the last page.
+func (repo *MongoFavoriteRepository) GetFavorites(ctx context.Context, email string, page model.CursorPage) ([]model.Favorite, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{"user_email": email}
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(page.Limit) + 1)
+
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var favorites []model.Favorite
+	if err := cursor.All(ctx, &favorites); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(favorites) > page.Limit {
+		favorites = favorites[:page.Limit]
+		next = encodeCursor(pageCursor{ID: favorites[len(favorites)-1].ID})
+	}
+	return favorites, next, nil
+}
+
+// GetFavoriteEmails returns the emails of the users who saved a product.
+func (repo *MongoFavoriteRepository) GetFavoriteEmails(ctx context.Context, productID primitive.ObjectID) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	values, err := repo.Collection.Distinct(ctx, "user_email", bson.M{"product_id": productID})
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]string, 0, len(values))
+	for _, value := range values {
+		if email, ok := value.(string); ok {
+			emails = append(emails, email)
+		}
+	}
+	return emails, nil
+}
+
+// GetFavoriteStats returns the favorite stats of the given products as seen
+// by email. Products nobody saved are left out of the result.
+func (repo *MongoFavoriteRepository) GetFavoriteStats(ctx context.Context, email string, productIDs []primitive.ObjectID) (map[primitive.ObjectID]model.FavoriteStats, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": bson.M{"$in": productIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$product_id",
+			"count":     bson.M{"$sum": 1},
+			"favorited": bson.M{"$max": bson.M{"$eq": bson.A{"$user_email", email}}},
+		}}},
+	}
+	cursor, err := repo.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		ProductID           primitive.ObjectID `bson:"_id"`
+		model.FavoriteStats `bson:",inline"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	stats := make(map[primitive.ObjectID]model.FavoriteStats, len(groups))
+	for _, group := range groups {
+		stats[group.ProductID] = group.FavoriteStats
+	}
+	return stats, nil
+}
+
+// DeleteProductFavorites removes every favorite of a product.
+func (repo *MongoFavoriteRepository) DeleteProductFavorites(ctx context.Context, productID primitive.ObjectID) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteMany(ctx, bson.M{"product_id": productID})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteUserFavorites removes every favorite saved by email.
+func (repo *MongoFavoriteRepository) DeleteUserFavorites(ctx context.Context, email string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteMany(ctx, bson.M{"user_email": email})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
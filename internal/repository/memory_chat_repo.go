@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryChatRepository keeps conversations and messages in memory with the
+// same behaviour as MongoChatRepository. It is safe for concurrent use and
+// meant for tests.
+type MemoryChatRepository struct {
+	mu            sync.RWMutex
+	conversations map[primitive.ObjectID]model.Conversation
+	messages      map[primitive.ObjectID][]model.Message // By conversation, oldest first
+}
+
+func NewMemoryChatRepository() *MemoryChatRepository {
+	return &MemoryChatRepository{
+		conversations: map[primitive.ObjectID]model.Conversation{},
+		messages:      map[primitive.ObjectID][]model.Message{},
+	}
+}
+
+// StartConversation returns the conversation of conversation.BuyerEmail about
+// conversation.ProductID, and stores conversation when there is none yet.
+func (repo *MemoryChatRepository) StartConversation(ctx context.Context, conversation model.Conversation) (*model.Conversation, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, stored := range repo.conversations {
+		if stored.ProductID == conversation.ProductID && stored.BuyerEmail == conversation.BuyerEmail {
+			stored.ArchivedAt = copyTime(stored.ArchivedAt)
+			return &stored, nil
+		}
+	}
+
+	if conversation.ID.IsZero() {
+		conversation.ID = primitive.NewObjectID()
+	}
+	conversation.ArchivedAt = copyTime(conversation.ArchivedAt)
+	repo.conversations[conversation.ID] = conversation
+	return &conversation, nil
+}
+
+func (repo *MemoryChatRepository) GetConversation(ctx context.Context, id primitive.ObjectID) (*model.Conversation, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	conversation, ok := repo.conversations[id]
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	conversation.ArchivedAt = copyTime(conversation.ArchivedAt)
+	return &conversation, nil
+}
+
+// GetInbox returns the conversations email takes part in, most recently
+// active first, with their unread counts. The returned cursor is empty on
+// the last page.
+func (repo *MemoryChatRepository) GetInbox(ctx context.Context, email string, page model.CursorPage) ([]model.InboxEntry, string, error) {
+	var after *pageCursor
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil || cursor.Time == nil {
+			return nil, "", ErrInvalidCursor
+		}
+		after = &cursor
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var entries []model.InboxEntry
+	for _, conversation := range repo.conversations {
+		if !conversation.HasParticipant(email) {
+			continue
+		}
+		if after != nil && !inboxLess(after.Time, after.ID, conversation) {
+			continue
+		}
+		conversation.ArchivedAt = copyTime(conversation.ArchivedAt)
+		entry := model.InboxEntry{Conversation: conversation}
+		for _, message := range repo.messages[conversation.ID] {
+			if message.SenderEmail != email && message.ReadAt == nil {
+				entry.UnreadCount++
+			}
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return inboxLess(&entries[i].LastActivityAt, entries[i].ID, entries[j].Conversation)
+	})
+
+	var next string
+	if len(entries) > page.Limit {
+		entries = entries[:page.Limit]
+		last := entries[len(entries)-1]
+		next = encodeCursor(pageCursor{ID: last.ID, Time: &last.LastActivityAt})
+	}
+	return entries, next, nil
+}
+
+// inboxLess reports whether conversation comes after the entry with the given
+// activity time and ID in the inbox, which lists the latest activity first.
+func inboxLess(at *time.Time, id primitive.ObjectID, conversation model.Conversation) bool {
+	if !conversation.LastActivityAt.Equal(*at) {
+		return conversation.LastActivityAt.Before(*at)
+	}
+	return idLess(conversation.ID, id)
+}
+
+// ReopenConversation lets an archived conversation take messages again.
+func (repo *MemoryChatRepository) ReopenConversation(ctx context.Context, id primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if conversation, ok := repo.conversations[id]; ok {
+		conversation.ArchivedAt = nil
+		conversation.ArchivedReason = ""
+		repo.conversations[id] = conversation
+	}
+	return nil
+}
+
+// ArchiveProductConversations archives every open conversation about a
+// product and returns how many were archived.
+func (repo *MemoryChatRepository) ArchiveProductConversations(ctx context.Context, productID primitive.ObjectID, reason string, at time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var archived int64
+	for id, conversation := range repo.conversations {
+		if conversation.ProductID != productID || conversation.Archived() {
+			continue
+		}
+		conversation.ArchivedAt = copyTime(&at)
+		conversation.ArchivedReason = reason
+		repo.conversations[id] = conversation
+		archived++
+	}
+	return archived, nil
+}
+
+// ArchiveUserConversations archives every open conversation email takes part
+// in and returns how many were archived.
+func (repo *MemoryChatRepository) ArchiveUserConversations(ctx context.Context, email, reason string, at time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var archived int64
+	for id, conversation := range repo.conversations {
+		if !conversation.HasParticipant(email) || conversation.Archived() {
+			continue
+		}
+		conversation.ArchivedAt = copyTime(&at)
+		conversation.ArchivedReason = reason
+		repo.conversations[id] = conversation
+		archived++
+	}
+	return archived, nil
+}
+
+// AddMessage stores a message and moves its conversation to the top of the
+// inbox. It fails with ErrConversationArchived once the conversation has
+// been archived.
+func (repo *MemoryChatRepository) AddMessage(ctx context.Context, message model.Message) (primitive.ObjectID, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	conversation, ok := repo.conversations[message.ConversationID]
+	if !ok || conversation.Archived() {
+		return primitive.NilObjectID, ErrConversationArchived
+	}
+	conversation.LastMessage = message.Body
+	conversation.LastActivityAt = message.SentAt
+	repo.conversations[conversation.ID] = conversation
+
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+	message.ReadAt = copyTime(message.ReadAt)
+	repo.messages[conversation.ID] = append(repo.messages[conversation.ID], message)
+	return message.ID, nil
+}
+
+// GetMessages returns the messages of a conversation, newest first, one page
+// at a time. The returned cursor is empty on the last page.
+func (repo *MemoryChatRepository) GetMessages(ctx context.Context, conversationID primitive.ObjectID, page model.CursorPage) ([]model.Message, string, error) {
+	var before primitive.ObjectID
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		before = cursor.ID
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var messages []model.Message
+	for _, message := range repo.messages[conversationID] {
+		if !before.IsZero() && !idLess(message.ID, before) {
+			continue
+		}
+		message.ReadAt = copyTime(message.ReadAt)
+		messages = append(messages, message)
+	}
+	sort.Slice(messages, func(i, j int) bool { return idLess(messages[j].ID, messages[i].ID) })
+
+	var next string
+	if len(messages) > page.Limit {
+		messages = messages[:page.Limit]
+		next = encodeCursor(pageCursor{ID: messages[len(messages)-1].ID})
+	}
+	return messages, next, nil
+}
+
+// MarkMessagesRead marks every message of a conversation that reader
+// received as read and returns how many were unread.
+func (repo *MemoryChatRepository) MarkMessagesRead(ctx context.Context, conversationID primitive.ObjectID, reader string, at time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var marked int64
+	messages := repo.messages[conversationID]
+	for i := range messages {
+		if messages[i].SenderEmail != reader && messages[i].ReadAt == nil {
+			messages[i].ReadAt = copyTime(&at)
+			marked++
+		}
+	}
+	return marked, nil
+}
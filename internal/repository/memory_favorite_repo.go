@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryFavoriteRepository keeps favorites in memory with the same
+// behaviour as MongoFavoriteRepository. It is safe for concurrent use and
+// meant for tests.
+type MemoryFavoriteRepository struct {
+	mu        sync.RWMutex
+	favorites map[primitive.ObjectID]model.Favorite
+}
+
+func NewMemoryFavoriteRepository() *MemoryFavoriteRepository {
+	return &MemoryFavoriteRepository{favorites: map[primitive.ObjectID]model.Favorite{}}
+}
+
+func (repo *MemoryFavoriteRepository) AddFavorite(ctx context.Context, favorite model.Favorite) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, stored := range repo.favorites {
+		if stored.UserEmail == favorite.UserEmail && stored.ProductID == favorite.ProductID {
+			return false, nil
+		}
+	}
+	if favorite.ID.IsZero() {
+		favorite.ID = primitive.NewObjectID()
+	}
+	repo.favorites[favorite.ID] = favorite
+	return true, nil
+}
+
+func (repo *MemoryFavoriteRepository) RemoveFavorite(ctx context.Context, email string, productID primitive.ObjectID) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, favorite := range repo.favorites {
+		if favorite.UserEmail == email && favorite.ProductID == productID {
+			delete(repo.favorites, id)
+			return nil
+		}
+	}
+	return ErrFavoriteNotFound
+}
+
+func (repo *MemoryFavoriteRepository) GetFavorites(ctx context.Context, email string, page model.CursorPage) ([]model.Favorite, string, error) {
+	var before primitive.ObjectID
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		before = cursor.ID
+	}
+
+	favorites := repo.findFavorites(func(favorite model.Favorite) bool {
+		return favorite.UserEmail == email && (before.IsZero() || idLess(favorite.ID, before))
+	})
+	sort.Slice(favorites, func(i, j int) bool { return idLess(favorites[j].ID, favorites[i].ID) })
+
+	var next string
+	if len(favorites) > page.Limit {
+		favorites = favorites[:page.Limit]
+		next = encodeCursor(pageCursor{ID: favorites[len(favorites)-1].ID})
+	}
+	return favorites, next, nil
+}
+
+func (repo *MemoryFavoriteRepository) GetFavoriteEmails(ctx context.Context, productID primitive.ObjectID) ([]string, error) {
+	var emails []string
+	for _, favorite := range repo.findFavorites(func(favorite model.Favorite) bool {
+		return favorite.ProductID == productID
+	}) {
+		emails = append(emails, favorite.UserEmail)
+	}
+	return emails, nil
+}
+
+func (repo *MemoryFavoriteRepository) GetFavoriteStats(ctx context.Context, email string, productIDs []primitive.ObjectID) (map[primitive.ObjectID]model.FavoriteStats, error) {
+	wanted := make(map[primitive.ObjectID]bool, len(productIDs))
+	for _, id := range productIDs {
+		wanted[id] = true
+	}
+
+	stats := map[primitive.ObjectID]model.FavoriteStats{}
+	for _, favorite := range repo.findFavorites(func(favorite model.Favorite) bool {
+		return wanted[favorite.ProductID]
+	}) {
+		entry := stats[favorite.ProductID]
+		entry.Count++
+		entry.Favorited = entry.Favorited || favorite.UserEmail == email
+		stats[favorite.ProductID] = entry
+	}
+	return stats, nil
+}
+
+func (repo *MemoryFavoriteRepository) DeleteProductFavorites(ctx context.Context, productID primitive.ObjectID) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var deleted int64
+	for id, favorite := range repo.favorites {
+		if favorite.ProductID == productID {
+			delete(repo.favorites, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (repo *MemoryFavoriteRepository) DeleteUserFavorites(ctx context.Context, email string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var deleted int64
+	for id, favorite := range repo.favorites {
+		if favorite.UserEmail == email {
+			delete(repo.favorites, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (repo *MemoryFavoriteRepository) findFavorites(match func(model.Favorite) bool) []model.Favorite {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var favorites []model.Favorite
+	for _, favorite := range repo.favorites {
+		if match(favorite) {
+			favorites = append(favorites, favorite)
+		}
+	}
+	return favorites
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryNotificationRepository keeps notifications and preferences in
+// memory with the same behaviour as MongoNotificationRepository, except that
+// old notifications are never removed. It is safe for concurrent use and
+// meant for tests.
+type MemoryNotificationRepository struct {
+	mu            sync.RWMutex
+	notifications map[primitive.ObjectID]model.Notification
+	preferences   map[string]model.NotificationPreferences
+}
+
+func NewMemoryNotificationRepository() *MemoryNotificationRepository {
+	return &MemoryNotificationRepository{
+		notifications: map[primitive.ObjectID]model.Notification{},
+		preferences:   map[string]model.NotificationPreferences{},
+	}
+}
+
+func (repo *MemoryNotificationRepository) AddNotification(ctx context.Context, notification model.Notification) (primitive.ObjectID, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if notification.ID.IsZero() {
+		notification.ID = primitive.NewObjectID()
+	}
+	notification.ReadAt = copyTime(notification.ReadAt)
+	repo.notifications[notification.ID] = notification
+	return notification.ID, nil
+}
+
+func (repo *MemoryNotificationRepository) GetNotifications(ctx context.Context, email string, unreadOnly bool, page model.CursorPage) ([]model.Notification, string, error) {
+	var before primitive.ObjectID
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		before = cursor.ID
+	}
+
+	notifications := repo.findNotifications(func(notification model.Notification) bool {
+		return notification.UserEmail == email &&
+			(!unreadOnly || notification.ReadAt == nil) &&
+			(before.IsZero() || idLess(notification.ID, before))
+	})
+	sort.Slice(notifications, func(i, j int) bool { return idLess(notifications[j].ID, notifications[i].ID) })
+
+	var next string
+	if len(notifications) > page.Limit {
+		notifications = notifications[:page.Limit]
+		next = encodeCursor(pageCursor{ID: notifications[len(notifications)-1].ID})
+	}
+	return notifications, next, nil
+}
+
+func (repo *MemoryNotificationRepository) CountUnread(ctx context.Context, email string) (int64, error) {
+	return int64(len(repo.findNotifications(func(notification model.Notification) bool {
+		return notification.UserEmail == email && notification.ReadAt == nil
+	}))), nil
+}
+
+func (repo *MemoryNotificationRepository) MarkRead(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	notification, ok := repo.notifications[id]
+	if !ok || notification.UserEmail != email {
+		return ErrNotificationNotFound
+	}
+	if notification.ReadAt == nil {
+		notification.ReadAt = &at
+		repo.notifications[id] = notification
+	}
+	return nil
+}
+
+func (repo *MemoryNotificationRepository) MarkAllRead(ctx context.Context, email string, at time.Time) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var marked int64
+	for id, notification := range repo.notifications {
+		if notification.UserEmail == email && notification.ReadAt == nil {
+			readAt := at
+			notification.ReadAt = &readAt
+			repo.notifications[id] = notification
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func (repo *MemoryNotificationRepository) GetPreferences(ctx context.Context, email string) (*model.NotificationPreferences, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	preferences, ok := repo.preferences[email]
+	if !ok {
+		return nil, ErrPreferencesNotFound
+	}
+	preferences = clonePreferences(preferences)
+	return &preferences, nil
+}
+
+func (repo *MemoryNotificationRepository) SavePreferences(ctx context.Context, preferences model.NotificationPreferences) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	repo.preferences[preferences.UserEmail] = clonePreferences(preferences)
+	return nil
+}
+
+func (repo *MemoryNotificationRepository) DeleteUserNotifications(ctx context.Context, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for id, notification := range repo.notifications {
+		if notification.UserEmail == email {
+			delete(repo.notifications, id)
+		}
+	}
+	delete(repo.preferences, email)
+	return nil
+}
+
+func (repo *MemoryNotificationRepository) findNotifications(match func(model.Notification) bool) []model.Notification {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var notifications []model.Notification
+	for _, notification := range repo.notifications {
+		if match(notification) {
+			notification.ReadAt = copyTime(notification.ReadAt)
+			notifications = append(notifications, notification)
+		}
+	}
+	return notifications
+}
+
+func clonePreferences(preferences model.NotificationPreferences) model.NotificationPreferences {
+	channels := make(map[model.NotificationCategory][]model.NotificationChannel, len(preferences.Channels))
+	for category, list := range preferences.Channels {
+		channels[category] = append([]model.NotificationChannel{}, list...)
+	}
+	preferences.Channels = channels
+	return preferences
+}
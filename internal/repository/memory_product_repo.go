@@ -0,0 +1,486 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errDuplicateProductID = errors.New("a product with this id already exists")
+
+// textWeights mirror the weights of the product_text index.
+var textWeights = map[string]int{"name": 10, "category": 5, "description": 1}
+
+// MemoryProductRepository keeps products in memory with the same behaviour
+// as MongoProductRepository. Its full-text search matches whole words and
+// negated words but, unlike MongoDB, does not stem or drop stop words. It is
+// safe for concurrent use and meant for tests.
+type MemoryProductRepository struct {
+	mu       sync.RWMutex
+	products map[primitive.ObjectID]model.Product
+}
+
+func NewMemoryProductRepository() *MemoryProductRepository {
+	return &MemoryProductRepository{products: map[primitive.ObjectID]model.Product{}}
+}
+
+func (repo *MemoryProductRepository) AddProduct(ctx context.Context, product model.Product) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if product.ID.IsZero() {
+		product.ID = primitive.NewObjectID()
+	}
+	if _, ok := repo.products[product.ID]; ok {
+		return errDuplicateProductID
+	}
+	product.Audit = model.NewAudit(product.Email, time.Now())
+	repo.products[product.ID] = cloneProduct(product)
+	return nil
+}
+
+// GetProductByID returns ErrInvalidProductID when id is not a valid ObjectID
+// and ErrProductNotFound when no product has that ID.
+func (repo *MemoryProductRepository) GetProductByID(ctx context.Context, id string) (*model.Product, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidProductID
+	}
+
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	product, ok := repo.products[objectID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	product = cloneProduct(product)
+	return &product, nil
+}
+
+func (repo *MemoryProductRepository) GetProductsByIDs(ctx context.Context, ids []primitive.ObjectID) ([]model.Product, error) {
+	wanted := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	return listings(repo.findProducts(func(product model.Product) bool {
+		return wanted[product.ID]
+	})), nil
+}
+
+func (repo *MemoryProductRepository) UpdateProduct(ctx context.Context, product model.Product) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.products[product.ID]
+	if !ok || stored.Email != product.Email {
+		return false, nil
+	}
+	repo.products[product.ID] = cloneProduct(product)
+	return true, nil
+}
+
+func (repo *MemoryProductRepository) UpdateProductImages(ctx context.Context, id primitive.ObjectID, email string, images []string) (bool, error) {
+	return repo.updateProduct(id, func(product *model.Product) bool {
+		if product.Email != email {
+			return false
+		}
+		product.Images = append([]string(nil), images...)
+		product.ImageURL = images[0]
+		product.Audit.Touch(email, time.Now())
+		return true
+	})
+}
+
+func (repo *MemoryProductRepository) UpdateProductStatus(ctx context.Context, id primitive.ObjectID, email string, change StatusChange) (bool, error) {
+	return repo.updateProduct(id, func(product *model.Product) bool {
+		if product.Email != email || product.Status.OrDefault() != change.From {
+			return false
+		}
+		product.Status = change.To
+		product.Audit.Touch(change.By, time.Now())
+		if change.To == model.StatusActive {
+			product.ExpiresAt = copyTime(change.ExpiresAt)
+			product.ExpiryWarnedAt = nil
+		}
+		return true
+	})
+}
+
+func (repo *MemoryProductRepository) GetExpiredProducts(ctx context.Context, now time.Time) ([]model.Product, error) {
+	return repo.findProducts(func(product model.Product) bool {
+		return product.Status.OrDefault() == model.StatusActive &&
+			product.ExpiresAt != nil && !product.ExpiresAt.After(now)
+	}), nil
+}
+
+func (repo *MemoryProductRepository) GetProductsToWarn(ctx context.Context, now, before time.Time) ([]model.Product, error) {
+	return repo.findProducts(func(product model.Product) bool {
+		return product.Status.OrDefault() == model.StatusActive &&
+			product.ExpiresAt != nil && product.ExpiresAt.After(now) && !product.ExpiresAt.After(before) &&
+			product.ExpiryWarnedAt == nil
+	}), nil
+}
+
+func (repo *MemoryProductRepository) MarkExpiryWarned(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := repo.updateProduct(id, func(product *model.Product) bool {
+		product.ExpiryWarnedAt = &at
+		product.Audit.Touch(model.SystemActor, at)
+		return true
+	})
+	return err
+}
+
+func (repo *MemoryProductRepository) DeleteProduct(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	product, ok := repo.products[id]
+	if !ok || product.Email != email {
+		return false, nil
+	}
+	delete(repo.products, id)
+	return true, nil
+}
+
+// GetAllProducts returns one page of products matching query, the total
+// number of matching products and the cursor of the next page, which is empty
+// on the last page. query must already be normalized.
+func (repo *MemoryProductRepository) GetAllProducts(ctx context.Context, query model.ProductQuery) ([]model.Product, int64, string, error) {
+	var cursor *pageCursor
+	if query.Cursor != "" {
+		decoded, err := decodeCursor(query.Cursor)
+		if err != nil || decoded.Sort != query.Sort {
+			return nil, 0, "", ErrInvalidCursor
+		}
+		cursor = &decoded
+	}
+
+	keyword := strings.ToLower(strings.TrimSpace(query.Keyword))
+	products := repo.findProducts(func(product model.Product) bool {
+		if !matchesAttributes(product, query) {
+			return false
+		}
+		return keyword == "" ||
+			strings.Contains(strings.ToLower(product.Name), keyword) ||
+			strings.Contains(strings.ToLower(product.Description), keyword) ||
+			strings.Contains(strings.ToLower(product.Category), keyword)
+	})
+	total := int64(len(products))
+
+	less := productLess(query.Sort)
+	sort.Slice(products, func(i, j int) bool { return less(products[i], products[j]) })
+
+	if cursor != nil {
+		last := model.Product{ID: cursor.ID, Price: cursor.Price}
+		i := sort.Search(len(products), func(i int) bool { return less(last, products[i]) })
+		products = products[i:]
+	} else {
+		products = skip(products, (query.Page-1)*query.PageSize)
+	}
+
+	var next string
+	if len(products) > query.PageSize {
+		products = products[:query.PageSize]
+		last := products[len(products)-1]
+		next = encodeCursor(pageCursor{Sort: query.Sort, ID: last.ID, Price: last.Price})
+	}
+	return listings(products), total, next, nil
+}
+
+// SearchProducts runs a full-text search for query.Keyword over the product
+// name, description and category, combined with the other filters of query.
+// Results are ranked by a weighted count of the matching words. query must
+// already be normalized.
+func (repo *MemoryProductRepository) SearchProducts(ctx context.Context, query model.ProductQuery) ([]model.ProductSearchResult, int64, error) {
+	terms, negated := parseTextSearch(query.Keyword)
+
+	var results []model.ProductSearchResult
+	for _, product := range repo.findProducts(func(product model.Product) bool {
+		return matchesAttributes(product, query)
+	}) {
+		if score := textScore(product, terms, negated); score > 0 {
+			results = append(results, model.ProductSearchResult{Product: product, Score: score})
+		}
+	}
+	total := int64(len(results))
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return idLess(results[j].ID, results[i].ID)
+	})
+	results = pageOf(results, query.Page, query.PageSize)
+	for i := range results {
+		results[i].Images = nil
+	}
+	return results, total, nil
+}
+
+// NearbyProducts returns one page of products within query.RadiusKm of the
+// query point, nearest first, and the total number of products in range.
+// Products without a location are never returned. query must already be
+// normalized.
+func (repo *MemoryProductRepository) NearbyProducts(ctx context.Context, query model.NearbyQuery) ([]model.NearbyProduct, int64, error) {
+	var results []model.NearbyProduct
+	for _, product := range repo.findProducts(func(product model.Product) bool {
+		return product.Location != nil && matchesAttributes(product, query.ProductQuery)
+	}) {
+		lng, lat := product.Location.Coordinates[0], product.Location.Coordinates[1]
+		distance := distanceKm(*query.Latitude, *query.Longitude, lat, lng)
+		if distance <= query.RadiusKm {
+			results = append(results, model.NearbyProduct{Product: product, DistanceKm: distance})
+		}
+	}
+	total := int64(len(results))
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].DistanceKm != results[j].DistanceKm {
+			return results[i].DistanceKm < results[j].DistanceKm
+		}
+		return idLess(results[i].ID, results[j].ID)
+	})
+	results = pageOf(results, query.Page, query.PageSize)
+	for i := range results {
+		results[i].Images = nil
+	}
+	return results, total, nil
+}
+
+func (repo *MemoryProductRepository) DeleteProductsByEmail(ctx context.Context, email string) ([]model.Product, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var deleted []model.Product
+	for id, product := range repo.products {
+		if product.Email == email {
+			delete(repo.products, id)
+			deleted = append(deleted, product)
+		}
+	}
+	return deleted, nil
+}
+
+// GetAllProductsByUserEmail returns the products listed by email, newest
+// first, one page at a time. Only products in one of statuses are returned,
+// or all of them when statuses is empty. The returned cursor is empty on the
+// last page.
+func (repo *MemoryProductRepository) GetAllProductsByUserEmail(ctx context.Context, email string, statuses []model.ProductStatus, page model.CursorPage) ([]model.Product, string, error) {
+	var before primitive.ObjectID
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		before = cursor.ID
+	}
+
+	products := repo.findProducts(func(product model.Product) bool {
+		if product.Email != email {
+			return false
+		}
+		if !before.IsZero() && !idLess(product.ID, before) {
+			return false
+		}
+		return len(statuses) == 0 || hasStatus(product, statuses)
+	})
+	sort.Slice(products, func(i, j int) bool { return idLess(products[j].ID, products[i].ID) })
+
+	var next string
+	if len(products) > page.Limit {
+		products = products[:page.Limit]
+		next = encodeCursor(pageCursor{ID: products[len(products)-1].ID})
+	}
+	return listings(products), next, nil
+}
+
+func (repo *MemoryProductRepository) findProducts(match func(model.Product) bool) []model.Product {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var products []model.Product
+	for _, product := range repo.products {
+		if match(product) {
+			products = append(products, cloneProduct(product))
+		}
+	}
+	return products
+}
+
+// updateProduct applies change to a stored product and keeps the result if
+// change reports true. It reports whether the product was changed.
+func (repo *MemoryProductRepository) updateProduct(id primitive.ObjectID, change func(*model.Product) bool) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	product, ok := repo.products[id]
+	if !ok {
+		return false, nil
+	}
+	product = cloneProduct(product)
+	if !change(&product) {
+		return false, nil
+	}
+	repo.products[id] = product
+	return true, nil
+}
+
+// matchesAttributes is the in-memory version of productAttributeFilter.
+func matchesAttributes(product model.Product, query model.ProductQuery) bool {
+	switch {
+	case product.Status.OrDefault() != model.StatusActive:
+		return false
+	case query.Category != "" && !strings.EqualFold(product.Category, query.Category):
+		return false
+	case query.State != "" && !strings.EqualFold(product.State, query.State):
+		return false
+	case query.Pincode != "" && product.Pincode != query.Pincode:
+		return false
+	case query.MinPrice != nil && product.Price < *query.MinPrice:
+		return false
+	case query.MaxPrice != nil && product.Price > *query.MaxPrice:
+		return false
+	}
+	return true
+}
+
+func hasStatus(product model.Product, statuses []model.ProductStatus) bool {
+	for _, status := range statuses {
+		if product.Status.OrDefault() == status {
+			return true
+		}
+	}
+	return false
+}
+
+// productLess orders products like productFindOptions.
+func productLess(order model.ProductSort) func(a, b model.Product) bool {
+	switch order {
+	case model.SortPriceAsc:
+		return func(a, b model.Product) bool {
+			if a.Price != b.Price {
+				return a.Price < b.Price
+			}
+			return idLess(a.ID, b.ID)
+		}
+	case model.SortPriceDesc:
+		return func(a, b model.Product) bool {
+			if a.Price != b.Price {
+				return a.Price > b.Price
+			}
+			return idLess(b.ID, a.ID)
+		}
+	default:
+		return func(a, b model.Product) bool { return idLess(b.ID, a.ID) }
+	}
+}
+
+func idLess(a, b primitive.ObjectID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// pageOf returns up to size items of the given 1-based page.
+func pageOf[T any](items []T, page, size int) []T {
+	items = skip(items, (page-1)*size)
+	if len(items) > size {
+		items = items[:size]
+	}
+	return items
+}
+
+func skip[T any](items []T, n int) []T {
+	if n >= len(items) {
+		return nil
+	}
+	return items[n:]
+}
+
+// listings drops the galleries, like listingProjection.
+func listings(products []model.Product) []model.Product {
+	for i := range products {
+		products[i].Images = nil
+	}
+	return products
+}
+
+// parseTextSearch splits a $text search string into the words to look for
+// and the words that must not appear. Quoted phrases count as their words.
+func parseTextSearch(search string) (terms, negated []string) {
+	for _, field := range strings.Fields(strings.ReplaceAll(search, `"`, " ")) {
+		target := &terms
+		if strings.HasPrefix(field, "-") {
+			target = &negated
+		}
+		*target = append(*target, textWords(field)...)
+	}
+	return terms, negated
+}
+
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// textScore weighs every occurrence of a search term by the field it is in,
+// or returns 0 when the product does not match.
+func textScore(product model.Product, terms, negated []string) float64 {
+	fields := map[string]string{
+		"name":        product.Name,
+		"category":    product.Category,
+		"description": product.Description,
+	}
+
+	score := 0
+	for field, text := range fields {
+		for _, word := range textWords(text) {
+			for _, term := range negated {
+				if word == term {
+					return 0
+				}
+			}
+			for _, term := range terms {
+				if word == term {
+					score += textWeights[field]
+				}
+			}
+		}
+	}
+	return float64(score)
+}
+
+// distanceKm is the great-circle distance between two points, using the
+// same Earth radius as MongoDB.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// cloneProduct copies a product so that callers cannot change stored data
+// through its pointers and slices.
+func cloneProduct(product model.Product) model.Product {
+	if product.Images != nil {
+		product.Images = append([]string(nil), product.Images...)
+	}
+	if product.Location != nil {
+		location := *product.Location
+		product.Location = &location
+	}
+	product.ExpiresAt = copyTime(product.ExpiresAt)
+	product.ExpiryWarnedAt = copyTime(product.ExpiryWarnedAt)
+	return product
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemorySavedSearchRepository keeps saved searches in memory with the same
+// behaviour as MongoSavedSearchRepository. It is safe for concurrent use and
+// meant for tests.
+type MemorySavedSearchRepository struct {
+	mu       sync.RWMutex
+	searches map[primitive.ObjectID]model.SavedSearch
+}
+
+func NewMemorySavedSearchRepository() *MemorySavedSearchRepository {
+	return &MemorySavedSearchRepository{searches: map[primitive.ObjectID]model.SavedSearch{}}
+}
+
+func (repo *MemorySavedSearchRepository) AddSavedSearch(ctx context.Context, search model.SavedSearch) (primitive.ObjectID, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	search.ID = primitive.NewObjectID()
+	repo.searches[search.ID] = cloneSavedSearch(search)
+	return search.ID, nil
+}
+
+func (repo *MemorySavedSearchRepository) CountSavedSearches(ctx context.Context, email string) (int64, error) {
+	return int64(len(repo.findSearches(func(search model.SavedSearch) bool {
+		return search.UserEmail == email
+	}))), nil
+}
+
+func (repo *MemorySavedSearchRepository) GetSavedSearch(ctx context.Context, id primitive.ObjectID) (*model.SavedSearch, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	search, ok := repo.searches[id]
+	if !ok {
+		return nil, ErrSavedSearchNotFound
+	}
+	search = cloneSavedSearch(search)
+	return &search, nil
+}
+
+func (repo *MemorySavedSearchRepository) GetSavedSearches(ctx context.Context, email string) ([]model.SavedSearch, error) {
+	searches := repo.findSearches(func(search model.SavedSearch) bool {
+		return search.UserEmail == email
+	})
+	sort.Slice(searches, func(i, j int) bool { return idLess(searches[j].ID, searches[i].ID) })
+	return searches, nil
+}
+
+func (repo *MemorySavedSearchRepository) UpdateSavedSearch(ctx context.Context, search model.SavedSearch) (bool, error) {
+	return repo.updateSearch(search.ID, func(stored *model.SavedSearch) bool {
+		if stored.UserEmail != search.UserEmail {
+			return false
+		}
+		stored.Name = search.Name
+		stored.Filters = cloneSavedSearch(search).Filters
+		stored.Frequency = search.Frequency
+		stored.UpdatedAt = search.UpdatedAt
+		stored.PendingMatches = nil
+		return true
+	}), nil
+}
+
+func (repo *MemorySavedSearchRepository) DeleteSavedSearch(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	search, ok := repo.searches[id]
+	if !ok || search.UserEmail != email {
+		return false, nil
+	}
+	delete(repo.searches, id)
+	return true, nil
+}
+
+func (repo *MemorySavedSearchRepository) DeleteUserSavedSearches(ctx context.Context, email string) (int64, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var deleted int64
+	for id, search := range repo.searches {
+		if search.UserEmail == email {
+			delete(repo.searches, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// FindMatchingSearches returns the searches of other users than the seller
+// that match product. Unlike MongoSavedSearchRepository it checks keywords
+// too, which callers do again anyway.
+func (repo *MemorySavedSearchRepository) FindMatchingSearches(ctx context.Context, product model.Product) ([]model.SavedSearch, error) {
+	return repo.findSearches(func(search model.SavedSearch) bool {
+		return search.UserEmail != product.Email && search.Filters.Matches(product)
+	}), nil
+}
+
+func (repo *MemorySavedSearchRepository) AddPendingMatch(ctx context.Context, id, productID primitive.ObjectID) error {
+	repo.updateSearch(id, func(search *model.SavedSearch) bool {
+		search.PendingMatches = append(search.PendingMatches, productID)
+		if extra := len(search.PendingMatches) - model.MaxPendingMatches; extra > 0 {
+			search.PendingMatches = search.PendingMatches[extra:]
+		}
+		return true
+	})
+	return nil
+}
+
+func (repo *MemorySavedSearchRepository) GetDueDigests(ctx context.Context, before time.Time) ([]model.SavedSearch, error) {
+	return repo.findSearches(func(search model.SavedSearch) bool {
+		return search.Frequency == model.AlertDaily && len(search.PendingMatches) > 0 &&
+			(search.LastDigestAt == nil || search.LastDigestAt.Before(before))
+	}), nil
+}
+
+func (repo *MemorySavedSearchRepository) MarkDigestSent(ctx context.Context, id primitive.ObjectID, sent []primitive.ObjectID, at time.Time) error {
+	repo.updateSearch(id, func(search *model.SavedSearch) bool {
+		listed := make(map[primitive.ObjectID]bool, len(sent))
+		for _, productID := range sent {
+			listed[productID] = true
+		}
+		var pending []primitive.ObjectID
+		for _, productID := range search.PendingMatches {
+			if !listed[productID] {
+				pending = append(pending, productID)
+			}
+		}
+		search.PendingMatches = pending
+		search.LastDigestAt = &at
+		return true
+	})
+	return nil
+}
+
+func (repo *MemorySavedSearchRepository) findSearches(match func(model.SavedSearch) bool) []model.SavedSearch {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	var searches []model.SavedSearch
+	for _, search := range repo.searches {
+		if match(search) {
+			searches = append(searches, cloneSavedSearch(search))
+		}
+	}
+	return searches
+}
+
+// updateSearch applies change to a stored search and keeps the result if
+// change reports true. It reports whether the search was changed.
+func (repo *MemorySavedSearchRepository) updateSearch(id primitive.ObjectID, change func(*model.SavedSearch) bool) bool {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	search, ok := repo.searches[id]
+	if !ok {
+		return false
+	}
+	search = cloneSavedSearch(search)
+	if !change(&search) {
+		return false
+	}
+	repo.searches[id] = search
+	return true
+}
+
+// cloneSavedSearch copies the pointers and slices of search so that callers
+// cannot change stored searches.
+func cloneSavedSearch(search model.SavedSearch) model.SavedSearch {
+	search.Filters.MinPrice = copyFloat(search.Filters.MinPrice)
+	search.Filters.MaxPrice = copyFloat(search.Filters.MaxPrice)
+	search.PendingMatches = append([]primitive.ObjectID(nil), search.PendingMatches...)
+	search.LastDigestAt = copyTime(search.LastDigestAt)
+	return search
+}
+
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+	copied := *f
+	return &copied
+}
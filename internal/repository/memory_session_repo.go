@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errDuplicateTokenHash = errors.New("refresh token hash already exists")
+
+// MemorySessionRepository keeps sessions and refresh tokens in memory with
+// the same behaviour as MongoSessionRepository, except that expired entries
+// are never purged. It is safe for concurrent use and meant for tests.
+type MemorySessionRepository struct {
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]model.Session
+	tokens   map[string]model.RefreshToken // By token hash
+}
+
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{
+		sessions: map[primitive.ObjectID]model.Session{},
+		tokens:   map[string]model.RefreshToken{},
+	}
+}
+
+func (repo *MemorySessionRepository) CreateSession(ctx context.Context, session model.Session) (primitive.ObjectID, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+	repo.sessions[session.ID] = session
+	return session.ID, nil
+}
+
+func (repo *MemorySessionRepository) GetSession(ctx context.Context, id primitive.ObjectID) (*model.Session, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	session, ok := repo.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	session.RevokedAt = copyTime(session.RevokedAt)
+	return &session, nil
+}
+
+// ExtendSession moves the expiry of a session that has not been revoked.
+func (repo *MemorySessionRepository) ExtendSession(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if session, ok := repo.sessions[id]; ok && session.RevokedAt == nil {
+		session.ExpiresAt = expiresAt
+		repo.sessions[id] = session
+	}
+	return nil
+}
+
+// RevokeSession revokes a session and with it its whole refresh token family.
+// Revoking an already revoked session keeps the original reason.
+func (repo *MemorySessionRepository) RevokeSession(ctx context.Context, id primitive.ObjectID, reason string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if session, ok := repo.sessions[id]; ok {
+		repo.revoke(session, reason)
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user.
+func (repo *MemorySessionRepository) RevokeUserSessions(ctx context.Context, email, reason string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, session := range repo.sessions {
+		if session.UserEmail == email {
+			repo.revoke(session, reason)
+		}
+	}
+	return nil
+}
+
+func (repo *MemorySessionRepository) revoke(session model.Session, reason string) {
+	if session.RevokedAt != nil {
+		return
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	session.RevokedReason = reason
+	repo.sessions[session.ID] = session
+}
+
+func (repo *MemorySessionRepository) AddRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.tokens[token.TokenHash]; ok {
+		return errDuplicateTokenHash
+	}
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	token.UsedAt = copyTime(token.UsedAt)
+	repo.tokens[token.TokenHash] = token
+	return nil
+}
+
+func (repo *MemorySessionRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	token, ok := repo.tokens[tokenHash]
+	if !ok {
+		return nil, ErrRefreshTokenNotFound
+	}
+	token.UsedAt = copyTime(token.UsedAt)
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed records that a refresh token has been rotated. It
+// reports false when the token was already used, which means it is being
+// replayed.
+func (repo *MemorySessionRepository) MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for hash, token := range repo.tokens {
+		if token.ID != id {
+			continue
+		}
+		if token.UsedAt != nil {
+			return false, nil
+		}
+		token.UsedAt = &at
+		repo.tokens[hash] = token
+		return true, nil
+	}
+	return false, nil
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
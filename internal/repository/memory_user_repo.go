@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryUserRepository keeps users in memory with the same behaviour as
+// MongoUserRepository. It is safe for concurrent use and meant for tests.
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]model.User // By email
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: map[string]model.User{}}
+}
+
+func (repo *MemoryUserRepository) AddUser(ctx context.Context, user model.User) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.users[user.Email]; ok {
+		return ErrDuplicateEmail
+	}
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	user.Audit = model.NewAudit(user.Email, time.Now())
+	repo.users[user.Email] = user
+	return nil
+}
+
+func (repo *MemoryUserRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+
+	user, ok := repo.users[email]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &user, nil
+}
+
+// GetAllUsers returns users in sign-up order, one page at a time. The returned
+// cursor is empty on the last page.
+func (repo *MemoryUserRepository) GetAllUsers(ctx context.Context, page model.CursorPage) ([]model.User, string, error) {
+	var after primitive.ObjectID
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = cursor.ID
+	}
+
+	repo.mu.RLock()
+	var users []model.User
+	for _, user := range repo.users {
+		if after.IsZero() || idLess(after, user.ID) {
+			users = append(users, user)
+		}
+	}
+	repo.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return idLess(users[i].ID, users[j].ID) })
+
+	var next string
+	if len(users) > page.Limit {
+		users = users[:page.Limit]
+		next = encodeCursor(pageCursor{ID: users[len(users)-1].ID})
+	}
+	return users, next, nil
+}
+
+func (repo *MemoryUserRepository) UpdateUserImage(ctx context.Context, userEmail string, newImageUrl string) error {
+	err := repo.updateUser(userEmail, userEmail, func(user *model.User) {
+		user.ImageURL = newImageUrl
+	})
+	if errors.Is(err, ErrUserNotFound) {
+		// Like the Mongo update, changing the image of a missing user is a no-op.
+		return nil
+	}
+	return err
+}
+
+func (repo *MemoryUserRepository) UpdateUserRole(ctx context.Context, email string, role model.Role, actor string) error {
+	return repo.updateUser(email, actor, func(user *model.User) {
+		user.Role = role
+	})
+}
+
+func (repo *MemoryUserRepository) SetUserSuspended(ctx context.Context, email string, suspended bool, actor string) error {
+	return repo.updateUser(email, actor, func(user *model.User) {
+		user.Suspended = suspended
+	})
+}
+
+func (repo *MemoryUserRepository) DeleteUser(ctx context.Context, email string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.users[email]; !ok {
+		return ErrUserNotFound
+	}
+	delete(repo.users, email)
+	return nil
+}
+
+func (repo *MemoryUserRepository) updateUser(email, actor string, change func(*model.User)) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[email]
+	if !ok {
+		return ErrUserNotFound
+	}
+	change(&user)
+	user.Audit.Touch(actor, time.Now())
+	repo.users[email] = user
+	return nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotificationNotFound = errors.New("notification not found")
+	ErrPreferencesNotFound  = errors.New("notification preferences not found")
+)
+
+type MongoNotificationRepository struct {
+	Notifications *mongo.Collection
+	Preferences   *mongo.Collection
+	Timeouts      Timeouts
+}
+
+// EnsureIndexes supports listing and counting the notifications of a user
+// and removes notifications older than model.NotificationRetention.
+func (repo *MongoNotificationRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Notifications.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("notification_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "read_at", Value: 1}},
+			Options: options.Index().SetName("notification_user_unread"),
+		},
+		{
+			Keys: bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().
+				SetName("notification_retention").
+				SetExpireAfterSeconds(int32(model.NotificationRetention.Seconds())),
+		},
+	})
+	return err
+}
+
+func (repo *MongoNotificationRepository) AddNotification(ctx context.Context, notification model.Notification) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	if notification.ID.IsZero() {
+		notification.ID = primitive.NewObjectID()
+	}
+	if _, err := repo.Notifications.InsertOne(ctx, notification); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return notification.ID, nil
+}
+
+// GetNotifications returns the notifications of email, newest first, and
+// the cursor of the next page, which is empty on the last page. With
+// unreadOnly, read notifications are left out.
+func (repo *MongoNotificationRepository) GetNotifications(ctx context.Context, email string, unreadOnly bool, page model.CursorPage) ([]model.Notification, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{"user_email": email}
+	if unreadOnly {
+		filter["read_at"] = bson.M{"$exists": false}
+	}
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(page.Limit) + 1)
+
+	cursor, err := repo.Notifications.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var notifications []model.Notification
+	if err := cursor.All(ctx, &notifications); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(notifications) > page.Limit {
+		notifications = notifications[:page.Limit]
+		next = encodeCursor(pageCursor{ID: notifications[len(notifications)-1].ID})
+	}
+	return notifications, next, nil
+}
+
+func (repo *MongoNotificationRepository) CountUnread(ctx context.Context, email string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	return repo.Notifications.CountDocuments(ctx, bson.M{"user_email": email, "read_at": bson.M{"$exists": false}})
+}
+
+// MarkRead marks a notification of email as read. It returns
+// ErrNotificationNotFound when email has no such notification, and succeeds
+// when it was already read.
+func (repo *MongoNotificationRepository) MarkRead(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "user_email": email, "read_at": bson.M{"$exists": false}}
+	result, err := repo.Notifications.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"read_at": at}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	// Nothing was unread, so either it was read before or it is not there.
+	count, err := repo.Notifications.CountDocuments(ctx, bson.M{"_id": id, "user_email": email})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotificationNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks every unread notification of email as read and returns
+// how many there were.
+func (repo *MongoNotificationRepository) MarkAllRead(ctx context.Context, email string, at time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"user_email": email, "read_at": bson.M{"$exists": false}}
+	result, err := repo.Notifications.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": at}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetPreferences returns ErrPreferencesNotFound for users who never saved
+// any.
+func (repo *MongoNotificationRepository) GetPreferences(ctx context.Context, email string) (*model.NotificationPreferences, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	var preferences model.NotificationPreferences
+	err := repo.Preferences.FindOne(ctx, bson.M{"_id": email}).Decode(&preferences)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrPreferencesNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &preferences, nil
+}
+
+func (repo *MongoNotificationRepository) SavePreferences(ctx context.Context, preferences model.NotificationPreferences) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := repo.Preferences.ReplaceOne(ctx, bson.M{"_id": preferences.UserEmail}, preferences, opts)
+	return err
+}
+
+// DeleteUserNotifications removes the notifications and preferences of email.
+func (repo *MongoNotificationRepository) DeleteUserNotifications(ctx context.Context, email string) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	if _, err := repo.Notifications.DeleteMany(ctx, bson.M{"user_email": email}); err != nil {
+		return err
+	}
+	_, err := repo.Preferences.DeleteOne(ctx, bson.M{"_id": email})
+	return err
+}
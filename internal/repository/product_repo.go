@@ -2,68 +2,546 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/liju-github/internal/model"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type ProductRepository struct {
+var (
+	ErrInvalidProductID = errors.New("invalid product id")
+	ErrProductNotFound  = errors.New("product not found")
+)
+
+// listingProjection leaves out the gallery from product listings, which only
+// show the cover image. The full gallery comes with GetProductByID.
+var listingProjection = bson.M{"images": 0}
+
+type MongoProductRepository struct {
 	Collection *mongo.Collection
+	Timeouts   Timeouts
+}
+
+// EnsureIndexes creates the indexes the product queries rely on. It is safe
+// to call on every start.
+func (repo *MongoProductRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "description", Value: "text"},
+				{Key: "category", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("product_text").
+				SetWeights(bson.D{
+					{Key: "name", Value: 10},
+					{Key: "category", Value: 5},
+					{Key: "description", Value: 1},
+				}),
+		},
+		{
+			Keys:    bson.D{{Key: "location", Value: "2dsphere"}},
+			Options: options.Index().SetName("product_location"),
+		},
+	})
+	return err
 }
 
-func (repo *ProductRepository) AddProduct(product model.Product) error {
-	_, err := repo.Collection.InsertOne(context.TODO(), product)
+func (repo *MongoProductRepository) AddProduct(ctx context.Context, product model.Product) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	product.Audit = model.NewAudit(product.Email, time.Now())
+	_, err := repo.Collection.InsertOne(ctx, product)
 	return err
 }
 
-func (repo *ProductRepository) GetProductByID(id string) (*model.Product, error) {
+// GetProductByID returns ErrInvalidProductID when id is not a valid ObjectID
+// and ErrProductNotFound when no product has that ID.
+func (repo *MongoProductRepository) GetProductByID(ctx context.Context, id string) (*model.Product, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidProductID
+	}
+
 	var product model.Product
-	objectID, _ := primitive.ObjectIDFromHex(id)
-	err := repo.Collection.FindOne(context.TODO(), bson.M{"_id": objectID}).Decode(&product)
-	return &product, err
+	err = repo.Collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// GetProductsByIDs returns the products with the given IDs, without their
+// galleries. IDs with no product are skipped.
+func (repo *MongoProductRepository) GetProductsByIDs(ctx context.Context, ids []primitive.ObjectID) ([]model.Product, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	opts := options.Find().SetProjection(listingProjection)
+	cursor, err := repo.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var products []model.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// UpdateProduct replaces a product owned by the given email. The email is part
+// of the filter so a listing can never be overwritten by another seller.
+// product.Audit is stored as given, so it must hold the stored creation fields
+// and the author and time of this change.
+func (repo *MongoProductRepository) UpdateProduct(ctx context.Context, product model.Product) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": product.ID, "email": product.Email}
+	result, err := repo.Collection.ReplaceOne(ctx, filter, product)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// UpdateProductImages replaces the gallery of a product owned by email and
+// makes its first image the cover. images must not be empty.
+func (repo *MongoProductRepository) UpdateProductImages(ctx context.Context, id primitive.ObjectID, email string, images []string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"images":     images,
+		"image_url":  images[0],
+		"updated_at": time.Now(),
+		"updated_by": email,
+	}}
+
+	result, err := repo.Collection.UpdateOne(ctx, bson.M{"_id": id, "email": email}, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// StatusChange describes a status transition for UpdateProductStatus.
+type StatusChange struct {
+	From, To model.ProductStatus
+	// ExpiresAt replaces the expiry date when the product becomes active. A
+	// nil value removes it.
+	ExpiresAt *time.Time
+	// By is recorded as the author of the change.
+	By string
 }
 
-func (repo *ProductRepository) GetAllProducts() ([]model.Product, error) {
+// UpdateProductStatus moves a product owned by email from one status to
+// another. When the product becomes active any earlier expiry warning is
+// forgotten. It reports false when the product is missing or no longer in the
+// from status, so concurrent transitions cannot both succeed.
+func (repo *MongoProductRepository) UpdateProductStatus(ctx context.Context, id primitive.ObjectID, email string, change StatusChange) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "email": email, "status": statusFilter(change.From)}
+	set := bson.M{"status": change.To, "updated_at": time.Now(), "updated_by": change.By}
+	update := bson.M{"$set": set}
+	if change.To == model.StatusActive {
+		unset := bson.M{"expiry_warned_at": ""}
+		if change.ExpiresAt != nil {
+			set["expires_at"] = *change.ExpiresAt
+		} else {
+			unset["expires_at"] = ""
+		}
+		update["$unset"] = unset
+	}
+
+	result, err := repo.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// GetExpiredProducts returns the active products whose expiry date is not
+// after now.
+func (repo *MongoProductRepository) GetExpiredProducts(ctx context.Context, now time.Time) ([]model.Product, error) {
+	return repo.findProducts(ctx, bson.M{
+		"status":     statusFilter(model.StatusActive),
+		"expires_at": bson.M{"$lte": now},
+	})
+}
+
+// GetProductsToWarn returns the active products that expire after now but no
+// later than before, and whose seller has not been warned yet.
+func (repo *MongoProductRepository) GetProductsToWarn(ctx context.Context, now, before time.Time) ([]model.Product, error) {
+	return repo.findProducts(ctx, bson.M{
+		"status":           statusFilter(model.StatusActive),
+		"expires_at":       bson.M{"$gt": now, "$lte": before},
+		"expiry_warned_at": bson.M{"$exists": false},
+	})
+}
+
+func (repo *MongoProductRepository) MarkExpiryWarned(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"expiry_warned_at": at,
+		"updated_at":       at,
+		"updated_by":       model.SystemActor,
+	}}
+	_, err := repo.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (repo *MongoProductRepository) findProducts(ctx context.Context, filter bson.M) ([]model.Product, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	cursor, err := repo.Collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
 	var products []model.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// DeleteProduct removes a product owned by the given email.
+func (repo *MongoProductRepository) DeleteProduct(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteOne(ctx, bson.M{"_id": id, "email": email})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// GetAllProducts returns one page of products matching query, the total
+// number of matching products and the cursor of the next page, which is empty
+// on the last page. query must already be normalized.
+func (repo *MongoProductRepository) GetAllProducts(ctx context.Context, query model.ProductQuery) ([]model.Product, int64, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	var products []model.Product
+	filter := buildProductFilter(query)
+
+	total, err := repo.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	opts := productFindOptions(query)
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil || cursor.Sort != query.Sort {
+			return nil, 0, "", ErrInvalidCursor
+		}
+		filter = andFilter(filter, productCursorFilter(query.Sort, cursor))
+		opts.SetSkip(0)
+	}
+	// Fetch one extra product to find out whether there is a next page.
+	opts.SetLimit(int64(query.PageSize) + 1)
 
-	cursor, err := repo.Collection.Find(context.TODO(), bson.M{})
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, 0, "", err
+	}
+
+	var next string
+	if len(products) > query.PageSize {
+		products = products[:query.PageSize]
+		last := products[len(products)-1]
+		next = encodeCursor(pageCursor{Sort: query.Sort, ID: last.ID, Price: last.Price})
+	}
+
+	return products, total, next, nil
+}
+
+// SearchProducts runs a full-text search for query.Keyword over the product
+// name, description and category, combined with the other filters of query.
+// Results are ranked by text score. query must already be normalized.
+func (repo *MongoProductRepository) SearchProducts(ctx context.Context, query model.ProductQuery) ([]model.ProductSearchResult, int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := productAttributeFilter(query)
+	filter["$text"] = bson.M{"$search": query.Keyword}
+
+	total, err := repo.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score, "images": 0}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "_id", Value: -1}}).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []model.ProductSearchResult
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// earthRadiusKm is the radius MongoDB uses for spherical geometry.
+const earthRadiusKm = 6378.1
+
+// NearbyProducts returns one page of products within query.RadiusKm of the
+// query point, nearest first, and the total number of products in range.
+// Products without a location are never returned. query must already be
+// normalized.
+func (repo *MongoProductRepository) NearbyProducts(ctx context.Context, query model.NearbyQuery) ([]model.NearbyProduct, int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	center := model.NewGeoPoint(*query.Latitude, *query.Longitude)
+	filter := productAttributeFilter(query.ProductQuery)
+
+	countFilter := productAttributeFilter(query.ProductQuery)
+	countFilter["location"] = bson.M{"$geoWithin": bson.M{
+		"$centerSphere": bson.A{center.Coordinates, query.RadiusKm / earthRadiusKm},
+	}}
+	total, err := repo.Collection.CountDocuments(ctx, countFilter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":               center,
+			"distanceField":      "distance_km",
+			"distanceMultiplier": 0.001,
+			"maxDistance":        query.RadiusKm * 1000,
+			"spherical":          true,
+			"query":              filter,
+		}}},
+		{{Key: "$skip", Value: int64((query.Page - 1) * query.PageSize)}},
+		{{Key: "$limit", Value: int64(query.PageSize)}},
+		{{Key: "$project", Value: listingProjection}},
+	}
+
+	cursor, err := repo.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var products []model.NearbyProduct
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, 0, err
+	}
+
+	return products, total, nil
+}
+
+// DeleteProductsByEmail deletes every product listed by email and returns
+// them, so that callers can tell others about each deletion. Only the
+// products that were found are deleted, so a listing added in the meantime is
+// never removed without being returned.
+func (repo *MongoProductRepository) DeleteProductsByEmail(ctx context.Context, email string) ([]model.Product, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	cursor, err := repo.Collection.Find(ctx, bson.M{"email": email})
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	if err := cursor.All(context.TODO(), &products); err != nil {
+	var products []model.Product
+	if err := cursor.All(ctx, &products); err != nil {
 		return nil, err
 	}
+	if len(products) == 0 {
+		return nil, nil
+	}
 
+	ids := make([]primitive.ObjectID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	if _, err := repo.Collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return nil, err
+	}
 	return products, nil
 }
 
-func (repo *ProductRepository) GetAllProductsByUserEmail(email string) ([]model.Product, error) {
+func buildProductFilter(query model.ProductQuery) bson.M {
+	filter := productAttributeFilter(query)
+
+	if keyword := strings.TrimSpace(query.Keyword); keyword != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(keyword), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"name": pattern},
+			bson.M{"description": pattern},
+			bson.M{"category": pattern},
+		}
+	}
+
+	return filter
+}
+
+// productAttributeFilter matches the active products that pass the category,
+// location and price filters of query. It is shared by the listing, the
+// full-text search and the distance search.
+func productAttributeFilter(query model.ProductQuery) bson.M {
+	filter := bson.M{"status": statusFilter(model.StatusActive)}
+
+	if query.Category != "" {
+		filter["category"] = exactMatchIgnoreCase(query.Category)
+	}
+	if query.State != "" {
+		filter["state"] = exactMatchIgnoreCase(query.State)
+	}
+	if query.Pincode != "" {
+		filter["pincode"] = query.Pincode
+	}
+
+	price := bson.M{}
+	if query.MinPrice != nil {
+		price["$gte"] = *query.MinPrice
+	}
+	if query.MaxPrice != nil {
+		price["$lte"] = *query.MaxPrice
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+
+	return filter
+}
+
+func productFindOptions(query model.ProductQuery) *options.FindOptions {
+	// _id is always the last sort key so that pages are stable when several
+	// products share a price. ObjectIDs grow over time, so sorting on them
+	// descending gives the newest listings first.
+	var sort bson.D
+	switch query.Sort {
+	case model.SortPriceAsc:
+		sort = bson.D{{Key: "price", Value: 1}, {Key: "_id", Value: 1}}
+	case model.SortPriceDesc:
+		sort = bson.D{{Key: "price", Value: -1}, {Key: "_id", Value: -1}}
+	default:
+		sort = bson.D{{Key: "_id", Value: -1}}
+	}
+
+	return options.Find().
+		SetProjection(listingProjection).
+		SetSort(sort).
+		SetSkip(int64((query.Page - 1) * query.PageSize)).
+		SetLimit(int64(query.PageSize))
+}
+
+// statusFilter matches any of statuses. Products stored before statuses were
+// introduced have no status field and count as active.
+func statusFilter(statuses ...model.ProductStatus) bson.M {
+	values := bson.A{}
+	for _, status := range statuses {
+		values = append(values, status)
+		if status == model.StatusActive {
+			values = append(values, nil)
+		}
+	}
+	return bson.M{"$in": values}
+}
+
+func exactMatchIgnoreCase(value string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+}
+
+// GetAllProductsByUserEmail returns the products listed by email, newest
+// first, one page at a time. Only products in one of statuses are returned,
+// or all of them when statuses is empty. The returned cursor is empty on the
+// last page.
+func (repo *MongoProductRepository) GetAllProductsByUserEmail(ctx context.Context, email string, statuses []model.ProductStatus, page model.CursorPage) ([]model.Product, string, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
 	var products []model.Product
 
 	// Find products where UserEmail matches the provided email
-	cursor, err := repo.Collection.Find(context.TODO(), bson.M{"email": email})
+	filter := bson.M{"email": email}
+	if len(statuses) > 0 {
+		filter["status"] = statusFilter(statuses...)
+	}
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		filter["_id"] = bson.M{"$lt": cursor.ID}
+	}
+
+	opts := options.Find().
+		SetProjection(listingProjection).
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetLimit(int64(page.Limit) + 1)
+
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
 	if err != nil {
-		return nil, err // Return the error if the query fails
+		return nil, "", err // Return the error if the query fails
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
 	// Decode each product found into the products slice
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		var product model.Product
 		if err := cursor.Decode(&product); err != nil {
-			return nil, err // Return the error if decoding fails
+			return nil, "", err // Return the error if decoding fails
 		}
 		products = append(products, product)
 	}
 
 	if err := cursor.Err(); err != nil {
-		return nil, err // Return any error encountered during iteration
+		return nil, "", err // Return any error encountered during iteration
+	}
+
+	var next string
+	if len(products) > page.Limit {
+		products = products[:page.Limit]
+		next = encodeCursor(pageCursor{ID: products[len(products)-1].ID})
 	}
 
-	return products, nil // Return the list of products found
+	return products, next, nil // Return the list of products found
 }
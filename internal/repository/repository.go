@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UserRepository stores user accounts. Emails are unique: adding a user
+// whose email is taken fails with ErrDuplicateEmail.
+type UserRepository interface {
+	AddUser(ctx context.Context, user model.User) error
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+	GetAllUsers(ctx context.Context, page model.CursorPage) ([]model.User, string, error)
+	UpdateUserImage(ctx context.Context, userEmail string, newImageUrl string) error
+	UpdateUserRole(ctx context.Context, email string, role model.Role, actor string) error
+	SetUserSuspended(ctx context.Context, email string, suspended bool, actor string) error
+	DeleteUser(ctx context.Context, email string) error
+}
+
+// ProductRepository stores product listings. Methods that take an email only
+// touch products listed by that seller.
+type ProductRepository interface {
+	AddProduct(ctx context.Context, product model.Product) error
+	GetProductByID(ctx context.Context, id string) (*model.Product, error)
+	GetProductsByIDs(ctx context.Context, ids []primitive.ObjectID) ([]model.Product, error)
+	UpdateProduct(ctx context.Context, product model.Product) (bool, error)
+	UpdateProductImages(ctx context.Context, id primitive.ObjectID, email string, images []string) (bool, error)
+	UpdateProductStatus(ctx context.Context, id primitive.ObjectID, email string, change StatusChange) (bool, error)
+	GetExpiredProducts(ctx context.Context, now time.Time) ([]model.Product, error)
+	GetProductsToWarn(ctx context.Context, now, before time.Time) ([]model.Product, error)
+	MarkExpiryWarned(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	DeleteProduct(ctx context.Context, id primitive.ObjectID, email string) (bool, error)
+	GetAllProducts(ctx context.Context, query model.ProductQuery) ([]model.Product, int64, string, error)
+	SearchProducts(ctx context.Context, query model.ProductQuery) ([]model.ProductSearchResult, int64, error)
+	NearbyProducts(ctx context.Context, query model.NearbyQuery) ([]model.NearbyProduct, int64, error)
+	DeleteProductsByEmail(ctx context.Context, email string) ([]model.Product, error)
+	GetAllProductsByUserEmail(ctx context.Context, email string, statuses []model.ProductStatus, page model.CursorPage) ([]model.Product, string, error)
+}
+
+// SessionRepository stores login sessions and their refresh tokens.
+type SessionRepository interface {
+	CreateSession(ctx context.Context, session model.Session) (primitive.ObjectID, error)
+	GetSession(ctx context.Context, id primitive.ObjectID) (*model.Session, error)
+	ExtendSession(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id primitive.ObjectID, reason string) error
+	RevokeUserSessions(ctx context.Context, email, reason string) error
+	AddRefreshToken(ctx context.Context, token model.RefreshToken) error
+	GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error)
+}
+
+// ChatRepository stores conversations between buyers and sellers and their
+// messages. Archived conversations refuse new messages with
+// ErrConversationArchived.
+type ChatRepository interface {
+	StartConversation(ctx context.Context, conversation model.Conversation) (*model.Conversation, error)
+	GetConversation(ctx context.Context, id primitive.ObjectID) (*model.Conversation, error)
+	GetInbox(ctx context.Context, email string, page model.CursorPage) ([]model.InboxEntry, string, error)
+	ReopenConversation(ctx context.Context, id primitive.ObjectID) error
+	ArchiveProductConversations(ctx context.Context, productID primitive.ObjectID, reason string, at time.Time) (int64, error)
+	ArchiveUserConversations(ctx context.Context, email, reason string, at time.Time) (int64, error)
+	AddMessage(ctx context.Context, message model.Message) (primitive.ObjectID, error)
+	GetMessages(ctx context.Context, conversationID primitive.ObjectID, page model.CursorPage) ([]model.Message, string, error)
+	MarkMessagesRead(ctx context.Context, conversationID primitive.ObjectID, reader string, at time.Time) (int64, error)
+}
+
+// FavoriteRepository stores the listings users saved.
+type FavoriteRepository interface {
+	AddFavorite(ctx context.Context, favorite model.Favorite) (bool, error)
+	RemoveFavorite(ctx context.Context, email string, productID primitive.ObjectID) error
+	GetFavorites(ctx context.Context, email string, page model.CursorPage) ([]model.Favorite, string, error)
+	GetFavoriteEmails(ctx context.Context, productID primitive.ObjectID) ([]string, error)
+	GetFavoriteStats(ctx context.Context, email string, productIDs []primitive.ObjectID) (map[primitive.ObjectID]model.FavoriteStats, error)
+	DeleteProductFavorites(ctx context.Context, productID primitive.ObjectID) (int64, error)
+	DeleteUserFavorites(ctx context.Context, email string) (int64, error)
+}
+
+// SavedSearchRepository stores the searches users want to hear about new
+// matches of, and the matches waiting for a daily digest.
+type SavedSearchRepository interface {
+	AddSavedSearch(ctx context.Context, search model.SavedSearch) (primitive.ObjectID, error)
+	CountSavedSearches(ctx context.Context, email string) (int64, error)
+	GetSavedSearch(ctx context.Context, id primitive.ObjectID) (*model.SavedSearch, error)
+	GetSavedSearches(ctx context.Context, email string) ([]model.SavedSearch, error)
+	UpdateSavedSearch(ctx context.Context, search model.SavedSearch) (bool, error)
+	DeleteSavedSearch(ctx context.Context, id primitive.ObjectID, email string) (bool, error)
+	DeleteUserSavedSearches(ctx context.Context, email string) (int64, error)
+	FindMatchingSearches(ctx context.Context, product model.Product) ([]model.SavedSearch, error)
+	AddPendingMatch(ctx context.Context, id, productID primitive.ObjectID) error
+	GetDueDigests(ctx context.Context, before time.Time) ([]model.SavedSearch, error)
+	MarkDigestSent(ctx context.Context, id primitive.ObjectID, sent []primitive.ObjectID, at time.Time) error
+}
+
+// NotificationRepository stores the notifications shown in-app and the
+// delivery preferences of users.
+type NotificationRepository interface {
+	AddNotification(ctx context.Context, notification model.Notification) (primitive.ObjectID, error)
+	GetNotifications(ctx context.Context, email string, unreadOnly bool, page model.CursorPage) ([]model.Notification, string, error)
+	CountUnread(ctx context.Context, email string) (int64, error)
+	MarkRead(ctx context.Context, id primitive.ObjectID, email string, at time.Time) error
+	MarkAllRead(ctx context.Context, email string, at time.Time) (int64, error)
+	GetPreferences(ctx context.Context, email string) (*model.NotificationPreferences, error)
+	SavePreferences(ctx context.Context, preferences model.NotificationPreferences) error
+	DeleteUserNotifications(ctx context.Context, email string) error
+}
+
+var (
+	_ UserRepository         = (*MongoUserRepository)(nil)
+	_ ProductRepository      = (*MongoProductRepository)(nil)
+	_ SessionRepository      = (*MongoSessionRepository)(nil)
+	_ ChatRepository         = (*MongoChatRepository)(nil)
+	_ FavoriteRepository     = (*MongoFavoriteRepository)(nil)
+	_ SavedSearchRepository  = (*MongoSavedSearchRepository)(nil)
+	_ NotificationRepository = (*MongoNotificationRepository)(nil)
+	_ UserRepository         = (*MemoryUserRepository)(nil)
+	_ ProductRepository      = (*MemoryProductRepository)(nil)
+	_ SessionRepository      = (*MemorySessionRepository)(nil)
+	_ ChatRepository         = (*MemoryChatRepository)(nil)
+	_ FavoriteRepository     = (*MemoryFavoriteRepository)(nil)
+	_ SavedSearchRepository  = (*MemorySavedSearchRepository)(nil)
+	_ NotificationRepository = (*MemoryNotificationRepository)(nil)
+)
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrSavedSearchNotFound = errors.New("saved search not found")
+
+type MongoSavedSearchRepository struct {
+	Collection *mongo.Collection
+	Timeouts   Timeouts
+}
+
+// EnsureIndexes supports listing the searches of a user and finding the
+// daily digests that are due.
+func (repo *MongoSavedSearchRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "_id", Value: -1}},
+			Options: options.Index().SetName("saved_search_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "frequency", Value: 1}, {Key: "last_digest_at", Value: 1}},
+			Options: options.Index().SetName("saved_search_digest"),
+		},
+	})
+	return err
+}
+
+func (repo *MongoSavedSearchRepository) AddSavedSearch(ctx context.Context, search model.SavedSearch) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	search.ID = primitive.NewObjectID()
+	if _, err := repo.Collection.InsertOne(ctx, search); err != nil {
+		return primitive.NilObjectID, err
+	}
+	return search.ID, nil
+}
+
+func (repo *MongoSavedSearchRepository) CountSavedSearches(ctx context.Context, email string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	return repo.Collection.CountDocuments(ctx, bson.M{"user_email": email})
+}
+
+func (repo *MongoSavedSearchRepository) GetSavedSearch(ctx context.Context, id primitive.ObjectID) (*model.SavedSearch, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	var search model.SavedSearch
+	err := repo.Collection.FindOne(ctx, bson.M{"_id": id}).Decode(&search)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSavedSearchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &search, nil
+}
+
+// GetSavedSearches returns the searches of email, newest first.
+func (repo *MongoSavedSearchRepository) GetSavedSearches(ctx context.Context, email string) ([]model.SavedSearch, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}})
+	return repo.find(ctx, bson.M{"user_email": email}, opts)
+}
+
+// UpdateSavedSearch replaces the name, filters and frequency of a search
+// saved by search.UserEmail. Pending matches are dropped, since they were
+// found with the old filters.
+func (repo *MongoSavedSearchRepository) UpdateSavedSearch(ctx context.Context, search model.SavedSearch) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": search.ID, "user_email": search.UserEmail}
+	update := bson.M{
+		"$set": bson.M{
+			"name":       search.Name,
+			"filters":    search.Filters,
+			"frequency":  search.Frequency,
+			"updated_at": search.UpdatedAt,
+		},
+		"$unset": bson.M{"pending_matches": ""},
+	}
+	result, err := repo.Collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (repo *MongoSavedSearchRepository) DeleteSavedSearch(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteOne(ctx, bson.M{"_id": id, "user_email": email})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// DeleteUserSavedSearches removes every search saved by email, together
+// with the matches waiting for its digest.
+func (repo *MongoSavedSearchRepository) DeleteUserSavedSearches(ctx context.Context, email string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteMany(ctx, bson.M{"user_email": email})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// FindMatchingSearches returns the searches of other users than the seller
+// whose category, location and price filters match product. Keywords are
+// not checked, so callers must still use SearchFilters.Matches.
+func (repo *MongoSavedSearchRepository) FindMatchingSearches(ctx context.Context, product model.Product) ([]model.SavedSearch, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	// A missing filter field matches every product.
+	filter := bson.M{
+		"user_email":       bson.M{"$ne": product.Email},
+		"filters.category": bson.M{"$in": bson.A{nil, exactMatchIgnoreCase(product.Category)}},
+		"filters.state":    bson.M{"$in": bson.A{nil, exactMatchIgnoreCase(product.State)}},
+		"filters.pincode":  bson.M{"$in": bson.A{nil, product.Pincode}},
+		"$and": bson.A{
+			bson.M{"$or": bson.A{
+				bson.M{"filters.min_price": nil},
+				bson.M{"filters.min_price": bson.M{"$lte": product.Price}},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"filters.max_price": nil},
+				bson.M{"filters.max_price": bson.M{"$gte": product.Price}},
+			}},
+		},
+	}
+	return repo.find(ctx, filter, nil)
+}
+
+// AddPendingMatch queues a product for the next digest of a search, keeping
+// the newest model.MaxPendingMatches.
+func (repo *MongoSavedSearchRepository) AddPendingMatch(ctx context.Context, id, productID primitive.ObjectID) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	update := bson.M{"$push": bson.M{"pending_matches": bson.M{
+		"$each":  bson.A{productID},
+		"$slice": -model.MaxPendingMatches,
+	}}}
+	_, err := repo.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// GetDueDigests returns the daily searches with pending matches whose last
+// digest was sent before the given time, or never.
+func (repo *MongoSavedSearchRepository) GetDueDigests(ctx context.Context, before time.Time) ([]model.SavedSearch, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+	defer cancel()
+
+	filter := bson.M{
+		"frequency":         model.AlertDaily,
+		"pending_matches.0": bson.M{"$exists": true},
+		"$or": bson.A{
+			bson.M{"last_digest_at": bson.M{"$exists": false}},
+			bson.M{"last_digest_at": bson.M{"$lt": before}},
+		},
+	}
+	return repo.find(ctx, filter, nil)
+}
+
+// MarkDigestSent removes the matches a digest listed and records when it
+// was sent. Matches queued in the meantime are kept for the next digest.
+func (repo *MongoSavedSearchRepository) MarkDigestSent(ctx context.Context, id primitive.ObjectID, sent []primitive.ObjectID, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	update := bson.M{
+		"$pullAll": bson.M{"pending_matches": sent},
+		"$set":     bson.M{"last_digest_at": at},
+	}
+	_, err := repo.Collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (repo *MongoSavedSearchRepository) find(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]model.SavedSearch, error) {
+	cursor, err := repo.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var searches []model.SavedSearch
+	if err := cursor.All(ctx, &searches); err != nil {
+		return nil, err
+	}
+	return searches, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+type MongoSessionRepository struct {
+	Sessions      *mongo.Collection
+	RefreshTokens *mongo.Collection
+	Timeouts      Timeouts
+}
+
+// EnsureIndexes creates the token lookup index and lets MongoDB delete
+// sessions and refresh tokens once they have expired.
+func (repo *MongoSessionRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.RefreshTokens.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetName("refresh_token_hash").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("refresh_token_expiry").SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.Sessions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}},
+			Options: options.Index().SetName("session_user"),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetName("session_expiry").SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (repo *MongoSessionRepository) CreateSession(ctx context.Context, session model.Session) (primitive.ObjectID, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Sessions.InsertOne(ctx, session)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return result.InsertedID.(primitive.ObjectID), nil
+}
+
+func (repo *MongoSessionRepository) GetSession(ctx context.Context, id primitive.ObjectID) (*model.Session, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	var session model.Session
+	err := repo.Sessions.FindOne(ctx, bson.M{"_id": id}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ExtendSession moves the expiry of a session that has not been revoked.
+func (repo *MongoSessionRepository) ExtendSession(ctx context.Context, id primitive.ObjectID, expiresAt time.Time) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	_, err := repo.Sessions.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"expires_at": expiresAt}})
+	return err
+}
+
+// RevokeSession revokes a session and with it its whole refresh token family.
+// Revoking an already revoked session keeps the original reason.
+func (repo *MongoSessionRepository) RevokeSession(ctx context.Context, id primitive.ObjectID, reason string) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}}
+	_, err := repo.Sessions.UpdateOne(ctx, filter, update)
+	return err
+}
+
+// RevokeUserSessions revokes every session of a user.
+func (repo *MongoSessionRepository) RevokeUserSessions(ctx context.Context, email, reason string) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"user_email": email, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": time.Now(), "revoked_reason": reason}}
+	_, err := repo.Sessions.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (repo *MongoSessionRepository) AddRefreshToken(ctx context.Context, token model.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	_, err := repo.RefreshTokens.InsertOne(ctx, token)
+	return err
+}
+
+func (repo *MongoSessionRepository) GetRefreshToken(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
+	var token model.RefreshToken
+	err := repo.RefreshTokens.FindOne(ctx, bson.M{"token_hash": tokenHash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed records that a refresh token has been rotated. It
+// reports false when the token was already used, which means it is being
+// replayed.
+func (repo *MongoSessionRepository) MarkRefreshTokenUsed(ctx context.Context, id primitive.ObjectID, at time.Time) (bool, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	filter := bson.M{"_id": id, "used_at": bson.M{"$exists": false}}
+	result, err := repo.RefreshTokens.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": at}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
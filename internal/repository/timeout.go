@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Timeouts bound how long a single MongoDB operation may take on top of
+// whatever deadline the caller's context already carries. A zero value means
+// no extra deadline for that kind of operation.
+type Timeouts struct {
+	Read  time.Duration // single-document lookups
+	Write time.Duration // inserts, updates and deletes
+	Query time.Duration // listings, searches and counts
+}
+
+// withTimeout derives the context one operation runs with.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// IsTimeout reports whether err means an operation ran out of time, either
+// because of one of the Timeouts or because the caller's deadline passed.
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err)
+}
@@ -3,51 +3,155 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/liju-github/internal/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-type UserRepository struct {
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrDuplicateEmail = errors.New("a user with this email already exists")
+)
+
+type MongoUserRepository struct {
 	Collection *mongo.Collection
+	Timeouts   Timeouts
 }
 
-func (repo *UserRepository) AddUser(user model.User) error {
-	_, err := repo.Collection.InsertOne(context.TODO(), user)
+// EnsureIndexes makes emails unique, which is what turns a second sign-up
+// with the same email into ErrDuplicateEmail even when both race.
+func (repo *MongoUserRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := repo.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("user_email").SetUnique(true),
+	})
 	return err
 }
 
-func (repo *UserRepository) GetUserByEmail(email string) (*model.User, error) {
+func (repo *MongoUserRepository) AddUser(ctx context.Context, user model.User) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	user.Audit = model.NewAudit(user.Email, time.Now())
+	_, err := repo.Collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (repo *MongoUserRepository) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Read)
+	defer cancel()
+
 	var user model.User
-	err := repo.Collection.FindOne(context.TODO(), bson.M{"email": email}).Decode(&user)
+	err := repo.Collection.FindOne(ctx, bson.M{"email": email}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
-		return nil, errors.New("user not found")
+		return nil, err
 	}
 	return &user, nil
 }
 
-func (repo *UserRepository) GetAllUsers() ([]model.User, error) {
+// GetAllUsers returns users in sign-up order, one page at a time. The returned
+// cursor is empty on the last page.
+func (repo *MongoUserRepository) GetAllUsers(ctx context.Context, page model.CursorPage) ([]model.User, string, error) {
+    ctx, cancel := withTimeout(ctx, repo.Timeouts.Query)
+    defer cancel()
+
     var users []model.User
-    
-    cursor, err := repo.Collection.Find(context.TODO(), bson.M{})
+
+    filter := bson.M{}
+    if page.Cursor != "" {
+        cursor, err := decodeCursor(page.Cursor)
+        if err != nil {
+            return nil, "", err
+        }
+        filter["_id"] = bson.M{"$gt": cursor.ID}
+    }
+
+    opts := options.Find().
+        SetSort(bson.D{{Key: "_id", Value: 1}}).
+        SetLimit(int64(page.Limit) + 1)
+
+    cursor, err := repo.Collection.Find(ctx, filter, opts)
     if err != nil {
-        return nil, err
+        return nil, "", err
     }
-    defer cursor.Close(context.TODO()) 
+    defer cursor.Close(ctx) 
 
-    if err := cursor.All(context.TODO(), &users); err != nil {
-        return nil, err
+    if err := cursor.All(ctx, &users); err != nil {
+        return nil, "", err
     }
 
-    return users, nil
+    var next string
+    if len(users) > page.Limit {
+        users = users[:page.Limit]
+        next = encodeCursor(pageCursor{ID: users[len(users)-1].ID})
+    }
+
+    return users, next, nil
 }
 
 
-func (repo *UserRepository) UpdateUserImage(ctx context.Context, userEmail string, newImageUrl string) error {
+func (repo *MongoUserRepository) UpdateUserImage(ctx context.Context, userEmail string, newImageUrl string) error {
+    ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+    defer cancel()
+
     filter := bson.M{"email": userEmail}
-    update := bson.M{"$set": bson.M{"image_url": newImageUrl}}
+    update := bson.M{"$set": bson.M{
+        "image_url":  newImageUrl,
+        "updated_at": time.Now(),
+        "updated_by": userEmail,
+    }}
 
     _, err := repo.Collection.UpdateOne(ctx, filter, update)
     return err
-}
\ No newline at end of file
+}
+
+// UpdateUserRole sets the role of a user. actor is recorded as the author of
+// the change.
+func (repo *MongoUserRepository) UpdateUserRole(ctx context.Context, email string, role model.Role, actor string) error {
+	return repo.updateUser(ctx, email, bson.M{"role": role}, actor)
+}
+
+// SetUserSuspended suspends or reinstates a user.
+func (repo *MongoUserRepository) SetUserSuspended(ctx context.Context, email string, suspended bool, actor string) error {
+	return repo.updateUser(ctx, email, bson.M{"suspended": suspended}, actor)
+}
+
+func (repo *MongoUserRepository) DeleteUser(ctx context.Context, email string) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	result, err := repo.Collection.DeleteOne(ctx, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (repo *MongoUserRepository) updateUser(ctx context.Context, email string, set bson.M, actor string) error {
+	ctx, cancel := withTimeout(ctx, repo.Timeouts.Write)
+	defer cancel()
+
+	set["updated_at"] = time.Now()
+	set["updated_by"] = actor
+
+	result, err := repo.Collection.UpdateOne(ctx, bson.M{"email": email}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
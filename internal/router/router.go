@@ -0,0 +1,155 @@
+// Package router builds the HTTP API. cmd/main.go and the tests use the same
+// constructor, so the tests exercise exactly the routes that are served.
+package router
+
+import (
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/liju-github/internal/auth"
+	"github.com/liju-github/internal/config"
+	"github.com/liju-github/internal/controller"
+	"github.com/liju-github/internal/middleware"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+	"github.com/liju-github/internal/service"
+)
+
+// Deps are the services the API is built from.
+type Deps struct {
+	UserRepo            repository.UserRepository // Used by the auth middleware
+	UserService         *service.UserService
+	ProductService      *service.ProductService
+	SessionService      *service.SessionService
+	AdminService        *service.AdminService
+	MediaService        *service.MediaService
+	ChatService         *service.ChatService
+	FavoriteService     *service.FavoriteService
+	SavedSearchService  *service.SavedSearchService
+	NotificationService *service.NotificationService
+	Gateway             *realtime.Gateway
+	Keys                *auth.KeySet
+	CORS                config.CORSConfig
+}
+
+// New registers every route of the API on a new gin engine.
+func New(deps Deps) *gin.Engine {
+	// Controllers
+	userController := &controller.UserController{
+		UserService:    deps.UserService,
+		ProductService: deps.ProductService,
+		SessionService: deps.SessionService,
+	}
+	keysController := &controller.KeysController{Keys: deps.Keys}
+	productController := &controller.ProductController{
+		ProductService:  deps.ProductService,
+		FavoriteService: deps.FavoriteService,
+	}
+	adminController := &controller.AdminController{
+		AdminService: deps.AdminService,
+		UserService:  deps.UserService,
+	}
+	chatController := &controller.ChatController{ChatService: deps.ChatService}
+	favoriteController := &controller.FavoriteController{FavoriteService: deps.FavoriteService}
+	savedSearchController := &controller.SavedSearchController{SavedSearchService: deps.SavedSearchService}
+	notificationController := &controller.NotificationController{NotificationService: deps.NotificationService}
+	realtimeController := &controller.RealtimeController{
+		Gateway:        deps.Gateway,
+		ChatService:    deps.ChatService,
+		ProductService: deps.ProductService,
+		SessionService: deps.SessionService,
+	}
+	mediaController := &controller.MediaController{
+		MediaService:   deps.MediaService,
+		UserService:    deps.UserService,
+		ProductService: deps.ProductService,
+	}
+
+	// The WebSocket takes the access token in the query string, so the
+	// access log must not print it.
+	router := gin.New()
+	router.Use(middleware.RedactedLogger("access_token"), gin.Recovery())
+	corsConfig := cors.Config{
+		AllowOrigins:     deps.CORS.AllowedOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: deps.CORS.AllowCredentials,
+		MaxAge:           deps.CORS.MaxAge,
+	}
+
+	router.Use(cors.New(corsConfig))
+
+	router.POST("/signup", userController.Signup)
+	router.POST("/login", userController.Login)
+	router.POST("/refresh", userController.Refresh)
+	router.GET("/products/:id", productController.GetProduct)
+	router.GET("/media/*key", mediaController.ServeMedia)
+	router.GET("/.well-known/jwks.json", keysController.JWKS)
+
+	requireAuth := middleware.AuthMiddleware(deps.UserRepo, deps.SessionService)
+
+	authRoutes := router.Group("/")
+	authRoutes.Use(requireAuth)
+
+	authRoutes.POST("/logout", userController.Logout)
+	authRoutes.POST("/addproduct", productController.AddProduct)
+	authRoutes.GET("/getproducts", productController.GetAllProducts)
+	authRoutes.GET("/products/search", productController.SearchProducts)
+	authRoutes.GET("/products/nearby", productController.NearbyProducts)
+	authRoutes.PUT("/products/:id", productController.UpdateProduct)
+	authRoutes.PATCH("/products/:id", productController.UpdateProduct)
+	authRoutes.DELETE("/products/:id", productController.DeleteProduct)
+	authRoutes.POST("/products/:id/publish", productController.ChangeStatus(model.ActionPublish))
+	authRoutes.POST("/products/:id/mark-sold", productController.ChangeStatus(model.ActionMarkSold))
+	authRoutes.POST("/products/:id/relist", productController.ChangeStatus(model.ActionRelist))
+	authRoutes.POST("/products/:id/archive", productController.ChangeStatus(model.ActionArchive))
+	authRoutes.POST("/products/:id/renew", productController.ChangeStatus(model.ActionRenew))
+	authRoutes.POST("/products/:id/images", productController.AddImage)
+	authRoutes.PUT("/products/:id/images", productController.ReorderImages)
+	authRoutes.DELETE("/products/:id/images", productController.RemoveImage)
+	authRoutes.PUT("/products/:id/images/cover", productController.SetCoverImage)
+	authRoutes.POST("/products/:id/images/upload", mediaController.UploadProductImage)
+	authRoutes.POST("/products/:id/favorite", favoriteController.AddFavorite)
+	authRoutes.DELETE("/products/:id/favorite", favoriteController.RemoveFavorite)
+	authRoutes.GET("/favorites", favoriteController.ListFavorites)
+	authRoutes.POST("/saved-searches", savedSearchController.CreateSavedSearch)
+	authRoutes.GET("/saved-searches", savedSearchController.ListSavedSearches)
+	authRoutes.GET("/saved-searches/:id", savedSearchController.GetSavedSearch)
+	authRoutes.PUT("/saved-searches/:id", savedSearchController.UpdateSavedSearch)
+	authRoutes.DELETE("/saved-searches/:id", savedSearchController.DeleteSavedSearch)
+	authRoutes.GET("/notifications", notificationController.ListNotifications)
+	authRoutes.GET("/notifications/unread-count", notificationController.UnreadCount)
+	authRoutes.POST("/notifications/read-all", notificationController.MarkAllRead)
+	authRoutes.POST("/notifications/:id/read", notificationController.MarkRead)
+	authRoutes.GET("/notifications/preferences", notificationController.GetPreferences)
+	authRoutes.PUT("/notifications/preferences", notificationController.UpdatePreferences)
+	authRoutes.GET("/allusers", middleware.RequireRole(model.RoleAdmin), userController.GetAllUsers)
+	authRoutes.GET("/profile", userController.GetProfile)
+	authRoutes.POST("/uploadprofile", userController.UpdateImage)
+	authRoutes.POST("/uploadprofile/image", mediaController.UploadProfileImage)
+	authRoutes.GET("/sellerprofile", userController.GetSellerProfile)
+	authRoutes.POST("/conversations", chatController.StartConversation)
+	authRoutes.GET("/conversations", chatController.Inbox)
+	authRoutes.GET("/conversations/:id", chatController.GetConversation)
+	authRoutes.GET("/conversations/:id/messages", chatController.GetMessages)
+	authRoutes.POST("/conversations/:id/messages", chatController.SendMessage)
+	authRoutes.POST("/conversations/:id/read", chatController.MarkRead)
+
+	// Browsers cannot set headers on WebSocket requests.
+	router.GET("/ws", middleware.TokenFromQuery("access_token"), requireAuth, realtimeController.Connect)
+
+	adminRoutes := router.Group("/admin")
+	adminRoutes.Use(requireAuth)
+
+	adminRoutes.DELETE("/products/:id", middleware.RequireRole(model.RoleModerator, model.RoleAdmin), adminController.RemoveProduct)
+
+	adminOnly := adminRoutes.Group("/", middleware.RequireRole(model.RoleAdmin))
+	adminOnly.GET("/users", adminController.ListUsers)
+	adminOnly.POST("/users/:email/suspend", adminController.SuspendUser)
+	adminOnly.POST("/users/:email/unsuspend", adminController.UnsuspendUser)
+	adminOnly.PUT("/users/:email/role", adminController.SetUserRole)
+	adminOnly.DELETE("/users/:email", adminController.DeleteUser)
+
+	return router
+}
@@ -0,0 +1,1756 @@
+package router_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/liju-github/internal/auth"
+	"github.com/liju-github/internal/config"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+	"github.com/liju-github/internal/router"
+	"github.com/liju-github/internal/service"
+	"github.com/liju-github/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecret = "test-secret-that-is-at-least-32-bytes"
+
+// testAPI is the full HTTP API backed by in-memory repositories.
+type testAPI struct {
+	t        *testing.T
+	handler  http.Handler
+	gateway  *realtime.Gateway
+	mail     *recordingMailer
+	queue    *service.DeliveryQueue
+	mediaDir string                      // Where uploads are stored
+	users    *service.UserService        // For making admins
+	searches *service.SavedSearchService // For running the digest job
+}
+
+// recordingMailer keeps the emails sent by the email notification channel.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []string
+}
+
+func (m *recordingMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, to+": "+subject)
+	return nil
+}
+
+// emails waits for the queued emails and returns those sent since the last
+// call.
+func (api *testAPI) emails() []string {
+	api.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := api.queue.Flush(ctx); err != nil {
+		api.t.Fatal(err)
+	}
+	return api.mail.take()
+}
+
+// take returns the emails sent since the last call.
+func (m *recordingMailer) take() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := m.sent
+	m.sent = nil
+	return sent
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	return newTestAPIWithProducts(t, repository.NewMemoryProductRepository())
+}
+
+// newTestAPIWithProducts is newTestAPI with the given product repository.
+func newTestAPIWithProducts(t *testing.T, productRepo repository.ProductRepository) *testAPI {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	keys, err := auth.NewKeySet([]auth.KeyConfig{{ID: "test", Algorithm: auth.AlgorithmHS256, Secret: testSecret}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userRepo := repository.NewMemoryUserRepository()
+	sessionRepo := repository.NewMemorySessionRepository()
+	events := &service.ProductEvents{}
+	hub := realtime.NewInProcessHub(0)
+	gateway := realtime.NewGateway(hub, []string{"*"}, time.Minute)
+	mediaDir := t.TempDir()
+
+	mail := &recordingMailer{}
+	queue := service.NewDeliveryQueue(2, 100, time.Second)
+	t.Cleanup(func() { queue.Close(context.Background()) })
+	notificationRepo := repository.NewMemoryNotificationRepository()
+	notifier := &service.NotificationService{
+		Repo: notificationRepo,
+		Channels: []service.DeliveryChannel{
+			service.InAppChannel{Repo: notificationRepo, Hub: hub},
+			service.EmailChannel{Mailer: mail},
+		},
+		Queue: queue,
+	}
+
+	userService := &service.UserService{UserRepo: userRepo, BcryptCost: bcrypt.MinCost}
+	chatRepo := repository.NewMemoryChatRepository()
+	chatService := &service.ChatService{ChatRepo: chatRepo, ProductRepo: productRepo, Notifier: notifier, Hub: hub}
+	favoriteRepo := repository.NewMemoryFavoriteRepository()
+	favoriteService := &service.FavoriteService{
+		FavoriteRepo: favoriteRepo,
+		ProductRepo:  productRepo,
+		Notifier:     notifier,
+		Hub:          hub,
+	}
+	events.Subscribe(chatService.HandleProductEvent)
+	searchRepo := repository.NewMemorySavedSearchRepository()
+	savedSearchService := &service.SavedSearchService{
+		SearchRepo:     searchRepo,
+		ProductRepo:    productRepo,
+		Notifier:       notifier,
+		Hub:            hub,
+		DigestInterval: 24 * time.Hour,
+	}
+	events.Subscribe(favoriteService.HandleProductEvent)
+	events.Subscribe(savedSearchService.HandleProductEvent)
+	events.Subscribe(service.ProductStatusPublisher(hub))
+
+	handler := router.New(router.Deps{
+		UserRepo:       userRepo,
+		UserService:    userService,
+		ProductService: &service.ProductService{ProductRepo: productRepo, ListingTTL: time.Hour, Notifier: notifier, Events: events},
+		SessionService: &service.SessionService{
+			SessionRepo:     sessionRepo,
+			UserRepo:        userRepo,
+			AccessTokenTTL:  time.Minute,
+			RefreshTokenTTL: time.Hour,
+			Keys:            keys,
+		},
+		AdminService: &service.AdminService{
+			UserRepo:         userRepo,
+			ProductRepo:      productRepo,
+			SessionRepo:      sessionRepo,
+			ChatRepo:         chatRepo,
+			FavoriteRepo:     favoriteRepo,
+			SearchRepo:       searchRepo,
+			NotificationRepo: notificationRepo,
+			Events:           events,
+			Notifier:         notifier,
+		},
+		MediaService: &service.MediaService{
+			Storage:        &storage.FileSystem{Dir: mediaDir, BaseURL: "http://media.test/media"},
+			MaxUploadBytes: 1 << 20,
+		},
+		ChatService:         chatService,
+		FavoriteService:     favoriteService,
+		SavedSearchService:  savedSearchService,
+		NotificationService: notifier,
+		Gateway:             gateway,
+		Keys:                keys,
+		CORS:                config.Default().CORS,
+	})
+	return &testAPI{t: t, handler: handler, gateway: gateway, mail: mail, queue: queue, mediaDir: mediaDir, users: userService, searches: savedSearchService}
+}
+
+// do sends a request and checks that the response never exposes a password.
+// body is sent as is when it is a string or a multipart upload, and encoded
+// as JSON otherwise.
+func (api *testAPI) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	api.t.Helper()
+
+	var reader io.Reader
+	contentType := "application/json"
+	switch body := body.(type) {
+	case nil:
+	case multipartBody:
+		reader, contentType = body.reader, body.contentType
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			api.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	api.handler.ServeHTTP(rec, req)
+
+	assertNoPassword(api.t, method+" "+path, rec.Body.Bytes())
+	return rec
+}
+
+// expect sends a request and fails the test unless it gets status. It
+// returns the decoded JSON response.
+func (api *testAPI) expect(status int, method, path, token string, body interface{}) map[string]interface{} {
+	api.t.Helper()
+	rec := api.do(method, path, token, body)
+	if rec.Code != status {
+		api.t.Fatalf("%s %s = %d %s, want %d", method, path, rec.Code, rec.Body.String(), status)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		api.t.Fatalf("%s %s: response is not JSON: %s", method, path, rec.Body.String())
+	}
+	return decoded
+}
+
+// with returns a copy of api that reports failures to t, for subtests.
+func (api *testAPI) with(t *testing.T) *testAPI {
+	copied := *api
+	copied.t = t
+	return &copied
+}
+
+func (api *testAPI) signup(name, email, password string) {
+	api.t.Helper()
+	api.expect(http.StatusOK, "POST", "/signup", "", map[string]string{
+		"name": name, "email": email, "password": password,
+	})
+}
+
+func (api *testAPI) login(email, password string) string {
+	api.t.Helper()
+	body := api.expect(http.StatusOK, "POST", "/login", "", map[string]string{
+		"email": email, "password": password,
+	})
+	token, _ := body["access_token"].(string)
+	if token == "" {
+		api.t.Fatalf("login returned no access token: %v", body)
+	}
+	return token
+}
+
+// assertNoPassword fails if any key of a JSON response mentions a password
+// or the body contains something that looks like a bcrypt hash.
+func assertNoPassword(t *testing.T, name string, body []byte) {
+	t.Helper()
+	if bytes.Contains(body, []byte("$2a$")) {
+		t.Fatalf("%s: response contains a password hash: %s", name, body)
+	}
+	var decoded interface{}
+	if json.Unmarshal(body, &decoded) != nil {
+		return
+	}
+	var walk func(interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for key, child := range value {
+				if strings.Contains(strings.ToLower(key), "password") {
+					t.Fatalf("%s: response has a %q key: %s", name, key, body)
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(decoded)
+}
+
+type multipartBody struct {
+	reader      io.Reader
+	contentType string
+}
+
+func imageUpload(t *testing.T, filename string, data []byte) multipartBody {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+	return multipartBody{reader: &buf, contentType: writer.FormDataContentType()}
+}
+
+func pngImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 48; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func validProduct(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"description": "Barely used, with lights and a bell",
+		"category":    "Bicycles",
+		"price":       4500,
+		"image_url":   "https://images.example.com/" + strings.ReplaceAll(name, " ", "-") + ".jpg",
+		"address":     "12 MG Road",
+		"state":       "Karnataka",
+		"pincode":     "560001",
+	}
+}
+
+func TestSignup(t *testing.T) {
+	api := newTestAPI(t)
+
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+
+	body := api.expect(http.StatusConflict, "POST", "/signup", "", map[string]string{
+		"name": "Another Asha", "email": "asha@example.com", "password": "whatever",
+	})
+	if body["error"] == nil {
+		t.Errorf("duplicate signup has no error message: %v", body)
+	}
+
+	invalid := map[string]interface{}{
+		"missing name":   map[string]string{"email": "b@example.com", "password": "x"},
+		"invalid email":  map[string]string{"name": "B", "email": "not-an-email", "password": "x"},
+		"missing pass":   map[string]string{"name": "B", "email": "b@example.com"},
+		"malformed JSON": `{"name": "B",`,
+	}
+	for name, request := range invalid {
+		t.Run(name, func(t *testing.T) {
+			api.with(t).expect(http.StatusBadRequest, "POST", "/signup", "", request)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+
+	body := api.expect(http.StatusOK, "POST", "/login", "", map[string]string{
+		"email": "asha@example.com", "password": "s3cret-pass",
+	})
+	for _, key := range []string{"access_token", "refresh_token", "token", "user"} {
+		if body[key] == nil {
+			t.Errorf("login response is missing %q: %v", key, body)
+		}
+	}
+	if user := body["user"].(map[string]interface{}); user["email"] != "asha@example.com" || user["role"] != "user" {
+		t.Errorf("login user = %v", user)
+	}
+
+	api.expect(http.StatusUnauthorized, "POST", "/login", "", map[string]string{
+		"email": "asha@example.com", "password": "wrong",
+	})
+	api.expect(http.StatusUnauthorized, "POST", "/login", "", map[string]string{
+		"email": "nobody@example.com", "password": "s3cret-pass",
+	})
+	api.expect(http.StatusBadRequest, "POST", "/login", "", map[string]string{
+		"email": "asha@example.com",
+	})
+	api.expect(http.StatusBadRequest, "POST", "/login", "", "not json")
+}
+
+func TestRefresh(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	login := func() map[string]interface{} {
+		t.Helper()
+		return api.expect(http.StatusOK, "POST", "/login", "", map[string]string{
+			"email": "asha@example.com", "password": "s3cret-pass",
+		})
+	}
+	refresh := func(status int, token interface{}) map[string]interface{} {
+		t.Helper()
+		return api.expect(status, "POST", "/refresh", "", map[string]interface{}{"refresh_token": token})
+	}
+
+	api.expect(http.StatusBadRequest, "POST", "/refresh", "", map[string]string{})
+	refresh(http.StatusUnauthorized, "not-a-refresh-token")
+
+	// Every refresh rotates the refresh token.
+	first := login()
+	second := refresh(http.StatusOK, first["refresh_token"])
+	if second["refresh_token"] == first["refresh_token"] || second["access_token"] == "" {
+		t.Fatalf("refresh returned %v, want a new token pair", second)
+	}
+	api.expect(http.StatusOK, "GET", "/profile", second["access_token"].(string), nil)
+	third := refresh(http.StatusOK, second["refresh_token"])
+
+	// Replaying a used refresh token revokes the whole session, including
+	// the tokens issued since.
+	refresh(http.StatusUnauthorized, first["refresh_token"])
+	refresh(http.StatusUnauthorized, third["refresh_token"])
+	api.expect(http.StatusUnauthorized, "GET", "/profile", third["access_token"].(string), nil)
+
+	// Other sessions are not affected, until they log out.
+	other := login()
+	api.expect(http.StatusOK, "GET", "/profile", other["access_token"].(string), nil)
+	api.expect(http.StatusOK, "POST", "/logout", other["access_token"].(string), nil)
+	refresh(http.StatusUnauthorized, other["refresh_token"])
+}
+
+func TestProtectedRoutesRequireValidToken(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+
+	otherKeys, err := auth.NewKeySet([]auth.KeyConfig{{ID: "test", Algorithm: auth.AlgorithmHS256, Secret: strings.Repeat("x", 32)}}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := otherKeys.GenerateJWT("asha@example.com", model.RoleAdmin, "000000000000000000000000", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	product := "/products/000000000000000000000000"
+	routes := []struct{ method, path string }{
+		{"GET", "/profile"},
+		{"POST", "/uploadprofile"},
+		{"POST", "/uploadprofile/image"},
+		{"GET", "/sellerprofile?email=asha@example.com"},
+		{"GET", "/allusers"},
+		{"POST", "/addproduct"},
+		{"GET", "/getproducts"},
+		{"GET", "/products/search?q=bicycle"},
+		{"GET", "/products/nearby?lat=12.9762&lng=77.6033"},
+		{"PUT", product},
+		{"PATCH", product},
+		{"DELETE", product},
+		{"POST", product + "/publish"},
+		{"POST", product + "/mark-sold"},
+		{"POST", product + "/relist"},
+		{"POST", product + "/archive"},
+		{"POST", product + "/renew"},
+		{"POST", product + "/images"},
+		{"PUT", product + "/images"},
+		{"DELETE", product + "/images?url=https://images.example.com/a.jpg"},
+		{"PUT", product + "/images/cover"},
+		{"POST", product + "/images/upload"},
+		{"POST", product + "/favorite"},
+		{"DELETE", product + "/favorite"},
+		{"GET", "/favorites"},
+		{"POST", "/saved-searches"},
+		{"GET", "/saved-searches"},
+		{"GET", "/saved-searches/000000000000000000000000"},
+		{"PUT", "/saved-searches/000000000000000000000000"},
+		{"DELETE", "/saved-searches/000000000000000000000000"},
+		{"GET", "/notifications"},
+		{"GET", "/notifications/unread-count"},
+		{"POST", "/notifications/read-all"},
+		{"POST", "/notifications/000000000000000000000000/read"},
+		{"GET", "/notifications/preferences"},
+		{"PUT", "/notifications/preferences"},
+		{"POST", "/conversations"},
+		{"GET", "/conversations"},
+		{"GET", "/conversations/000000000000000000000000"},
+		{"GET", "/conversations/000000000000000000000000/messages"},
+		{"POST", "/conversations/000000000000000000000000/messages"},
+		{"POST", "/conversations/000000000000000000000000/read"},
+		{"POST", "/logout"},
+		{"GET", "/ws"},
+		{"GET", "/admin/users"},
+		{"POST", "/admin/users/asha@example.com/suspend"},
+		{"POST", "/admin/users/asha@example.com/unsuspend"},
+		{"PUT", "/admin/users/asha@example.com/role"},
+		{"DELETE", "/admin/users/asha@example.com"},
+		{"DELETE", "/admin" + product},
+	}
+
+	// Every route outside the public ones has to be in the table above.
+	public := map[string]bool{
+		"POST /signup":               true,
+		"POST /login":                true,
+		"POST /refresh":              true,
+		"GET /products/:id":          true,
+		"GET /media/*key":            true,
+		"GET /.well-known/jwks.json": true,
+	}
+	for _, registered := range api.handler.(*gin.Engine).Routes() {
+		if public[registered.Method+" "+registered.Path] {
+			continue
+		}
+		listed := false
+		for _, route := range routes {
+			path, _, _ := strings.Cut(route.path, "?")
+			if route.method == registered.Method && matchesRoute(registered.Path, path) {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			t.Errorf("%s %s is not checked for authentication", registered.Method, registered.Path)
+		}
+	}
+
+	tokens := map[string]string{
+		"no token":      "",
+		"garbage token": "not-a-jwt",
+		"forged token":  forged,
+	}
+	for _, route := range routes {
+		for name, bad := range tokens {
+			t.Run(route.method+" "+route.path+" with "+name, func(t *testing.T) {
+				api.with(t).expect(http.StatusUnauthorized, route.method, route.path, bad, nil)
+			})
+		}
+	}
+
+	// The token works until the session is logged out.
+	api.expect(http.StatusOK, "GET", "/profile", token, nil)
+	api.expect(http.StatusOK, "POST", "/logout", token, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/profile", token, nil)
+
+	// Plain users cannot reach admin routes.
+	token = api.login("asha@example.com", "s3cret-pass")
+	for _, route := range routes {
+		if route.path == "/allusers" || strings.HasPrefix(route.path, "/admin/") {
+			t.Run(route.method+" "+route.path+" as a user", func(t *testing.T) {
+				api.with(t).expect(http.StatusForbidden, route.method, route.path, token, nil)
+			})
+		}
+	}
+}
+
+// matchesRoute reports whether path is served by the gin route pattern.
+func matchesRoute(pattern, path string) bool {
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(path, "/")
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "*") {
+			return i < len(pathParts)
+		}
+		if i >= len(pathParts) || (!strings.HasPrefix(part, ":") && part != pathParts[i]) {
+			return false
+		}
+	}
+	return len(patternParts) == len(pathParts)
+}
+
+func TestProfile(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+
+	profile := api.expect(http.StatusOK, "GET", "/profile", token, nil)["profile"].(map[string]interface{})
+	if profile["email"] != "asha@example.com" || profile["name"] != "Asha" {
+		t.Errorf("profile = %v", profile)
+	}
+
+	api.expect(http.StatusOK, "POST", "/uploadprofile", token, map[string]string{
+		"image_url": "https://images.example.com/asha.png",
+	})
+	profile = api.expect(http.StatusOK, "GET", "/profile", token, nil)["profile"].(map[string]interface{})
+	if profile["image_url"] != "https://images.example.com/asha.png" {
+		t.Errorf("profile image = %v, want the uploaded URL", profile["image_url"])
+	}
+	api.expect(http.StatusBadRequest, "POST", "/uploadprofile", token, map[string]string{})
+	api.expect(http.StatusBadRequest, "GET", "/profile?limit=1000", token, nil)
+
+	body := api.expect(http.StatusOK, "POST", "/uploadprofile/image", token, imageUpload(t, "me.png", pngImage(t)))
+	uploaded := body["image"].(map[string]interface{})
+	url, _ := uploaded["url"].(string)
+	if !strings.HasPrefix(url, "http://media.test/media/images/") {
+		t.Fatalf("uploaded image URL = %q", url)
+	}
+	profile = api.expect(http.StatusOK, "GET", "/profile", token, nil)["profile"].(map[string]interface{})
+	if profile["image_url"] != url {
+		t.Errorf("profile image = %v, want %v", profile["image_url"], url)
+	}
+
+	// The stored file is served back.
+	rec := api.do("GET", strings.TrimPrefix(url, "http://media.test"), "", nil)
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "image/") {
+		t.Errorf("GET uploaded image = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, dir := range []string{"/media/images", "/media/images/"} {
+		if rec := api.do("GET", dir, "", nil); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", dir, rec.Code)
+		}
+	}
+
+	api.expect(http.StatusUnsupportedMediaType, "POST", "/uploadprofile/image", token, imageUpload(t, "me.png", []byte("definitely not an image")))
+	api.expect(http.StatusBadRequest, "POST", "/uploadprofile/image", token, map[string]string{})
+}
+
+func TestProducts(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	cheap := validProduct("blue bicycle")
+	cheap["price"] = 1500
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, cheap)
+	draft := validProduct("green bicycle")
+	draft["status"] = "draft"
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, draft)
+
+	invalid := map[string]func(map[string]interface{}){
+		"missing name":     func(p map[string]interface{}) { delete(p, "name") },
+		"negative price":   func(p map[string]interface{}) { p["price"] = -1 },
+		"short pincode":    func(p map[string]interface{}) { p["pincode"] = "5600" },
+		"image not a URL":  func(p map[string]interface{}) { p["image_url"] = "bicycle.jpg" },
+		"sold on creation": func(p map[string]interface{}) { p["status"] = "sold" },
+	}
+	for name, change := range invalid {
+		t.Run(name, func(t *testing.T) {
+			product := validProduct("broken bicycle")
+			change(product)
+			api.with(t).expect(http.StatusBadRequest, "POST", "/addproduct", seller, product)
+		})
+	}
+	api.expect(http.StatusBadRequest, "POST", "/addproduct", seller, `{"name":`)
+
+	// Buyers see both active listings but not the draft.
+	body := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)
+	products := body["products"].([]interface{})
+	if len(products) != 2 {
+		t.Fatalf("listed %d products, want 2: %v", len(products), products)
+	}
+	if total := body["pagination"].(map[string]interface{})["total"]; total != float64(2) {
+		t.Errorf("total = %v, want 2", total)
+	}
+
+	body = api.expect(http.StatusOK, "GET", "/getproducts?sort=price_asc&max_price=2000", buyer, nil)
+	products = body["products"].([]interface{})
+	if len(products) != 1 || products[0].(map[string]interface{})["name"] != "blue bicycle" {
+		t.Errorf("filtered products = %v, want only the blue bicycle", products)
+	}
+
+	body = api.expect(http.StatusOK, "GET", "/getproducts?page_size=1", buyer, nil)
+	next := body["pagination"].(map[string]interface{})["next_cursor"].(string)
+	body = api.expect(http.StatusOK, "GET", "/getproducts?page_size=1&cursor="+next, buyer, nil)
+	if products = body["products"].([]interface{}); len(products) != 1 {
+		t.Errorf("second page has %d products, want 1", len(products))
+	}
+
+	for _, query := range []string{"sort=cheapest", "min_price=10&max_price=5", "page_size=1000", "pincode=12", "cursor=bogus"} {
+		api.expect(http.StatusBadRequest, "GET", "/getproducts?"+query, buyer, nil)
+	}
+
+	// The seller's profile shows only active listings to others, while the
+	// seller sees the draft too.
+	profile := api.expect(http.StatusOK, "GET", "/sellerprofile?email=asha@example.com", buyer, nil)["data"].(map[string]interface{})
+	if listed := profile["products"].([]interface{}); len(listed) != 2 {
+		t.Errorf("seller profile lists %d products, want 2", len(listed))
+	}
+	own := api.expect(http.StatusOK, "GET", "/profile", seller, nil)["profile"].(map[string]interface{})
+	if listed := own["products"].([]interface{}); len(listed) != 3 {
+		t.Errorf("own profile lists %d products, want 3", len(listed))
+	}
+	api.expect(http.StatusBadRequest, "GET", "/sellerprofile", buyer, nil)
+	api.expect(http.StatusNotFound, "GET", "/sellerprofile?email=nobody@example.com", buyer, nil)
+
+	// Single products are public.
+	id := products[0].(map[string]interface{})["id"].(string)
+	product := api.expect(http.StatusOK, "GET", "/products/"+id, "", nil)["product"].(map[string]interface{})
+	if product["email"] != "asha@example.com" {
+		t.Errorf("product = %v", product)
+	}
+	api.expect(http.StatusBadRequest, "GET", "/products/not-an-id", "", nil)
+	api.expect(http.StatusNotFound, "GET", "/products/000000000000000000000000", "", nil)
+
+	// Only the seller can change a listing.
+	api.expect(http.StatusForbidden, "DELETE", "/products/"+id, buyer, nil)
+	api.expect(http.StatusOK, "DELETE", "/products/"+id, seller, nil)
+	api.expect(http.StatusNotFound, "GET", "/products/"+id, "", nil)
+}
+
+// addProduct lists product as token's user and returns its ID.
+func TestSearchProducts(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", token, validProduct("red bicycle"))
+	helmet := validProduct("helmet")
+	helmet["category"] = "Accessories"
+	helmet["description"] = "Fits any bicycle & comes with a bicycle bell"
+	api.expect(http.StatusOK, "POST", "/addproduct", token, helmet)
+	pump := validProduct("bicycle pump")
+	pump["category"] = "Accessories"
+	pump["description"] = "Works with every bicycle"
+	pump["price"] = 800
+	api.expect(http.StatusOK, "POST", "/addproduct", token, pump)
+	api.expect(http.StatusOK, "POST", "/addproduct", token, validProduct("sofa"))
+
+	search := func(query string) []interface{} {
+		t.Helper()
+		return api.expect(http.StatusOK, "GET", "/products/search?"+query, token, nil)["results"].([]interface{})
+	}
+	names := func(results []interface{}) []string {
+		var names []string
+		for _, result := range results {
+			names = append(names, result.(map[string]interface{})["name"].(string))
+		}
+		return names
+	}
+
+	// A match in the name outweighs one in the description.
+	results := search("q=bicycle")
+	if got := names(results); fmt.Sprint(got) != "[bicycle pump red bicycle helmet]" {
+		t.Errorf("search order = %v, want the best match first", got)
+	}
+	snippets := map[string]string{
+		"red bicycle": "red <mark>bicycle</mark>",
+		"helmet":      "Fits any <mark>bicycle</mark> &amp; comes with a <mark>bicycle</mark> bell",
+	}
+	for _, result := range results {
+		result := result.(map[string]interface{})
+		if want, ok := snippets[result["name"].(string)]; ok && result["snippet"] != want {
+			t.Errorf("snippet of %v = %q, want %q", result["name"], result["snippet"], want)
+		}
+	}
+
+	// The listing filters narrow the matches.
+	for query, want := range map[string]string{
+		"q=bicycle&category=Accessories": "[bicycle pump helmet]",
+		"q=bicycle&max_price=1000":       "[bicycle pump]",
+		"q=bicycle&pincode=110001":       "[]",
+		"q=bicycle -pump":                "[red bicycle helmet]",
+		"q=sofa":                         "[sofa]",
+		"q=piano":                        "[]",
+	} {
+		if got := names(search(strings.ReplaceAll(query, " ", "+"))); fmt.Sprint(got) != want {
+			t.Errorf("search %q = %v, want %v", query, got, want)
+		}
+	}
+
+	api.expect(http.StatusBadRequest, "GET", "/products/search", token, nil)
+	api.expect(http.StatusBadRequest, "GET", "/products/search?q=bicycle&page_size=1000", token, nil)
+}
+
+func TestNearbyProducts(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+
+	// Listings only have a pincode and are placed on the map from it.
+	for name, pincode := range map[string]string{
+		"kochi bicycle":     "682001",
+		"ernakulam bicycle": "682011",
+		"kottayam bicycle":  "686001",
+		"thrissur bicycle":  "680001",
+		"unknown bicycle":   "999999",
+	} {
+		product := validProduct(name)
+		product["state"] = "Kerala"
+		product["pincode"] = pincode
+		api.expect(http.StatusOK, "POST", "/addproduct", token, product)
+	}
+	chair := validProduct("kochi chair")
+	chair["category"] = "Furniture"
+	chair["pincode"] = "682001"
+	api.expect(http.StatusOK, "POST", "/addproduct", token, chair)
+
+	nearby := func(query string) []map[string]interface{} {
+		t.Helper()
+		var products []map[string]interface{}
+		for _, product := range api.expect(http.StatusOK, "GET", "/products/nearby?lat=9.9658&lng=76.2421&"+query, token, nil)["products"].([]interface{}) {
+			products = append(products, product.(map[string]interface{}))
+		}
+		return products
+	}
+
+	products := nearby("radius_km=60&category=Bicycles")
+	want := []struct {
+		name     string
+		distance float64
+	}{{"kochi bicycle", 0}, {"ernakulam bicycle", 6.6}, {"kottayam bicycle", 51.8}}
+	if len(products) != len(want) {
+		t.Fatalf("nearby products = %v, want %d", products, len(want))
+	}
+	for i, product := range products {
+		distance, _ := product["distance_km"].(float64)
+		if product["name"] != want[i].name || math.Abs(distance-want[i].distance) > 0.1 {
+			t.Errorf("nearby product %d = %v at %v km, want %s at %v km", i, product["name"], distance, want[i].name, want[i].distance)
+		}
+	}
+	location, _ := products[0]["location"].(map[string]interface{})
+	if fmt.Sprint(location["coordinates"]) != "[76.2421 9.9658]" {
+		t.Errorf("location resolved from the pincode = %v", location)
+	}
+
+	for query, want := range map[string]int{
+		"":                              3, // The default radius is 10 km
+		"radius_km=70":                  5,
+		"radius_km=70&max_price=1000":   0,
+		"radius_km=1&category=Bicycles": 1,
+	} {
+		if got := len(nearby(query)); got != want {
+			t.Errorf("nearby %q returned %d products, want %d", query, got, want)
+		}
+	}
+
+	api.expect(http.StatusBadRequest, "GET", "/products/nearby", token, nil)
+	api.expect(http.StatusBadRequest, "GET", "/products/nearby?lat=100&lng=76.2421", token, nil)
+	api.expect(http.StatusBadRequest, "GET", "/products/nearby?lat=9.9658&lng=76.2421&radius_km=1000", token, nil)
+}
+
+func (api *testAPI) addProduct(token string, product map[string]interface{}) string {
+	api.t.Helper()
+	api.expect(http.StatusOK, "POST", "/addproduct", token, product)
+	profile := api.expect(http.StatusOK, "GET", "/profile", token, nil)["profile"].(map[string]interface{})
+	return profile["products"].([]interface{})[0].(map[string]interface{})["id"].(string)
+}
+
+func TestUpdateProduct(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	id := api.addProduct(seller, validProduct("red bicycle"))
+
+	// PATCH changes only the fields it sends.
+	patched := api.expect(http.StatusOK, "PATCH", "/products/"+id, seller, map[string]interface{}{"price": 3900})["product"].(map[string]interface{})
+	if patched["price"] != float64(3900) || patched["name"] != "red bicycle" {
+		t.Errorf("patched product = %v", patched)
+	}
+
+	// PUT replaces every field, so a partial body fails validation.
+	api.expect(http.StatusBadRequest, "PUT", "/products/"+id, seller, map[string]interface{}{"price": 3000})
+	replaced := validProduct("blue bicycle")
+	replaced["email"] = "ravi@example.com"
+	product := api.expect(http.StatusOK, "PUT", "/products/"+id, seller, replaced)["product"].(map[string]interface{})
+	if product["name"] != "blue bicycle" || product["email"] != "asha@example.com" {
+		t.Errorf("replaced product = %v, want the new fields and the same seller", product)
+	}
+
+	for name, body := range map[string]interface{}{
+		"negative price": map[string]interface{}{"price": -1},
+		"short pincode":  map[string]interface{}{"pincode": "12"},
+		"empty name":     map[string]interface{}{"name": ""},
+		"not JSON":       `{"price":`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			api.with(t).expect(http.StatusBadRequest, "PATCH", "/products/"+id, seller, body)
+		})
+	}
+
+	// Statuses only change through the lifecycle actions.
+	patched = api.expect(http.StatusOK, "PATCH", "/products/"+id, seller, map[string]interface{}{"status": "sold"})["product"].(map[string]interface{})
+	stored := api.expect(http.StatusOK, "GET", "/products/"+id, "", nil)["product"].(map[string]interface{})
+	if patched["status"] != "active" || stored["status"] != "active" {
+		t.Errorf("status after patching it = %v, stored %v, want active", patched["status"], stored["status"])
+	}
+	if patched["updated_at"] != stored["updated_at"] || patched["updated_at"] == product["updated_at"] {
+		t.Errorf("updated_at after patching = %v, stored %v, before %v", patched["updated_at"], stored["updated_at"], product["updated_at"])
+	}
+
+	api.expect(http.StatusForbidden, "PATCH", "/products/"+id, buyer, map[string]interface{}{"price": 1})
+	api.expect(http.StatusBadRequest, "PATCH", "/products/not-an-id", seller, map[string]interface{}{"price": 1})
+	api.expect(http.StatusNotFound, "PUT", "/products/000000000000000000000000", seller, validProduct("red bicycle"))
+}
+
+func TestProductStatusActions(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	draft := validProduct("red bicycle")
+	draft["status"] = "draft"
+	id := api.addProduct(seller, draft)
+
+	steps := []struct {
+		action string
+		status int
+		want   string
+	}{
+		{"mark-sold", http.StatusConflict, ""},
+		{"publish", http.StatusOK, "active"},
+		{"publish", http.StatusConflict, ""},
+		{"renew", http.StatusOK, "active"},
+		{"mark-sold", http.StatusOK, "sold"},
+		{"renew", http.StatusConflict, ""},
+		{"relist", http.StatusOK, "active"},
+		{"archive", http.StatusOK, "archived"},
+		{"mark-sold", http.StatusConflict, ""},
+		{"relist", http.StatusOK, "active"},
+	}
+	for _, step := range steps {
+		body := api.expect(step.status, "POST", "/products/"+id+"/"+step.action, seller, nil)
+		if step.want == "" {
+			continue
+		}
+		if status := body["product"].(map[string]interface{})["status"]; status != step.want {
+			t.Errorf("%s led to %v, want %s", step.action, status, step.want)
+		}
+	}
+
+	api.expect(http.StatusForbidden, "POST", "/products/"+id+"/mark-sold", buyer, nil)
+	api.expect(http.StatusBadRequest, "POST", "/products/not-an-id/publish", seller, nil)
+	api.expect(http.StatusNotFound, "POST", "/products/000000000000000000000000/archive", seller, nil)
+}
+
+func TestProductImages(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	id := api.addProduct(seller, validProduct("red bicycle"))
+	images := "/products/" + id + "/images"
+	cover := "https://images.example.com/red-bicycle.jpg"
+	side := "https://images.example.com/side.jpg"
+
+	gallery := func(body map[string]interface{}) []interface{} {
+		t.Helper()
+		return body["images"].([]interface{})
+	}
+
+	if got := gallery(api.expect(http.StatusOK, "POST", images, seller, map[string]string{"url": side})); len(got) != 2 || got[1] != side {
+		t.Errorf("gallery after adding = %v", got)
+	}
+	if got := gallery(api.expect(http.StatusOK, "PUT", images+"/cover", seller, map[string]string{"url": side})); got[0] != side {
+		t.Errorf("gallery after setting the cover = %v, want the side view first", got)
+	}
+	if got := gallery(api.expect(http.StatusOK, "PUT", images, seller, map[string]interface{}{"images": []string{cover, side}})); got[0] != cover {
+		t.Errorf("gallery after reordering = %v", got)
+	}
+	if got := gallery(api.expect(http.StatusOK, "DELETE", images+"?url="+side, seller, nil)); len(got) != 1 {
+		t.Errorf("gallery after removing = %v", got)
+	}
+	product := api.expect(http.StatusOK, "GET", "/products/"+id, "", nil)["product"].(map[string]interface{})
+	if product["image_url"] != cover {
+		t.Errorf("product = %v, want the cover image kept", product)
+	}
+
+	uploaded := api.expect(http.StatusOK, "POST", images+"/upload", seller, imageUpload(t, "bike.png", pngImage(t)))
+	if got := gallery(uploaded); len(got) != 2 || !strings.HasPrefix(got[1].(string), "http://media.test/media/") {
+		t.Errorf("gallery after uploading = %v", got)
+	}
+
+	// Validation and ownership.
+	api.expect(http.StatusBadRequest, "DELETE", images, seller, nil)
+	api.expect(http.StatusNotFound, "DELETE", images+"?url=https://images.example.com/unknown.jpg", seller, nil)
+	api.expect(http.StatusNotFound, "PUT", images+"/cover", seller, map[string]string{"url": "https://images.example.com/unknown.jpg"})
+	api.expect(http.StatusBadRequest, "POST", images, seller, map[string]string{"url": "not a url"})
+	api.expect(http.StatusBadRequest, "PUT", images, seller, map[string]interface{}{"images": []string{}})
+	api.expect(http.StatusBadRequest, "PUT", images, seller, map[string]interface{}{"images": []string{cover}})
+	api.expect(http.StatusForbidden, "POST", images, buyer, map[string]string{"url": side})
+	api.expect(http.StatusForbidden, "POST", images+"/upload", buyer, imageUpload(t, "bike.png", pngImage(t)))
+	api.expect(http.StatusUnsupportedMediaType, "POST", images+"/upload", seller, imageUpload(t, "bike.png", []byte("not an image")))
+
+	// A full gallery is refused before anything is stored.
+	for i := 2; i < model.MaxProductImages; i++ {
+		api.expect(http.StatusOK, "POST", images, seller, map[string]string{"url": fmt.Sprintf("https://images.example.com/%d.jpg", i)})
+	}
+	stored := api.storedMedia()
+	api.expect(http.StatusBadRequest, "POST", images+"/upload", seller, imageUpload(t, "bike.png", pngImage(t)))
+	if got := api.storedMedia(); got != stored {
+		t.Errorf("stored files after uploading to a full gallery = %d, want %d", got, stored)
+	}
+}
+
+// vanishingProductRepository loses every product before its gallery is saved.
+type vanishingProductRepository struct {
+	repository.ProductRepository
+}
+
+func (vanishingProductRepository) UpdateProductImages(ctx context.Context, id primitive.ObjectID, email string, images []string) (bool, error) {
+	return false, nil
+}
+
+func TestFailedUploadsAreNotKept(t *testing.T) {
+	api := newTestAPIWithProducts(t, vanishingProductRepository{repository.NewMemoryProductRepository()})
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	id := api.addProduct(seller, validProduct("red bicycle"))
+
+	api.expect(http.StatusNotFound, "POST", "/products/"+id+"/images/upload", seller, imageUpload(t, "bike.png", pngImage(t)))
+	if got := api.storedMedia(); got != 0 {
+		t.Errorf("stored files after a failed upload = %d, want 0", got)
+	}
+}
+
+// storedMedia counts the files in the upload storage.
+func (api *testAPI) storedMedia() int {
+	api.t.Helper()
+	count := 0
+	err := filepath.WalkDir(api.mediaDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		api.t.Fatal(err)
+	}
+	return count
+}
+
+func TestAdmin(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	if err := api.users.BootstrapAdmin(context.Background(), "meera@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	admin := api.login("meera@example.com", "th1rd-pass")
+
+	for _, path := range []string{"/allusers", "/admin/users"} {
+		if users := api.expect(http.StatusOK, "GET", path, admin, nil)["users"].([]interface{}); len(users) != 3 {
+			t.Errorf("%s listed %d users, want 3", path, len(users))
+		}
+	}
+	api.expect(http.StatusBadRequest, "GET", "/admin/users?cursor=bogus", admin, nil)
+
+	// Roles: moderators may remove listings but nothing else.
+	role := "/admin/users/ravi@example.com/role"
+	api.expect(http.StatusBadRequest, "PUT", role, admin, map[string]string{"role": "owner"})
+	api.expect(http.StatusBadRequest, "PUT", role, admin, "not json")
+	api.expect(http.StatusBadRequest, "PUT", "/admin/users/meera@example.com/role", admin, map[string]string{"role": "user"})
+	api.expect(http.StatusNotFound, "PUT", "/admin/users/nobody@example.com/role", admin, map[string]string{"role": "moderator"})
+	api.expect(http.StatusOK, "PUT", role, admin, map[string]string{"role": "moderator"})
+	id := api.addProduct(seller, validProduct("red bicycle"))
+	api.expect(http.StatusForbidden, "GET", "/admin/users", buyer, nil)
+	api.expect(http.StatusBadRequest, "DELETE", "/admin/products/not-an-id", buyer, nil)
+	api.expect(http.StatusNotFound, "DELETE", "/admin/products/000000000000000000000000", buyer, nil)
+	api.expect(http.StatusOK, "DELETE", "/admin/products/"+id, buyer, nil)
+	api.expect(http.StatusNotFound, "GET", "/products/"+id, "", nil)
+
+	// Suspension ends sessions and refuses logins until lifted.
+	api.expect(http.StatusBadRequest, "POST", "/admin/users/meera@example.com/suspend", admin, nil)
+	api.expect(http.StatusNotFound, "POST", "/admin/users/nobody@example.com/suspend", admin, nil)
+	api.expect(http.StatusOK, "POST", "/admin/users/asha@example.com/suspend", admin, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/profile", seller, nil)
+	api.expect(http.StatusForbidden, "POST", "/login", "", map[string]string{"email": "asha@example.com", "password": "s3cret-pass"})
+	api.expect(http.StatusOK, "POST", "/admin/users/asha@example.com/unsuspend", admin, nil)
+	seller = api.login("asha@example.com", "s3cret-pass")
+
+	// Deleting a user ends their account.
+	api.expect(http.StatusBadRequest, "DELETE", "/admin/users/meera@example.com", admin, nil)
+	api.expect(http.StatusNotFound, "DELETE", "/admin/users/nobody@example.com", admin, nil)
+	api.expect(http.StatusOK, "DELETE", "/admin/users/asha@example.com", admin, nil)
+	api.expect(http.StatusUnauthorized, "GET", "/profile", seller, nil)
+	api.expect(http.StatusUnauthorized, "POST", "/login", "", map[string]string{"email": "asha@example.com", "password": "s3cret-pass"})
+}
+
+func TestChat(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	outsider := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	listed := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)["products"].([]interface{})
+	productID := listed[0].(map[string]interface{})["id"].(string)
+
+	start := map[string]interface{}{"product_id": productID, "message": "Is it still available?"}
+	api.expect(http.StatusBadRequest, "POST", "/conversations", seller, start)
+	api.expect(http.StatusNotFound, "POST", "/conversations", buyer, map[string]interface{}{"product_id": "000000000000000000000000"})
+	conversation := api.expect(http.StatusOK, "POST", "/conversations", buyer, start)["conversation"].(map[string]interface{})
+	id := conversation["id"].(string)
+	if conversation["seller_email"] != "asha@example.com" || conversation["last_message"] != "Is it still available?" {
+		t.Errorf("conversation = %v", conversation)
+	}
+
+	// Starting again returns the same conversation.
+	again := api.expect(http.StatusOK, "POST", "/conversations", buyer, map[string]interface{}{"product_id": productID})
+	if again["conversation"].(map[string]interface{})["id"] != id {
+		t.Errorf("second start created another conversation: %v", again)
+	}
+
+	api.expect(http.StatusOK, "POST", "/conversations/"+id+"/messages", buyer, map[string]interface{}{"body": "I can pick it up today."})
+	api.expect(http.StatusBadRequest, "POST", "/conversations/"+id+"/messages", buyer, map[string]interface{}{"body": "   "})
+	api.expect(http.StatusBadRequest, "POST", "/conversations/"+id+"/messages", buyer, map[string]interface{}{"body": strings.Repeat("a", 4001)})
+
+	// Only the buyer and the seller see the thread.
+	for _, path := range []string{"/conversations/" + id, "/conversations/" + id + "/messages"} {
+		api.expect(http.StatusForbidden, "GET", path, outsider, nil)
+	}
+	api.expect(http.StatusForbidden, "POST", "/conversations/"+id+"/messages", outsider, map[string]interface{}{"body": "Hello?"})
+	api.expect(http.StatusForbidden, "POST", "/conversations/"+id+"/read", outsider, nil)
+	api.expect(http.StatusBadRequest, "GET", "/conversations/not-an-id", buyer, nil)
+	api.expect(http.StatusNotFound, "GET", "/conversations/000000000000000000000000", buyer, nil)
+
+	unread := func(token string) float64 {
+		t.Helper()
+		inbox := api.expect(http.StatusOK, "GET", "/conversations", token, nil)["conversations"].([]interface{})
+		if len(inbox) != 1 {
+			t.Fatalf("inbox has %d conversations, want 1", len(inbox))
+		}
+		return inbox[0].(map[string]interface{})["unread_count"].(float64)
+	}
+	if got := unread(seller); got != 2 {
+		t.Errorf("seller has %v unread messages, want 2", got)
+	}
+	if got := unread(buyer); got != 0 {
+		t.Errorf("buyer has %v unread messages, want 0", got)
+	}
+	if inbox := api.expect(http.StatusOK, "GET", "/conversations", outsider, nil)["conversations"].([]interface{}); len(inbox) != 0 {
+		t.Errorf("outsider inbox = %v, want empty", inbox)
+	}
+
+	messages := api.expect(http.StatusOK, "GET", "/conversations/"+id+"/messages", seller, nil)["messages"].([]interface{})
+	if len(messages) != 2 || messages[0].(map[string]interface{})["body"] != "I can pick it up today." {
+		t.Errorf("messages = %v, want both, newest first", messages)
+	}
+
+	marked := api.expect(http.StatusOK, "POST", "/conversations/"+id+"/read", seller, nil)
+	if marked["marked_read"] != float64(2) {
+		t.Errorf("marked = %v, want 2", marked)
+	}
+	if got := unread(seller); got != 0 {
+		t.Errorf("seller has %v unread messages after reading, want 0", got)
+	}
+	api.expect(http.StatusOK, "POST", "/conversations/"+id+"/messages", seller, map[string]interface{}{"body": "Yes, come by at 5."})
+	if got := unread(buyer); got != 1 {
+		t.Errorf("buyer has %v unread messages, want 1", got)
+	}
+
+	// Selling the product archives the conversation.
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/mark-sold", seller, nil)
+	archived := api.expect(http.StatusOK, "GET", "/conversations/"+id, buyer, nil)["conversation"].(map[string]interface{})
+	if archived["archived_reason"] != "product sold" {
+		t.Errorf("conversation after sale = %v", archived)
+	}
+	api.expect(http.StatusConflict, "POST", "/conversations/"+id+"/messages", buyer, map[string]interface{}{"body": "Too late?"})
+	api.expect(http.StatusConflict, "POST", "/conversations", outsider, map[string]interface{}{"product_id": productID})
+
+	// Relisting lets the buyer pick the conversation up again, and deleting
+	// the product archives it for good.
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/relist", seller, nil)
+	api.expect(http.StatusOK, "POST", "/conversations", buyer, map[string]interface{}{"product_id": productID, "message": "Back on sale?"})
+	api.expect(http.StatusOK, "DELETE", "/products/"+productID, seller, nil)
+	api.expect(http.StatusConflict, "POST", "/conversations/"+id+"/messages", seller, map[string]interface{}{"body": "Sorry, gone."})
+	archived = api.expect(http.StatusOK, "GET", "/conversations/"+id, seller, nil)["conversation"].(map[string]interface{})
+	if archived["archived_reason"] != "product deleted" {
+		t.Errorf("conversation after deletion = %v", archived)
+	}
+}
+
+func TestDeletingUserRemovesTheirListingsEverywhere(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	if err := api.users.BootstrapAdmin(context.Background(), "meera@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	admin := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	listed := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)["products"].([]interface{})
+	productID := listed[0].(map[string]interface{})["id"].(string)
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/favorite", buyer, nil)
+	start := map[string]interface{}{"product_id": productID, "message": "Is it still available?"}
+	id := api.expect(http.StatusOK, "POST", "/conversations", buyer, start)["conversation"].(map[string]interface{})["id"].(string)
+
+	api.expect(http.StatusOK, "DELETE", "/admin/users/asha@example.com", admin, nil)
+
+	archived := api.expect(http.StatusOK, "GET", "/conversations/"+id, buyer, nil)["conversation"].(map[string]interface{})
+	if archived["archived_reason"] != "product deleted" {
+		t.Errorf("conversation after deleting the seller = %v, want it archived", archived)
+	}
+	if favorites := api.expect(http.StatusOK, "GET", "/favorites", buyer, nil)["favorites"].([]interface{}); len(favorites) != 0 {
+		t.Errorf("favorites after deleting the seller = %v, want none", favorites)
+	}
+	api.expect(http.StatusNotFound, "GET", "/products/"+productID, buyer, nil)
+}
+
+func TestDeletingUserRemovesTheirData(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	if err := api.users.BootstrapAdmin(context.Background(), "meera@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	admin := api.login("meera@example.com", "th1rd-pass")
+
+	productID := api.addProduct(seller, validProduct("red bicycle"))
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/favorite", buyer, nil)
+	api.expect(http.StatusOK, "POST", "/saved-searches", buyer, map[string]interface{}{"name": "Bikes", "category": "bicycles"})
+	api.expect(http.StatusOK, "PUT", "/notifications/preferences", buyer, map[string]interface{}{
+		"channels": map[string]interface{}{"favorites": []string{"in_app"}},
+	})
+	start := map[string]interface{}{"product_id": productID, "message": "Is it still available?"}
+	conversationID := api.expect(http.StatusOK, "POST", "/conversations", buyer, start)["conversation"].(map[string]interface{})["id"].(string)
+	api.expect(http.StatusOK, "PATCH", "/products/"+productID, seller, map[string]interface{}{"price": 4000})
+	api.emails()
+
+	api.expect(http.StatusOK, "DELETE", "/admin/users/ravi@example.com", admin, nil)
+
+	conversation := api.expect(http.StatusOK, "GET", "/conversations/"+conversationID, seller, nil)["conversation"].(map[string]interface{})
+	if conversation["archived_reason"] != "user deleted" {
+		t.Errorf("conversation after deleting the buyer = %v, want it archived", conversation)
+	}
+	product := api.expect(http.StatusOK, "GET", "/getproducts", seller, nil)["products"].([]interface{})[0].(map[string]interface{})
+	if product["favorite_count"] != float64(0) {
+		t.Errorf("favorite count after deleting the buyer = %v, want 0", product["favorite_count"])
+	}
+	// The saved search no longer matches new listings.
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("blue bicycle"))
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("new listing sent %v to the deleted buyer", sent)
+	}
+
+	// Signing up again with the same email starts from scratch.
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	buyer = api.login("ravi@example.com", "an0ther-pass")
+	for _, list := range []struct{ path, key string }{
+		{"/favorites", "favorites"},
+		{"/saved-searches", "saved_searches"},
+		{"/notifications", "notifications"},
+	} {
+		if got := api.expect(http.StatusOK, "GET", list.path, buyer, nil)[list.key].([]interface{}); len(got) != 0 {
+			t.Errorf("%s after signing up again = %v, want none", list.path, got)
+		}
+	}
+	preferences := api.expect(http.StatusOK, "GET", "/notifications/preferences", buyer, nil)["preferences"].(map[string]interface{})
+	if channels := preferences["channels"].(map[string]interface{})["favorites"]; len(channels.([]interface{})) != 2 {
+		t.Errorf("favorites channels after signing up again = %v, want the defaults", channels)
+	}
+}
+
+func TestFavorites(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	other := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("blue helmet"))
+	listed := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)["products"].([]interface{})
+	helmetID := listed[0].(map[string]interface{})["id"].(string)
+	bicycleID := listed[1].(map[string]interface{})["id"].(string)
+
+	api.expect(http.StatusBadRequest, "POST", "/products/"+bicycleID+"/favorite", seller, nil)
+	api.expect(http.StatusNotFound, "POST", "/products/000000000000000000000000/favorite", buyer, nil)
+	api.expect(http.StatusBadRequest, "POST", "/products/not-an-id/favorite", buyer, nil)
+	for _, token := range []string{buyer, buyer, other} {
+		api.expect(http.StatusOK, "POST", "/products/"+bicycleID+"/favorite", token, nil)
+	}
+	api.expect(http.StatusOK, "POST", "/products/"+helmetID+"/favorite", buyer, nil)
+
+	stats := func(token string) map[string][2]interface{} {
+		t.Helper()
+		stats := map[string][2]interface{}{}
+		for _, item := range api.expect(http.StatusOK, "GET", "/getproducts", token, nil)["products"].([]interface{}) {
+			product := item.(map[string]interface{})
+			stats[product["id"].(string)] = [2]interface{}{product["favorite_count"], product["is_favorite"]}
+		}
+		return stats
+	}
+	if got := stats(buyer)[bicycleID]; got != [2]interface{}{float64(2), true} {
+		t.Errorf("buyer sees bicycle stats %v, want 2 favorites including theirs", got)
+	}
+	if got := stats(seller)[bicycleID]; got != [2]interface{}{float64(2), false} {
+		t.Errorf("seller sees bicycle stats %v, want 2 favorites not including theirs", got)
+	}
+	if got := stats(other)[helmetID]; got != [2]interface{}{float64(1), false} {
+		t.Errorf("other user sees helmet stats %v, want 1 favorite not including theirs", got)
+	}
+
+	favorites := api.expect(http.StatusOK, "GET", "/favorites", buyer, nil)["favorites"].([]interface{})
+	if len(favorites) != 2 || favorites[0].(map[string]interface{})["id"] != helmetID {
+		t.Fatalf("favorites = %v, want both, most recently saved first", favorites)
+	}
+
+	// Raising the price tells nobody, lowering it tells everyone who saved it.
+	api.emails()
+	api.expect(http.StatusOK, "PATCH", "/products/"+bicycleID, seller, map[string]interface{}{"price": 5000})
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("price increase sent %v", sent)
+	}
+	api.expect(http.StatusOK, "PATCH", "/products/"+bicycleID, seller, map[string]interface{}{"price": 3900})
+	if sent := api.emails(); len(sent) != 2 {
+		t.Errorf("price drop sent %v, want one notification per saver", sent)
+	}
+
+	api.expect(http.StatusOK, "POST", "/products/"+bicycleID+"/mark-sold", seller, nil)
+	if sent := api.emails(); len(sent) != 2 || !strings.Contains(sent[0], "sold") {
+		t.Errorf("sale sent %v, want one notification per saver", sent)
+	}
+	favorites = api.expect(http.StatusOK, "GET", "/favorites", other, nil)["favorites"].([]interface{})
+	if len(favorites) != 1 || favorites[0].(map[string]interface{})["status"] != "sold" {
+		t.Errorf("favorites after sale = %v, want the bicycle as sold", favorites)
+	}
+	api.expect(http.StatusConflict, "POST", "/products/"+bicycleID+"/favorite", other, nil)
+
+	// Removing a favorite, and deleting a listing, take it off the list.
+	api.expect(http.StatusOK, "DELETE", "/products/"+helmetID+"/favorite", buyer, nil)
+	api.expect(http.StatusNotFound, "DELETE", "/products/"+helmetID+"/favorite", buyer, nil)
+	api.expect(http.StatusOK, "DELETE", "/products/"+bicycleID, seller, nil)
+	favorites = api.expect(http.StatusOK, "GET", "/favorites", buyer, nil)["favorites"].([]interface{})
+	if len(favorites) != 0 {
+		t.Errorf("favorites = %v, want none", favorites)
+	}
+}
+
+func TestSavedSearches(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	other := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusBadRequest, "POST", "/saved-searches", buyer, map[string]interface{}{"name": "Anything"})
+	api.expect(http.StatusBadRequest, "POST", "/saved-searches", buyer, map[string]interface{}{"name": "Bikes", "min_price": 10, "max_price": 5})
+	api.expect(http.StatusBadRequest, "POST", "/saved-searches", buyer, map[string]interface{}{"name": "Bikes", "category": "bicycles", "frequency": "hourly"})
+
+	instant := api.expect(http.StatusOK, "POST", "/saved-searches", buyer, map[string]interface{}{
+		"name": "Cheap bikes", "category": "bicycles", "max_price": 5000,
+	})["saved_search"].(map[string]interface{})
+	if instant["frequency"] != "instant" {
+		t.Errorf("saved search = %v, want instant alerts by default", instant)
+	}
+	instantID := instant["id"].(string)
+	daily := api.expect(http.StatusOK, "POST", "/saved-searches", other, map[string]interface{}{
+		"name": "Helmets", "q": "helmet", "state": "karnataka", "frequency": "daily",
+	})["saved_search"].(map[string]interface{})
+	dailyID := daily["id"].(string)
+
+	// Only the owner sees a search.
+	api.expect(http.StatusNotFound, "GET", "/saved-searches/"+instantID, other, nil)
+	api.expect(http.StatusNotFound, "DELETE", "/saved-searches/"+instantID, other, nil)
+	api.expect(http.StatusBadRequest, "GET", "/saved-searches/not-an-id", buyer, nil)
+	if listed := api.expect(http.StatusOK, "GET", "/saved-searches", buyer, nil)["saved_searches"].([]interface{}); len(listed) != 1 {
+		t.Errorf("saved searches = %v, want one", listed)
+	}
+
+	// Matching listings are sent right away to instant searches.
+	api.emails()
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	if sent := api.emails(); len(sent) != 1 || !strings.HasPrefix(sent[0], "ravi@example.com") {
+		t.Errorf("new bicycle sent %v, want an alert to the buyer", sent)
+	}
+	expensive := validProduct("racing bicycle")
+	expensive["price"] = 9000
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, expensive)
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("bicycle over budget sent %v", sent)
+	}
+	draft := validProduct("blue bicycle")
+	draft["status"] = "draft"
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, draft)
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("draft sent %v", sent)
+	}
+	profile := api.expect(http.StatusOK, "GET", "/profile", seller, nil)["profile"].(map[string]interface{})
+	products := profile["products"].([]interface{})
+	draftID := products[0].(map[string]interface{})["id"].(string)
+	api.expect(http.StatusOK, "POST", "/products/"+draftID+"/publish", seller, nil)
+	if sent := api.emails(); len(sent) != 1 {
+		t.Errorf("publishing a draft sent %v, want an alert", sent)
+	}
+
+	// Daily searches collect matches until the digest goes out.
+	helmet := validProduct("blue helmet")
+	helmet["category"] = "Accessories"
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, helmet)
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("match of a daily search sent %v right away", sent)
+	}
+	pending := api.expect(http.StatusOK, "GET", "/saved-searches/"+dailyID, other, nil)["saved_search"].(map[string]interface{})
+	if pending["pending_matches"] != float64(1) {
+		t.Errorf("daily search = %v, want one pending match", pending)
+	}
+	if err := api.searches.SendDigests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sent := api.emails(); len(sent) != 1 || !strings.HasPrefix(sent[0], "meera@example.com") {
+		t.Errorf("digest sent %v, want one digest", sent)
+	}
+	if err := api.searches.SendDigests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("second digest run sent %v", sent)
+	}
+
+	// Editing and deleting.
+	updated := api.expect(http.StatusOK, "PUT", "/saved-searches/"+instantID, buyer, map[string]interface{}{
+		"name": "Any bike", "category": "bicycles", "frequency": "daily",
+	})["saved_search"].(map[string]interface{})
+	if updated["frequency"] != "daily" || updated["max_price"] != nil {
+		t.Errorf("updated search = %v", updated)
+	}
+	api.expect(http.StatusOK, "DELETE", "/saved-searches/"+instantID, buyer, nil)
+	api.expect(http.StatusNotFound, "GET", "/saved-searches/"+instantID, buyer, nil)
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("green bicycle"))
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("deleted search sent %v", sent)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	if err := api.users.BootstrapAdmin(context.Background(), "meera@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	admin := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	listed := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)["products"].([]interface{})
+	productID := listed[0].(map[string]interface{})["id"].(string)
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/favorite", buyer, nil)
+
+	unread := func(token string) float64 {
+		t.Helper()
+		return api.expect(http.StatusOK, "GET", "/notifications/unread-count", token, nil)["unread_count"].(float64)
+	}
+	list := func(path, token string) []interface{} {
+		t.Helper()
+		return api.expect(http.StatusOK, "GET", path, token, nil)["notifications"].([]interface{})
+	}
+
+	// Chat notifications are in-app only by default.
+	start := map[string]interface{}{"product_id": productID, "message": "Is it still available?"}
+	conversationID := api.expect(http.StatusOK, "POST", "/conversations", buyer, start)["conversation"].(map[string]interface{})["id"].(string)
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("new message sent emails %v", sent)
+	}
+	notifications := list("/notifications", seller)
+	if len(notifications) != 1 {
+		t.Fatalf("seller notifications = %v, want one", notifications)
+	}
+	chat := notifications[0].(map[string]interface{})
+	if chat["category"] != "chat" || chat["link"] != "/conversations/"+conversationID || chat["read_at"] != nil {
+		t.Errorf("chat notification = %v", chat)
+	}
+	if got := unread(seller); got != 1 {
+		t.Errorf("seller unread count = %v, want 1", got)
+	}
+
+	// Favorites go in-app and by email until the buyer turns email off.
+	preferences := api.expect(http.StatusOK, "GET", "/notifications/preferences", buyer, nil)["preferences"].(map[string]interface{})
+	if channels := preferences["channels"].(map[string]interface{})["favorites"]; len(channels.([]interface{})) != 2 {
+		t.Errorf("default favorites channels = %v, want in-app and email", channels)
+	}
+	api.expect(http.StatusOK, "PATCH", "/products/"+productID, seller, map[string]interface{}{"price": 4000})
+	if sent := api.emails(); len(sent) != 1 || !strings.HasPrefix(sent[0], "ravi@example.com") {
+		t.Errorf("price drop sent %v, want an email to the buyer", sent)
+	}
+
+	for _, body := range []map[string]interface{}{
+		{"channels": map[string]interface{}{"gossip": []string{"in_app"}}},
+		{"channels": map[string]interface{}{"favorites": []string{"pigeon"}}},
+		{"channels": map[string]interface{}{"favorites": []string{"webhook"}}},
+		{"webhook_url": "http://hooks.example.com/ravi"},
+	} {
+		api.expect(http.StatusBadRequest, "PUT", "/notifications/preferences", buyer, body)
+	}
+	api.expect(http.StatusOK, "PUT", "/notifications/preferences", buyer, map[string]interface{}{
+		"channels": map[string]interface{}{"favorites": []string{"in_app"}},
+	})
+	api.expect(http.StatusOK, "PATCH", "/products/"+productID, seller, map[string]interface{}{"price": 3500})
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("price drop with email off sent %v", sent)
+	}
+
+	// Marking as read.
+	notifications = list("/notifications?unread=true", buyer)
+	if len(notifications) != 2 || unread(buyer) != 2 {
+		t.Fatalf("buyer unread notifications = %v, want both price drops", notifications)
+	}
+	first := notifications[0].(map[string]interface{})["id"].(string)
+	api.expect(http.StatusBadRequest, "POST", "/notifications/not-an-id/read", buyer, nil)
+	api.expect(http.StatusNotFound, "POST", "/notifications/"+first+"/read", seller, nil)
+	api.expect(http.StatusOK, "POST", "/notifications/"+first+"/read", buyer, nil)
+	api.expect(http.StatusOK, "POST", "/notifications/"+first+"/read", buyer, nil)
+	if got := unread(buyer); got != 1 {
+		t.Errorf("buyer unread count after reading one = %v, want 1", got)
+	}
+	marked := api.expect(http.StatusOK, "POST", "/notifications/read-all", buyer, nil)["marked_read"]
+	if marked != float64(1) || unread(buyer) != 0 {
+		t.Errorf("read-all marked %v", marked)
+	}
+	if got := list("/notifications?unread=true", buyer); len(got) != 0 {
+		t.Errorf("unread notifications after read-all = %v", got)
+	}
+	if got := list("/notifications?limit=1", buyer); len(got) != 1 {
+		t.Errorf("first page of notifications = %v, want one", got)
+	}
+	api.expect(http.StatusBadRequest, "GET", "/notifications?unread=maybe", buyer, nil)
+
+	// Moderation notices stay in-app even when turned off.
+	api.expect(http.StatusOK, "PUT", "/notifications/preferences", seller, map[string]interface{}{
+		"channels": map[string]interface{}{"moderation": []string{}},
+	})
+	api.expect(http.StatusOK, "DELETE", "/admin/products/"+productID, admin, nil)
+	if sent := api.emails(); len(sent) != 0 {
+		t.Errorf("removal with email off sent %v", sent)
+	}
+	removal := list("/notifications?unread=true", seller)[0].(map[string]interface{})
+	if removal["category"] != "moderation" {
+		t.Errorf("latest seller notification = %v, want the removal", removal)
+	}
+}
+
+// dial opens a WebSocket to server, passing token in the query string as
+// browsers do.
+func dial(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?access_token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// next returns the next event received on conn.
+func next(t *testing.T, conn *websocket.Conn) (string, map[string]interface{}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event struct {
+		Type string                 `json:"type"`
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := conn.ReadJSON(&event); err != nil {
+		t.Fatal(err)
+	}
+	return event.Type, event.Data
+}
+
+func TestWebSocket(t *testing.T) {
+	api := newTestAPI(t)
+	server := httptest.NewServer(api.handler)
+	defer server.Close()
+
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	outsider := api.login("meera@example.com", "th1rd-pass")
+
+	api.expect(http.StatusOK, "POST", "/addproduct", seller, validProduct("red bicycle"))
+	listed := api.expect(http.StatusOK, "GET", "/getproducts", buyer, nil)["products"].([]interface{})
+	productID := listed[0].(map[string]interface{})["id"].(string)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("connecting without a token = %v, %v; want 401", resp, err)
+	}
+
+	sellerConn := dial(t, server, seller)
+	buyerConn := dial(t, server, buyer)
+	outsiderConn := dial(t, server, outsider)
+
+	// Both participants receive new messages.
+	start := map[string]interface{}{"product_id": productID, "message": "Is it still available?"}
+	id := api.expect(http.StatusOK, "POST", "/conversations", buyer, start)["conversation"].(map[string]interface{})["id"].(string)
+	for name, conn := range map[string]*websocket.Conn{"seller": sellerConn, "buyer": buyerConn} {
+		if kind, data := next(t, conn); kind != realtime.EventMessage || data["body"] != "Is it still available?" {
+			t.Errorf("%s received %s %v, want the message", name, kind, data)
+		}
+	}
+	if kind, data := next(t, sellerConn); kind != realtime.EventNotification || data["category"] != "chat" {
+		t.Errorf("seller received %s %v, want a notification", kind, data)
+	}
+
+	// Typing reaches the other participant only, and outsiders are refused.
+	if err := sellerConn.WriteJSON(realtime.ClientMessage{Type: realtime.ClientTyping, ConversationID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if kind, data := next(t, buyerConn); kind != realtime.EventTyping || data["email"] != "asha@example.com" {
+		t.Errorf("buyer received %s %v, want the seller typing", kind, data)
+	}
+	if err := outsiderConn.WriteJSON(realtime.ClientMessage{Type: realtime.ClientTyping, ConversationID: id}); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _ := next(t, outsiderConn); kind != realtime.EventError {
+		t.Errorf("outsider typing got %s, want an error", kind)
+	}
+
+	// Reading tells the sender.
+	api.expect(http.StatusOK, "POST", "/conversations/"+id+"/read", seller, nil)
+	if kind, data := next(t, buyerConn); kind != realtime.EventRead || data["reader"] != "asha@example.com" {
+		t.Errorf("buyer received %s %v, want a read receipt", kind, data)
+	}
+
+	// Watchers of a product hear about its sale.
+	if err := outsiderConn.WriteJSON(realtime.ClientMessage{Type: realtime.ClientWatch, ProductID: productID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := outsiderConn.WriteJSON(realtime.ClientMessage{Type: realtime.ClientWatch, ProductID: "000000000000000000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	// The error for the unknown product also shows that the first watch was
+	// handled.
+	if kind, _ := next(t, outsiderConn); kind != realtime.EventError {
+		t.Errorf("watching an unknown product got %s, want an error", kind)
+	}
+	api.expect(http.StatusOK, "POST", "/products/"+productID+"/mark-sold", seller, nil)
+	if kind, data := next(t, outsiderConn); kind != realtime.EventProductStatus || data["status"] != "sold" || data["previous_status"] != "active" {
+		t.Errorf("watcher received %s %v, want the sale", kind, data)
+	}
+
+	// Closing the gateway sends every connection a close frame.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	closed := make(chan error, 1)
+	go func() { closed <- api.gateway.Close(ctx) }()
+	buyerConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := buyerConn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("read after close = %v, want a going away close frame", err)
+			}
+			break
+		}
+	}
+	sellerConn.Close()
+	outsiderConn.Close()
+	if err := <-closed; err != nil {
+		t.Errorf("Close = %v", err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?access_token="+buyer, nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("connecting after close = %v, %v; want 503", resp, err)
+	}
+}
+
+func TestWebSocketEndsWithSession(t *testing.T) {
+	api := newTestAPI(t)
+	// Sessions are checked on every ping.
+	api.gateway.PingInterval = 50 * time.Millisecond
+	server := httptest.NewServer(api.handler)
+	defer server.Close()
+
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	api.signup("Ravi", "ravi@example.com", "an0ther-pass")
+	api.signup("Meera", "meera@example.com", "th1rd-pass")
+	if err := api.users.BootstrapAdmin(context.Background(), "meera@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	seller := api.login("asha@example.com", "s3cret-pass")
+	buyer := api.login("ravi@example.com", "an0ther-pass")
+	admin := api.login("meera@example.com", "th1rd-pass")
+
+	sellerConn := dial(t, server, seller)
+	buyerConn := dial(t, server, buyer)
+	adminConn := dial(t, server, admin)
+
+	api.expect(http.StatusOK, "POST", "/logout", seller, nil)
+	api.expect(http.StatusOK, "POST", "/admin/users/ravi@example.com/suspend", admin, nil)
+	for name, conn := range map[string]*websocket.Conn{"logged out": sellerConn, "suspended": buyerConn} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+					t.Errorf("%s user's socket ended with %v, want a policy violation close frame", name, err)
+				}
+				break
+			}
+		}
+	}
+
+	// Sockets of sessions that are still active stay open.
+	adminConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, _, err := adminConn.ReadMessage(); !strings.Contains(fmt.Sprint(err), "timeout") {
+		t.Errorf("admin socket read = %v, want it to stay open until the deadline", err)
+	}
+}
+
+func TestAccessLogHidesTokens(t *testing.T) {
+	var logged bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logged
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	api := newTestAPI(t)
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+	api.do("GET", "/ws?access_token="+token+"&v=1", "", nil)
+
+	if strings.Contains(logged.String(), token) {
+		t.Errorf("access log contains the access token:\n%s", logged.String())
+	}
+	if !strings.Contains(logged.String(), "/ws?access_token=REDACTED&v=1") {
+		t.Errorf("access log = %s, want the request with the token redacted", logged.String())
+	}
+}
+
+func TestJWKSPublishesNoSecrets(t *testing.T) {
+	api := newTestAPI(t)
+	body := api.expect(http.StatusOK, "GET", "/.well-known/jwks.json", "", nil)
+	if keys := body["keys"].([]interface{}); len(keys) != 0 {
+		t.Errorf("HS256 keys must not be published, got %v", keys)
+	}
+}
+
+// stalledProductRepository stands in for a database that stopped answering:
+// listings block until their deadline passes.
+type stalledProductRepository struct {
+	repository.ProductRepository
+}
+
+func (stalledProductRepository) GetAllProducts(ctx context.Context, query model.ProductQuery) ([]model.Product, int64, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	return nil, 0, "", ctx.Err()
+}
+
+func TestTimeoutsAreGatewayTimeouts(t *testing.T) {
+	api := newTestAPIWithProducts(t, stalledProductRepository{repository.NewMemoryProductRepository()})
+	api.signup("Asha", "asha@example.com", "s3cret-pass")
+	token := api.login("asha@example.com", "s3cret-pass")
+
+	body := api.expect(http.StatusGatewayTimeout, "GET", "/getproducts", token, nil)
+	if body["error"] != "Request timed out" {
+		t.Errorf("error = %v, want a timeout message", body["error"])
+	}
+}
@@ -0,0 +1,111 @@
+// Package server runs the HTTP API and shuts it down gracefully.
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/liju-github/internal/config"
+)
+
+// Step is a piece of teardown that runs after the HTTP server has drained,
+// such as stopping background jobs or disconnecting from the database.
+type Step struct {
+	Name string
+	Stop func(ctx context.Context) error
+}
+
+// Server serves the API until its context is cancelled, then stops accepting
+// connections, waits for in-flight requests and runs the teardown steps in
+// order. The whole shutdown shares a single ShutdownTimeout.
+type Server struct {
+	HTTP            *http.Server
+	ShutdownTimeout time.Duration
+	Teardown        []Step
+}
+
+// New creates a server for handler with the timeouts of config.
+func New(handler http.Handler, config config.HTTPConfig, teardown ...Step) *Server {
+	return &Server{
+		HTTP: &http.Server{
+			Addr:              config.Addr,
+			Handler:           handler,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+		},
+		ShutdownTimeout: config.ShutdownTimeout,
+		Teardown:        teardown,
+	}
+}
+
+// ListenAndServe listens on the configured address and calls Serve.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.HTTP.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx is cancelled and then shuts
+// down. It returns once the shutdown has finished. An error is returned if
+// the server failed or the shutdown did not complete in time; teardown steps
+// still run in either case.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- s.HTTP.Serve(listener)
+	}()
+	log.Printf("Server listening on %s", listener.Addr())
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-serveErr:
+		// The server stopped on its own, so there is nothing to drain but
+		// the rest still has to be torn down.
+		log.Printf("Server stopped unexpectedly: %v", err)
+	}
+
+	log.Println("Shutting down server...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+
+	if shutdownErr := s.HTTP.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Printf("Failed to drain HTTP connections: %v", shutdownErr)
+		err = errors.Join(err, shutdownErr)
+	}
+
+	for _, step := range s.Teardown {
+		if stepErr := runStep(shutdownCtx, step); stepErr != nil {
+			log.Printf("Failed to stop %s: %v", step.Name, stepErr)
+			err = errors.Join(err, stepErr)
+		}
+	}
+
+	if err == nil {
+		log.Println("Server gracefully stopped")
+	}
+	return err
+}
+
+// runStep gives up on a step once ctx is done, so that a stuck step cannot
+// hold up the process forever.
+func runStep(ctx context.Context, step Step) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- step.Stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/repository"
+)
+
+var (
+	ErrInvalidRole      = errors.New("unknown role")
+	ErrCannotTargetSelf = errors.New("admins cannot suspend, delete or demote themselves")
+)
+
+// AdminService holds the moderation actions of admins and moderators. actor
+// is always the email of the staff member performing the action.
+type AdminService struct {
+	UserRepo         repository.UserRepository
+	ProductRepo      repository.ProductRepository
+	SessionRepo      repository.SessionRepository
+	ChatRepo         repository.ChatRepository
+	FavoriteRepo     repository.FavoriteRepository
+	SearchRepo       repository.SavedSearchRepository
+	NotificationRepo repository.NotificationRepository
+	Events           *ProductEvents // Told about removed products; may be nil
+	Notifier         Notifier       // Tells users about actions taken on them
+}
+
+// SetUserSuspended suspends or reinstates a user. Suspending also ends all
+// of the user's sessions.
+func (service *AdminService) SetUserSuspended(ctx context.Context, actor, email string, suspended bool) error {
+	if actor == email {
+		return ErrCannotTargetSelf
+	}
+	if err := service.UserRepo.SetUserSuspended(ctx, email, suspended, actor); err != nil {
+		return err
+	}
+	if suspended {
+		if err := service.SessionRepo.RevokeUserSessions(ctx, email, "suspended"); err != nil {
+			return err
+		}
+		service.notify(ctx, email, "Account suspended", "Your account has been suspended by a moderator.")
+		return nil
+	}
+	service.notify(ctx, email, "Account reinstated", "Your account has been reinstated and you can sign in again.")
+	return nil
+}
+
+func (service *AdminService) SetUserRole(ctx context.Context, actor, email string, role model.Role) error {
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	if actor == email && role != model.RoleAdmin {
+		return ErrCannotTargetSelf
+	}
+	return service.UserRepo.UpdateUserRole(ctx, email, role, actor)
+}
+
+// DeleteUser removes a user together with all of their listings and
+// everything else stored for them. Every listing is published as deleted,
+// which archives its conversations and drops its favorites, and the other
+// conversations of the user are archived. The account itself goes last, so
+// a failed deletion can be retried.
+func (service *AdminService) DeleteUser(ctx context.Context, actor, email string) error {
+	if actor == email {
+		return ErrCannotTargetSelf
+	}
+	if _, err := service.UserRepo.GetUserByEmail(ctx, email); err != nil {
+		return err
+	}
+
+	deleted, err := service.ProductRepo.DeleteProductsByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	for _, product := range deleted {
+		service.Events.publish(ctx, ProductEvent{Type: ProductDeleted, Product: product})
+	}
+
+	if err := service.deleteUserData(ctx, email); err != nil {
+		return err
+	}
+	if err := service.UserRepo.DeleteUser(ctx, email); err != nil {
+		return err
+	}
+	if err := service.SessionRepo.RevokeUserSessions(ctx, email, "deleted"); err != nil {
+		return err
+	}
+	log.Printf("User %s deleted by %s along with %d products", email, actor, len(deleted))
+	return nil
+}
+
+// deleteUserData removes the favorites, saved searches and notifications of
+// a user and archives their conversations, which the other participant keeps.
+func (service *AdminService) deleteUserData(ctx context.Context, email string) error {
+	if _, err := service.FavoriteRepo.DeleteUserFavorites(ctx, email); err != nil {
+		return err
+	}
+	if _, err := service.SearchRepo.DeleteUserSavedSearches(ctx, email); err != nil {
+		return err
+	}
+	if err := service.NotificationRepo.DeleteUserNotifications(ctx, email); err != nil {
+		return err
+	}
+	if _, err := service.ChatRepo.ArchiveUserConversations(ctx, email, archivedUserDeleted, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveProduct deletes any product, whoever listed it.
+func (service *AdminService) RemoveProduct(ctx context.Context, actor, id string) error {
+	product, err := service.ProductRepo.GetProductByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	removed, err := service.ProductRepo.DeleteProduct(ctx, product.ID, product.Email)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrProductNotFound
+	}
+
+	service.Events.publish(ctx, ProductEvent{Type: ProductDeleted, Product: *product})
+	log.Printf("Product %s of %s removed by %s", id, product.Email, actor)
+	message := fmt.Sprintf("Your listing %q was removed by a moderator.", product.Name)
+	service.notify(ctx, product.Email, "Listing removed", message)
+	return nil
+}
+
+// notify tells email about a moderation action. The action is already done,
+// so failures are only logged.
+func (service *AdminService) notify(ctx context.Context, email, subject, message string) {
+	notifier := service.Notifier
+	if notifier == nil {
+		notifier = LogNotifier{}
+	}
+	err := notifier.Notify(ctx, model.Notification{
+		UserEmail: email,
+		Category:  model.CategoryModeration,
+		Subject:   subject,
+		Message:   message,
+	})
+	if err != nil {
+		log.Printf("Failed to tell %s about a moderation action: %v", email, err)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrConversationNotFound  = repository.ErrConversationNotFound
+	ErrConversationArchived  = repository.ErrConversationArchived
+	ErrInvalidConversationID = errors.New("invalid conversation id")
+	ErrNotParticipant        = errors.New("only the buyer and the seller can access a conversation")
+	ErrOwnProduct            = errors.New("sellers cannot start a conversation about their own product")
+	ErrProductUnavailable    = errors.New("product is no longer available")
+	ErrEmptyMessage          = errors.New("message cannot be empty")
+	ErrMessageTooLong        = fmt.Errorf("a message can be at most %d characters", model.MaxMessageLength)
+)
+
+// Reasons recorded on conversations archived because of their listing or
+// one of their participants.
+const (
+	archivedProductSold    = "product sold"
+	archivedProductDeleted = "product deleted"
+	archivedUserDeleted    = "user deleted"
+)
+
+// ChatService lets buyers message the seller of a listing. Conversations are
+// archived when their listing is sold or deleted, see HandleProductEvent.
+// New messages, read receipts and typing indicators are pushed to the
+// participants through Hub.
+type ChatService struct {
+	ChatRepo    repository.ChatRepository
+	ProductRepo repository.ProductRepository
+	Notifier    Notifier
+	Hub         realtime.Hub // May be nil
+}
+
+// StartConversation opens the conversation of buyer about a product, or
+// returns the existing one, and sends message when it is not empty. Only
+// active listings can be asked about. A conversation archived when the
+// listing was sold is reopened if the listing is active again.
+func (service *ChatService) StartConversation(ctx context.Context, productID, buyer, message string) (*model.Conversation, error) {
+	product, err := service.ProductRepo.GetProductByID(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+	if product.Email == buyer {
+		return nil, ErrOwnProduct
+	}
+	switch product.Status.OrDefault() {
+	case model.StatusActive:
+	case model.StatusDraft, model.StatusArchived:
+		// Only their seller knows about these.
+		return nil, ErrProductNotFound
+	default:
+		return nil, ErrProductUnavailable
+	}
+
+	now := time.Now()
+	conversation, err := service.ChatRepo.StartConversation(ctx, model.Conversation{
+		ProductID:      product.ID,
+		ProductName:    product.Name,
+		BuyerEmail:     buyer,
+		SellerEmail:    product.Email,
+		CreatedAt:      now,
+		LastActivityAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Archived() {
+		if err := service.ChatRepo.ReopenConversation(ctx, conversation.ID); err != nil {
+			return nil, err
+		}
+		conversation.ArchivedAt = nil
+		conversation.ArchivedReason = ""
+	}
+
+	if message != "" {
+		sent, err := service.send(ctx, conversation, buyer, message)
+		if err != nil {
+			return nil, err
+		}
+		conversation.LastMessage = sent.Body
+		conversation.LastActivityAt = sent.SentAt
+	}
+	return conversation, nil
+}
+
+// Conversation returns a conversation email takes part in.
+func (service *ChatService) Conversation(ctx context.Context, id, email string) (*model.Conversation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidConversationID
+	}
+	conversation, err := service.ChatRepo.GetConversation(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if !conversation.HasParticipant(email) {
+		return nil, ErrNotParticipant
+	}
+	return conversation, nil
+}
+
+// Inbox returns a page of the conversations of email, most recently active
+// first, with the number of unread messages in each.
+func (service *ChatService) Inbox(ctx context.Context, email string, page model.CursorPage) ([]model.InboxEntry, string, error) {
+	page.Normalize()
+	return service.ChatRepo.GetInbox(ctx, email, page)
+}
+
+// Messages returns a page of a conversation's messages, newest first.
+func (service *ChatService) Messages(ctx context.Context, id, email string, page model.CursorPage) ([]model.Message, string, error) {
+	conversation, err := service.Conversation(ctx, id, email)
+	if err != nil {
+		return nil, "", err
+	}
+	page.Normalize()
+	return service.ChatRepo.GetMessages(ctx, conversation.ID, page)
+}
+
+// SendMessage adds a message from sender to a conversation and lets the
+// other participant know about it.
+func (service *ChatService) SendMessage(ctx context.Context, id, sender, body string) (*model.Message, error) {
+	conversation, err := service.Conversation(ctx, id, sender)
+	if err != nil {
+		return nil, err
+	}
+	if conversation.Archived() {
+		return nil, ErrConversationArchived
+	}
+	return service.send(ctx, conversation, sender, body)
+}
+
+// MarkRead marks the messages email received in a conversation as read and
+// returns how many there were.
+func (service *ChatService) MarkRead(ctx context.Context, id, email string) (int64, error) {
+	conversation, err := service.Conversation(ctx, id, email)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	marked, err := service.ChatRepo.MarkMessagesRead(ctx, conversation.ID, email, now)
+	if err != nil {
+		return 0, err
+	}
+	if marked > 0 {
+		receipt := dto.ReadReceipt{ConversationID: conversation.ID.Hex(), Reader: email, ReadAt: now}
+		publish(ctx, service.Hub, realtime.UserTopic(conversation.OtherParticipant(email)),
+			realtime.Event{Type: realtime.EventRead, Data: receipt})
+	}
+	return marked, nil
+}
+
+// Typing tells the other participant of a conversation that email is typing.
+func (service *ChatService) Typing(ctx context.Context, id, email string) error {
+	conversation, err := service.Conversation(ctx, id, email)
+	if err != nil {
+		return err
+	}
+	if conversation.Archived() {
+		return ErrConversationArchived
+	}
+	typing := dto.TypingEvent{ConversationID: conversation.ID.Hex(), Email: email}
+	publish(ctx, service.Hub, realtime.UserTopic(conversation.OtherParticipant(email)),
+		realtime.Event{Type: realtime.EventTyping, Data: typing})
+	return nil
+}
+
+// HandleProductEvent archives the conversations about a listing once it is
+// sold or deleted. It is meant to be subscribed to ProductEvents.
+func (service *ChatService) HandleProductEvent(ctx context.Context, event ProductEvent) error {
+	var reason string
+	switch {
+	case event.Type == ProductDeleted:
+		reason = archivedProductDeleted
+	case event.Type == ProductStatusChanged && event.Product.Status == model.StatusSold:
+		reason = archivedProductSold
+	default:
+		return nil
+	}
+
+	archived, err := service.ChatRepo.ArchiveProductConversations(ctx, event.Product.ID, reason, time.Now())
+	if err != nil {
+		return err
+	}
+	if archived > 0 {
+		log.Printf("Archived %d conversations about product %s: %s", archived, event.Product.ID.Hex(), reason)
+	}
+	return nil
+}
+
+func (service *ChatService) send(ctx context.Context, conversation *model.Conversation, sender, body string) (*model.Message, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return nil, ErrEmptyMessage
+	}
+	if utf8.RuneCountInString(body) > model.MaxMessageLength {
+		return nil, ErrMessageTooLong
+	}
+
+	message := model.Message{
+		ConversationID: conversation.ID,
+		SenderEmail:    sender,
+		Body:           body,
+		SentAt:         time.Now(),
+	}
+	id, err := service.ChatRepo.AddMessage(ctx, message)
+	if err != nil {
+		return nil, err
+	}
+	message.ID = id
+
+	// The sender gets the message too, for their other devices.
+	event := realtime.Event{Type: realtime.EventMessage, Data: dto.NewMessageResponse(message)}
+	publish(ctx, service.Hub, realtime.UserTopic(conversation.BuyerEmail), event)
+	publish(ctx, service.Hub, realtime.UserTopic(conversation.SellerEmail), event)
+
+	recipient := conversation.OtherParticipant(sender)
+	notice := fmt.Sprintf("%s sent you a message about %q.", sender, conversation.ProductName)
+	err = service.notifier().Notify(ctx, model.Notification{
+		UserEmail: recipient,
+		Category:  model.CategoryChat,
+		Subject:   "New message",
+		Message:   notice,
+		Link:      "/conversations/" + conversation.ID.Hex(),
+	})
+	if err != nil {
+		log.Printf("Failed to notify %s of message %s: %v", recipient, id.Hex(), err)
+	}
+	return &message, nil
+}
+
+func (service *ChatService) notifier() Notifier {
+	if service.Notifier == nil {
+		return LogNotifier{}
+	}
+	return service.Notifier
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrFavoriteNotFound = repository.ErrFavoriteNotFound
+	ErrOwnFavorite      = errors.New("sellers cannot save their own product")
+)
+
+// Reasons of the alerts sent to the users who saved a listing.
+const (
+	FavoritePriceDrop = "price_drop"
+	FavoriteSold      = "sold"
+)
+
+// FavoriteService lets users save listings and tells them when a saved
+// listing gets cheaper or is sold, see HandleProductEvent.
+type FavoriteService struct {
+	FavoriteRepo repository.FavoriteRepository
+	ProductRepo  repository.ProductRepository
+	Notifier     Notifier
+	Hub          realtime.Hub // May be nil
+}
+
+// AddFavorite saves an active listing for email. Saving a listing twice is
+// not an error.
+func (service *FavoriteService) AddFavorite(ctx context.Context, productID, email string) error {
+	product, err := service.ProductRepo.GetProductByID(ctx, productID)
+	if err != nil {
+		return err
+	}
+	switch product.Status.OrDefault() {
+	case model.StatusActive:
+	case model.StatusSold, model.StatusExpired:
+		return ErrProductUnavailable
+	default:
+		return ErrProductNotFound
+	}
+	if product.Email == email {
+		return ErrOwnFavorite
+	}
+
+	_, err = service.FavoriteRepo.AddFavorite(ctx, model.Favorite{
+		UserEmail: email,
+		ProductID: product.ID,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+func (service *FavoriteService) RemoveFavorite(ctx context.Context, productID, email string) error {
+	id, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return ErrInvalidProductID
+	}
+	return service.FavoriteRepo.RemoveFavorite(ctx, email, id)
+}
+
+// Favorites returns a page of the listings email saved, most recently saved
+// first, as they are now. Listings that were deleted are left out.
+func (service *FavoriteService) Favorites(ctx context.Context, email string, page model.CursorPage) ([]model.FavoriteProduct, string, error) {
+	page.Normalize()
+	favorites, next, err := service.FavoriteRepo.GetFavorites(ctx, email, page)
+	if err != nil || len(favorites) == 0 {
+		return nil, next, err
+	}
+
+	ids := make([]primitive.ObjectID, len(favorites))
+	for i, favorite := range favorites {
+		ids[i] = favorite.ProductID
+	}
+	products, err := service.ProductRepo.GetProductsByIDs(ctx, ids)
+	if err != nil {
+		return nil, "", err
+	}
+	byID := make(map[primitive.ObjectID]model.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	saved := make([]model.FavoriteProduct, 0, len(favorites))
+	for _, favorite := range favorites {
+		if product, ok := byID[favorite.ProductID]; ok {
+			saved = append(saved, model.FavoriteProduct{Product: product, FavoritedAt: favorite.CreatedAt})
+		}
+	}
+	return saved, next, nil
+}
+
+// Stats returns the favorite stats of products as seen by email. Products
+// nobody saved are missing from the result.
+func (service *FavoriteService) Stats(ctx context.Context, email string, products []model.Product) (map[primitive.ObjectID]model.FavoriteStats, error) {
+	if len(products) == 0 {
+		return nil, nil
+	}
+	ids := make([]primitive.ObjectID, len(products))
+	for i, product := range products {
+		ids[i] = product.ID
+	}
+	return service.FavoriteRepo.GetFavoriteStats(ctx, email, ids)
+}
+
+// HandleProductEvent alerts the users who saved a listing when its price is
+// lowered or it is sold, and forgets the listing once it is deleted. It is
+// meant to be subscribed to ProductEvents.
+func (service *FavoriteService) HandleProductEvent(ctx context.Context, event ProductEvent) error {
+	product := event.Product
+	switch {
+	case event.Type == ProductDeleted:
+		_, err := service.FavoriteRepo.DeleteProductFavorites(ctx, product.ID)
+		return err
+	case event.Type == ProductStatusChanged && product.Status == model.StatusSold:
+		message := fmt.Sprintf("%q, which you saved, has been sold.", product.Name)
+		return service.alert(ctx, product, FavoriteSold, 0, "Saved listing sold", message)
+	case event.Type == ProductUpdated && event.Previous != nil && product.Price < event.Previous.Price &&
+		product.Status.OrDefault() == model.StatusActive:
+		message := fmt.Sprintf("%q, which you saved, dropped from %.2f to %.2f.", product.Name, event.Previous.Price, product.Price)
+		return service.alert(ctx, product, FavoritePriceDrop, event.Previous.Price, "Price drop on a saved listing", message)
+	}
+	return nil
+}
+
+func (service *FavoriteService) alert(ctx context.Context, product model.Product, reason string, previousPrice float64, subject, message string) error {
+	emails, err := service.FavoriteRepo.GetFavoriteEmails(ctx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	event := realtime.Event{Type: realtime.EventFavorite, Data: dto.FavoriteAlert{
+		ProductID:     product.ID.Hex(),
+		Name:          product.Name,
+		Reason:        reason,
+		Status:        product.Status.OrDefault(),
+		Price:         product.Price,
+		PreviousPrice: previousPrice,
+	}}
+	for _, email := range emails {
+		publish(ctx, service.Hub, realtime.UserTopic(email), event)
+		err := service.notifier().Notify(ctx, model.Notification{
+			UserEmail: email,
+			Category:  model.CategoryFavorites,
+			Subject:   subject,
+			Message:   message,
+			Link:      "/products/" + product.ID.Hex(),
+		})
+		if err != nil {
+			log.Printf("Failed to tell %s about product %s: %v", email, product.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+func (service *FavoriteService) notifier() Notifier {
+	if service.Notifier == nil {
+		return LogNotifier{}
+	}
+	return service.Notifier
+}
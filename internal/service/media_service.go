@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+
+	"github.com/liju-github/internal/imaging"
+	"github.com/liju-github/internal/storage"
+)
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+type MediaService struct {
+	Storage        storage.Storage
+	MaxUploadBytes int64
+}
+
+type UploadedImage struct {
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+
+	key, thumbKey string
+}
+
+// UploadImage validates and cleans up an uploaded image, stores it together
+// with a thumbnail and returns their public URLs.
+func (service *MediaService) UploadImage(ctx context.Context, r io.Reader) (*UploadedImage, error) {
+	data, err := io.ReadAll(io.LimitReader(r, service.MaxUploadBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > service.MaxUploadBytes {
+		return nil, ErrImageTooLarge
+	}
+
+	processed, err := imaging.Process(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	key := "images/" + id + processed.Extension
+	thumbKey := "images/" + id + "_thumb" + processed.Extension
+
+	if err := service.Storage.Save(ctx, key, bytes.NewReader(processed.Data)); err != nil {
+		return nil, err
+	}
+	uploaded := &UploadedImage{
+		URL:          service.Storage.URL(key),
+		ThumbnailURL: service.Storage.URL(thumbKey),
+		key:          key,
+		thumbKey:     thumbKey,
+	}
+	if err := service.Storage.Save(ctx, thumbKey, bytes.NewReader(processed.Thumbnail)); err != nil {
+		service.DeleteImage(ctx, uploaded)
+		return nil, err
+	}
+	return uploaded, nil
+}
+
+// DeleteImage removes an uploaded image and its thumbnail, for uploads that
+// end up not being used. Failures are logged, since the caller is already
+// handling another error.
+func (service *MediaService) DeleteImage(ctx context.Context, image *UploadedImage) {
+	for _, key := range []string{image.key, image.thumbKey} {
+		if err := service.Storage.Delete(ctx, key); err != nil {
+			log.Println("Failed to delete unused upload in DeleteImage: ", err)
+		}
+	}
+}
+
+// OpenMedia returns a stored file for serving.
+func (service *MediaService) OpenMedia(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	return service.Storage.Open(ctx, key)
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
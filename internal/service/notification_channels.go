@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/mailer"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+)
+
+// DeliveryChannel delivers notifications one way, such as in-app or by
+// email. NotificationService picks the channels of each notification from
+// the preferences of its user.
+type DeliveryChannel interface {
+	Name() model.NotificationChannel
+	Deliver(ctx context.Context, notification model.Notification, preferences model.NotificationPreferences) error
+}
+
+// InAppChannel stores notifications for the notification endpoints and
+// pushes them to the user's open WebSockets.
+type InAppChannel struct {
+	Repo repository.NotificationRepository
+	Hub  realtime.Hub // May be nil
+}
+
+func (InAppChannel) Name() model.NotificationChannel { return model.ChannelInApp }
+
+func (channel InAppChannel) Deliver(ctx context.Context, notification model.Notification, preferences model.NotificationPreferences) error {
+	id, err := channel.Repo.AddNotification(ctx, notification)
+	if err != nil {
+		return err
+	}
+	notification.ID = id
+	event := realtime.Event{Type: realtime.EventNotification, Data: dto.NewNotificationResponse(notification)}
+	publish(ctx, channel.Hub, realtime.UserTopic(notification.UserEmail), event)
+	return nil
+}
+
+// EmailChannel sends notifications by email.
+type EmailChannel struct {
+	Mailer mailer.Mailer
+}
+
+func (EmailChannel) Name() model.NotificationChannel { return model.ChannelEmail }
+
+func (channel EmailChannel) Deliver(ctx context.Context, notification model.Notification, preferences model.NotificationPreferences) error {
+	return channel.Mailer.Send(ctx, notification.UserEmail, notification.Subject, notification.Message)
+}
+
+// WebhookPayload is the JSON body posted to the webhooks of users.
+type WebhookPayload struct {
+	UserEmail    string                   `json:"user_email"`
+	Notification dto.NotificationResponse `json:"notification"`
+}
+
+// WebhookChannel posts notifications to the webhook URL of their user.
+// Users without a webhook URL are skipped.
+type WebhookChannel struct {
+	Client *http.Client
+}
+
+func (WebhookChannel) Name() model.NotificationChannel { return model.ChannelWebhook }
+
+func (channel WebhookChannel) Deliver(ctx context.Context, notification model.Notification, preferences model.NotificationPreferences) error {
+	if preferences.WebhookURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(WebhookPayload{
+		UserEmail:    notification.UserEmail,
+		Notification: dto.NewNotificationResponse(notification),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, preferences.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := channel.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+var errPrivateAddress = errors.New("webhooks cannot reach private addresses")
+
+// NewWebhookClient returns the client of WebhookChannel. Webhook URLs are
+// chosen by users, so the client refuses to connect to loopback, private
+// and link-local addresses and does not follow redirects.
+func NewWebhookClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+				ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validWebhookURL accepts absolute https URLs without credentials.
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Host != "" && u.User == nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/liju-github/internal/model"
+)
+
+var (
+	ErrDeliveryQueueFull   = errors.New("notification delivery queue is full")
+	ErrDeliveryQueueClosed = errors.New("notification delivery queue is closed")
+)
+
+// DeliveryQueue delivers notifications through slow channels, such as email
+// and webhooks, on a pool of workers, so that the requests that cause
+// notifications never wait for a mail server or a user's webhook. Every
+// delivery gets its own timeout.
+type DeliveryQueue struct {
+	timeout    time.Duration
+	deliveries chan delivery
+	workers    sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	pending int
+	idle    chan struct{} // Closed while nothing is pending
+}
+
+type delivery struct {
+	channel      DeliveryChannel
+	notification model.Notification
+	preferences  model.NotificationPreferences
+}
+
+// NewDeliveryQueue starts workers that deliver up to size queued
+// notifications, each within timeout.
+func NewDeliveryQueue(workers, size int, timeout time.Duration) *DeliveryQueue {
+	queue := &DeliveryQueue{
+		timeout:    timeout,
+		deliveries: make(chan delivery, size),
+		idle:       make(chan struct{}),
+	}
+	close(queue.idle)
+	for i := 0; i < workers; i++ {
+		queue.workers.Add(1)
+		go queue.work()
+	}
+	return queue
+}
+
+// Enqueue schedules the delivery of notification through channel. It does
+// not wait for room in the queue: it fails with ErrDeliveryQueueFull
+// instead.
+func (queue *DeliveryQueue) Enqueue(channel DeliveryChannel, notification model.Notification, preferences model.NotificationPreferences) error {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	if queue.closed {
+		return ErrDeliveryQueueClosed
+	}
+
+	select {
+	case queue.deliveries <- delivery{channel: channel, notification: notification, preferences: preferences}:
+	default:
+		return ErrDeliveryQueueFull
+	}
+	if queue.pending == 0 {
+		queue.idle = make(chan struct{})
+	}
+	queue.pending++
+	return nil
+}
+
+// Flush waits until every queued notification has been delivered or has
+// failed.
+func (queue *DeliveryQueue) Flush(ctx context.Context) error {
+	queue.mu.Lock()
+	idle := queue.idle
+	queue.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close refuses new deliveries and waits for the queued ones to finish.
+func (queue *DeliveryQueue) Close(ctx context.Context) error {
+	queue.mu.Lock()
+	if !queue.closed {
+		queue.closed = true
+		close(queue.deliveries)
+	}
+	queue.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		queue.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (queue *DeliveryQueue) work() {
+	defer queue.workers.Done()
+	for d := range queue.deliveries {
+		queue.deliver(d)
+
+		queue.mu.Lock()
+		queue.pending--
+		if queue.pending == 0 {
+			close(queue.idle)
+		}
+		queue.mu.Unlock()
+	}
+}
+
+func (queue *DeliveryQueue) deliver(d delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), queue.timeout)
+	defer cancel()
+
+	if err := d.channel.Deliver(ctx, d.notification, d.preferences); err != nil {
+		log.Printf("Failed to deliver notification %s to %s by %s: %v",
+			d.notification.ID.Hex(), d.notification.UserEmail, d.channel.Name(), err)
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotificationNotFound  = repository.ErrNotificationNotFound
+	ErrInvalidNotificationID = errors.New("invalid notification id")
+	ErrUnknownCategory       = errors.New("unknown notification category")
+	ErrUnknownChannel        = errors.New("unknown notification channel")
+	ErrInvalidWebhookURL     = errors.New("webhook_url must be an https URL")
+	ErrWebhookURLRequired    = errors.New("the webhook channel needs a webhook_url")
+)
+
+// NotificationService delivers the notifications of every other service
+// through the channels each user chose per category, and serves the
+// notifications kept in-app. It implements Notifier.
+type NotificationService struct {
+	Repo     repository.NotificationRepository
+	Channels []DeliveryChannel
+	// Queue delivers through every channel but in-app, which only stores
+	// the notification. When it is nil they are delivered within Notify.
+	Queue *DeliveryQueue
+}
+
+// Notify stores notification in-app and queues its delivery through the
+// other channels its user chose for its category. Failures of single
+// channels are logged; Notify only fails when no channel took it, so that
+// callers retrying later do not deliver it twice.
+func (service *NotificationService) Notify(ctx context.Context, notification model.Notification) error {
+	preferences, err := service.Preferences(ctx, notification.UserEmail)
+	if err != nil {
+		return err
+	}
+	notification.ID = primitive.NewObjectID()
+	notification.CreatedAt = time.Now()
+	notification.ReadAt = nil
+
+	delivered := 0
+	var errs []error
+	for _, name := range preferences.ChannelsFor(notification.Category) {
+		channel := service.channel(name)
+		if channel == nil {
+			continue
+		}
+		if name == model.ChannelInApp || service.Queue == nil {
+			err = channel.Deliver(ctx, notification, *preferences)
+		} else {
+			err = service.Queue.Enqueue(channel, notification, *preferences)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		delivered++
+	}
+	if delivered == 0 {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Printf("Failed to deliver notification %s to %s: %v", notification.ID.Hex(), notification.UserEmail, err)
+	}
+	return nil
+}
+
+// List returns a page of the in-app notifications of email, newest first,
+// and the cursor of the next page.
+func (service *NotificationService) List(ctx context.Context, email string, unreadOnly bool, page model.CursorPage) ([]model.Notification, string, error) {
+	page.Normalize()
+	return service.Repo.GetNotifications(ctx, email, unreadOnly, page)
+}
+
+func (service *NotificationService) UnreadCount(ctx context.Context, email string) (int64, error) {
+	return service.Repo.CountUnread(ctx, email)
+}
+
+// MarkRead marks a notification of email as read. Marking it twice is not
+// an error.
+func (service *NotificationService) MarkRead(ctx context.Context, id, email string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidNotificationID
+	}
+	return service.Repo.MarkRead(ctx, objectID, email, time.Now())
+}
+
+// MarkAllRead marks every notification of email as read and returns how
+// many were unread.
+func (service *NotificationService) MarkAllRead(ctx context.Context, email string) (int64, error) {
+	return service.Repo.MarkAllRead(ctx, email, time.Now())
+}
+
+// Preferences returns the preferences of email, which are empty, and so the
+// defaults, until the user saves some.
+func (service *NotificationService) Preferences(ctx context.Context, email string) (*model.NotificationPreferences, error) {
+	preferences, err := service.Repo.GetPreferences(ctx, email)
+	if errors.Is(err, repository.ErrPreferencesNotFound) {
+		return &model.NotificationPreferences{UserEmail: email}, nil
+	}
+	return preferences, err
+}
+
+// UpdatePreferences sets the channels of the categories in channels, leaving
+// the other categories as they are. A nil webhookURL keeps the current one
+// and an empty one removes it.
+func (service *NotificationService) UpdatePreferences(ctx context.Context, email string, channels map[model.NotificationCategory][]model.NotificationChannel, webhookURL *string) (*model.NotificationPreferences, error) {
+	preferences, err := service.Preferences(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if preferences.Channels == nil {
+		preferences.Channels = map[model.NotificationCategory][]model.NotificationChannel{}
+	}
+
+	for category, chosen := range channels {
+		if _, ok := model.DefaultNotificationChannels[category]; !ok {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownCategory, category)
+		}
+		unique := []model.NotificationChannel{}
+		seen := map[model.NotificationChannel]bool{}
+		for _, channel := range chosen {
+			if channel != model.ChannelInApp && channel != model.ChannelEmail && channel != model.ChannelWebhook {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownChannel, channel)
+			}
+			if !seen[channel] {
+				seen[channel] = true
+				unique = append(unique, channel)
+			}
+		}
+		preferences.Channels[category] = unique
+	}
+
+	if webhookURL != nil {
+		preferences.WebhookURL = strings.TrimSpace(*webhookURL)
+		if preferences.WebhookURL != "" && !validWebhookURL(preferences.WebhookURL) {
+			return nil, ErrInvalidWebhookURL
+		}
+	}
+	if preferences.WebhookURL == "" {
+		for _, category := range model.NotificationCategories {
+			for _, channel := range preferences.ChannelsFor(category) {
+				if channel == model.ChannelWebhook {
+					return nil, ErrWebhookURLRequired
+				}
+			}
+		}
+	}
+
+	preferences.UserEmail = email
+	preferences.UpdatedAt = time.Now()
+	if err := service.Repo.SavePreferences(ctx, *preferences); err != nil {
+		return nil, err
+	}
+	return preferences, nil
+}
+
+func (service *NotificationService) channel(name model.NotificationChannel) DeliveryChannel {
+	for _, channel := range service.Channels {
+		if channel.Name() == name {
+			return channel
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/liju-github/internal/model"
+)
+
+// Notifier delivers a notification to notification.UserEmail.
+type Notifier interface {
+	Notify(ctx context.Context, notification model.Notification) error
+}
+
+// LogNotifier writes notifications to the server log. It is used when no
+// NotificationService is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, notification model.Notification) error {
+	log.Printf("Notification for %s: %s: %s", notification.UserEmail, notification.Subject, notification.Message)
+	return nil
+}
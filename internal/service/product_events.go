@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/liju-github/internal/model"
+)
+
+type ProductEventType string
+
+const (
+	ProductCreated       ProductEventType = "created"
+	ProductUpdated       ProductEventType = "updated"
+	ProductStatusChanged ProductEventType = "status_changed"
+	ProductDeleted       ProductEventType = "deleted"
+)
+
+// ProductEvent describes a change to a listing. Previous is the listing as
+// it was before the change, when there was one.
+type ProductEvent struct {
+	Type     ProductEventType
+	Product  model.Product
+	Previous *model.Product
+}
+
+// ProductListener reacts to a product change. The change has already been
+// stored, so an error is only logged.
+type ProductListener func(ctx context.Context, event ProductEvent) error
+
+// ProductEvents lets other services react to listings changing without the
+// product code knowing about them. Listeners run synchronously, in the order
+// they subscribed. A nil *ProductEvents drops every event.
+type ProductEvents struct {
+	mu        sync.RWMutex
+	listeners []ProductListener
+}
+
+func (events *ProductEvents) Subscribe(listener ProductListener) {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.listeners = append(events.listeners, listener)
+}
+
+func (events *ProductEvents) publish(ctx context.Context, event ProductEvent) {
+	if events == nil {
+		return
+	}
+
+	events.mu.RLock()
+	listeners := events.listeners
+	events.mu.RUnlock()
+
+	for _, listener := range listeners {
+		if err := listener(ctx, event); err != nil {
+			log.Printf("Product %s listener failed on %s event: %v", event.Product.ID.Hex(), event.Type, err)
+		}
+	}
+}
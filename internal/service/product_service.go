@@ -1,22 +1,411 @@
 package service
 
 import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/liju-github/internal/geo"
     "github.com/liju-github/internal/model"
     "github.com/liju-github/internal/repository"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+    ErrProductNotOwned  = errors.New("product belongs to another seller")
+    ErrProductNotFound  = repository.ErrProductNotFound
+    ErrInvalidProductID = repository.ErrInvalidProductID
+    ErrInvalidCursor    = repository.ErrInvalidCursor
+    ErrInvalidLocation  = errors.New("location coordinates are out of range")
+    ErrInvalidStatus    = errors.New("new products must be draft or active")
+    ErrInvalidAction    = errors.New("action is not allowed in the product's current status")
+    ErrTooManyImages    = fmt.Errorf("a product can have at most %d images", model.MaxProductImages)
+    ErrImageNotFound    = errors.New("image is not in the product gallery")
+    ErrLastImage        = errors.New("a product must keep at least one image")
+    ErrInvalidOrder     = errors.New("new image order must contain exactly the current images")
 )
 
 type ProductService struct {
     ProductRepo repository.ProductRepository
+    ListingTTL  time.Duration // How long a listing stays active; 0 means forever
+    Notifier    Notifier
+    Events      *ProductEvents // Told about new listings, edits, status changes and deletions; may be nil
 }
 
-func (service *ProductService) AddProduct(product model.Product) error {
-    return service.ProductRepo.AddProduct(product)
+func (service *ProductService) AddProduct(ctx context.Context, product model.Product) error {
+    switch product.Status {
+    case "":
+        product.Status = model.StatusActive
+    case model.StatusDraft, model.StatusActive:
+    default:
+        return ErrInvalidStatus
+    }
+
+    if err := resolveLocation(&product, nil); err != nil {
+        return err
+    }
+
+    if product.Status == model.StatusActive {
+        product.ExpiresAt = service.expiryFrom(time.Now())
+    }
+
+    // The ID is set here so that listeners know which product was added.
+    product.ID = primitive.NewObjectID()
+    if err := service.ProductRepo.AddProduct(ctx, product); err != nil {
+        return err
+    }
+    service.Events.publish(ctx, ProductEvent{Type: ProductCreated, Product: product})
+    return nil
 }
 
-func (service *ProductService) GetAllProducts() ([]model.Product, error) {
-    return service.ProductRepo.GetAllProducts()
+func (service *ProductService) GetAllProducts(ctx context.Context, query model.ProductQuery) ([]model.Product, model.Pagination, error) {
+    query.Normalize()
+    products, total, next, err := service.ProductRepo.GetAllProducts(ctx, query)
+    if err != nil {
+        return nil, model.Pagination{}, err
+    }
+
+    pagination := model.NewPagination(query.Page, query.PageSize, total)
+    pagination.NextCursor = next
+    if query.Cursor != "" {
+        pagination.Page = 0
+    }
+    return products, pagination, nil
 }
 
-func (service *ProductService)GetAllProductsByUserEmail(email string) ([]model.Product,error) {
-    return service.ProductRepo.GetAllProductsByUserEmail(email)
-}
\ No newline at end of file
+// SearchProducts runs a full-text search ranked by relevance and fills in a
+// highlighted snippet for every result.
+func (service *ProductService) SearchProducts(ctx context.Context, query model.ProductQuery) ([]model.ProductSearchResult, model.Pagination, error) {
+    query.Normalize()
+    results, total, err := service.ProductRepo.SearchProducts(ctx, query)
+    if err != nil {
+        return nil, model.Pagination{}, err
+    }
+
+    terms := searchTerms(query.Keyword)
+    for i := range results {
+        results[i].Snippet = highlightSnippet(terms, results[i].Description, results[i].Name)
+    }
+    return results, model.NewPagination(query.Page, query.PageSize, total), nil
+}
+
+func (service *ProductService) NearbyProducts(ctx context.Context, query model.NearbyQuery) ([]model.NearbyProduct, model.Pagination, error) {
+    query.Normalize()
+    products, total, err := service.ProductRepo.NearbyProducts(ctx, query)
+    if err != nil {
+        return nil, model.Pagination{}, err
+    }
+    return products, model.NewPagination(query.Page, query.PageSize, total), nil
+}
+
+// GetAllProductsByUserEmail returns a page of the seller's products and the
+// cursor of the next page. With no statuses every product is returned.
+func (service *ProductService)GetAllProductsByUserEmail(ctx context.Context, email string, page model.CursorPage, statuses ...model.ProductStatus) ([]model.Product, string, error) {
+    page.Normalize()
+    return service.ProductRepo.GetAllProductsByUserEmail(ctx, email, statuses, page)
+}
+
+// ChangeProductStatus performs a lifecycle action on a seller's product and
+// returns the updated product.
+func (service *ProductService) ChangeProductStatus(ctx context.Context, id, email string, action model.ProductAction) (*model.Product, error) {
+    product, err := service.GetOwnedProduct(ctx, id, email)
+    if err != nil {
+        return nil, err
+    }
+
+    from := product.Status.OrDefault()
+    to, ok := from.Apply(action)
+    if !ok {
+        return nil, ErrInvalidAction
+    }
+
+    // Every time a listing becomes active it gets a fresh expiry date.
+    var expiresAt *time.Time
+    if to == model.StatusActive {
+        expiresAt = service.expiryFrom(time.Now())
+    }
+
+    changed, err := service.ProductRepo.UpdateProductStatus(ctx, product.ID, email, repository.StatusChange{
+        From:      from,
+        To:        to,
+        ExpiresAt: expiresAt,
+        By:        email,
+    })
+    if err != nil {
+        return nil, err
+    }
+    if !changed {
+        // Someone else changed the status since we read it.
+        return nil, ErrInvalidAction
+    }
+
+    previous := *product
+    product.Status = to
+    product.Audit.Touch(email, time.Now())
+    if to == model.StatusActive {
+        product.ExpiresAt = expiresAt
+        product.ExpiryWarnedAt = nil
+    }
+    service.Events.publish(ctx, ProductEvent{Type: ProductStatusChanged, Product: *product, Previous: &previous})
+    return product, nil
+}
+
+// AddImage appends an image to the gallery of a seller's product.
+func (service *ProductService) AddImage(ctx context.Context, id, email, url string) ([]string, error) {
+    return service.updateImages(ctx, id, email, func(images []string) ([]string, error) {
+        if indexOf(images, url) >= 0 {
+            return images, nil
+        }
+        if len(images) >= model.MaxProductImages {
+            return nil, ErrTooManyImages
+        }
+        return append(images, url), nil
+    })
+}
+
+// RemoveImage removes an image from the gallery. Removing the cover makes the
+// next image the cover.
+func (service *ProductService) RemoveImage(ctx context.Context, id, email, url string) ([]string, error) {
+    return service.updateImages(ctx, id, email, func(images []string) ([]string, error) {
+        i := indexOf(images, url)
+        if i < 0 {
+            return nil, ErrImageNotFound
+        }
+        if len(images) == 1 {
+            return nil, ErrLastImage
+        }
+        return append(images[:i:i], images[i+1:]...), nil
+    })
+}
+
+// SetCoverImage moves an image of the gallery to the front.
+func (service *ProductService) SetCoverImage(ctx context.Context, id, email, url string) ([]string, error) {
+    return service.updateImages(ctx, id, email, func(images []string) ([]string, error) {
+        i := indexOf(images, url)
+        if i < 0 {
+            return nil, ErrImageNotFound
+        }
+        reordered := append([]string{url}, images[:i]...)
+        return append(reordered, images[i+1:]...), nil
+    })
+}
+
+// ReorderImages puts the gallery in the given order, which must be a
+// permutation of the current images. The first image becomes the cover.
+func (service *ProductService) ReorderImages(ctx context.Context, id, email string, order []string) ([]string, error) {
+    return service.updateImages(ctx, id, email, func(images []string) ([]string, error) {
+        if len(order) != len(images) {
+            return nil, ErrInvalidOrder
+        }
+        seen := make(map[string]bool, len(order))
+        for _, url := range order {
+            if seen[url] || indexOf(images, url) < 0 {
+                return nil, ErrInvalidOrder
+            }
+            seen[url] = true
+        }
+        return order, nil
+    })
+}
+
+func (service *ProductService) updateImages(ctx context.Context, id, email string, change func([]string) ([]string, error)) ([]string, error) {
+    product, err := service.GetOwnedProduct(ctx, id, email)
+    if err != nil {
+        return nil, err
+    }
+    product.NormalizeImages()
+
+    images, err := change(product.Images)
+    if err != nil {
+        return nil, err
+    }
+
+    updated, err := service.ProductRepo.UpdateProductImages(ctx, product.ID, email, images)
+    if err != nil {
+        return nil, err
+    }
+    if !updated {
+        return nil, ErrProductNotFound
+    }
+    return images, nil
+}
+
+func indexOf(values []string, value string) int {
+    for i, v := range values {
+        if v == value {
+            return i
+        }
+    }
+    return -1
+}
+
+// ExpireListings moves every active listing past its expiry date to expired.
+func (service *ProductService) ExpireListings(ctx context.Context) error {
+    products, err := service.ProductRepo.GetExpiredProducts(ctx, time.Now())
+    if err != nil {
+        return err
+    }
+
+    expired := 0
+    for _, product := range products {
+        changed, err := service.ProductRepo.UpdateProductStatus(ctx, product.ID, product.Email, repository.StatusChange{
+            From: model.StatusActive,
+            To:   model.StatusExpired,
+            By:   model.SystemActor,
+        })
+        if err != nil {
+            return err
+        }
+        if changed {
+            expired++
+            previous := product
+            product.Status = model.StatusExpired
+            service.Events.publish(ctx, ProductEvent{Type: ProductStatusChanged, Product: product, Previous: &previous})
+        }
+    }
+    if expired > 0 {
+        log.Printf("Expired %d listings", expired)
+    }
+    return nil
+}
+
+// WarnExpiringListings notifies the sellers of listings that expire within
+// warnBefore. Each listing is warned about once per expiry date.
+func (service *ProductService) WarnExpiringListings(ctx context.Context, warnBefore time.Duration) error {
+    now := time.Now()
+    products, err := service.ProductRepo.GetProductsToWarn(ctx, now, now.Add(warnBefore))
+    if err != nil {
+        return err
+    }
+
+    for _, product := range products {
+        message := fmt.Sprintf("Your listing %q expires on %s. Renew it to keep it visible to buyers.",
+            product.Name, product.ExpiresAt.Format(time.RFC1123))
+        err := service.notifier().Notify(ctx, model.Notification{
+            UserEmail: product.Email,
+            Category:  model.CategoryListings,
+            Subject:   "Listing about to expire",
+            Message:   message,
+            Link:      "/products/" + product.ID.Hex(),
+        })
+        if err != nil {
+            log.Printf("Failed to warn %s about expiring product %s: %v", product.Email, product.ID.Hex(), err)
+            continue
+        }
+        if err := service.ProductRepo.MarkExpiryWarned(ctx, product.ID, now); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (service *ProductService) expiryFrom(t time.Time) *time.Time {
+    if service.ListingTTL <= 0 {
+        return nil
+    }
+    expiresAt := t.Add(service.ListingTTL)
+    return &expiresAt
+}
+
+func (service *ProductService) notifier() Notifier {
+    if service.Notifier == nil {
+        return LogNotifier{}
+    }
+    return service.Notifier
+}
+
+// GetProductByID returns a product as buyers see it. Drafts and archived
+// listings are only visible to their seller, so they are reported as missing.
+func (service *ProductService) GetProductByID(ctx context.Context, id string) (*model.Product, error) {
+    product, err := service.ProductRepo.GetProductByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    switch product.Status {
+    case model.StatusDraft, model.StatusArchived:
+        return nil, ErrProductNotFound
+    }
+    // Products listed before galleries existed only have a cover image.
+    product.NormalizeImages()
+    return product, nil
+}
+
+// GetOwnedProduct fetches a product and makes sure it was listed by email.
+func (service *ProductService) GetOwnedProduct(ctx context.Context, id, email string) (*model.Product, error) {
+    product, err := service.ProductRepo.GetProductByID(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if product.Email != email {
+        return nil, ErrProductNotOwned
+    }
+    return product, nil
+}
+
+// UpdateProduct stores the edited fields of product and returns the listing
+// as stored, with the status and expiry of previous. product.Email must be
+// the email of the seller making the change.
+func (service *ProductService) UpdateProduct(ctx context.Context, previous *model.Product, product model.Product) (*model.Product, error) {
+    // Status and expiry only change through ChangeProductStatus.
+    product.Status = previous.Status
+    product.ExpiresAt = previous.ExpiresAt
+    product.ExpiryWarnedAt = previous.ExpiryWarnedAt
+    product.Audit = previous.Audit
+    product.Audit.Touch(product.Email, time.Now())
+
+    if err := resolveLocation(&product, previous); err != nil {
+        return nil, err
+    }
+
+    updated, err := service.ProductRepo.UpdateProduct(ctx, product)
+    if err != nil {
+        return nil, err
+    }
+    if !updated {
+        return nil, ErrProductNotFound
+    }
+    service.Events.publish(ctx, ProductEvent{Type: ProductUpdated, Product: product, Previous: previous})
+    return &product, nil
+}
+
+func (service *ProductService) DeleteProduct(ctx context.Context, id, email string) error {
+    product, err := service.GetOwnedProduct(ctx, id, email)
+    if err != nil {
+        return err
+    }
+
+    deleted, err := service.ProductRepo.DeleteProduct(ctx, product.ID, email)
+    if err != nil {
+        return err
+    }
+    if !deleted {
+        return ErrProductNotFound
+    }
+    service.Events.publish(ctx, ProductEvent{Type: ProductDeleted, Product: *product})
+    return nil
+}
+
+// resolveLocation checks a location supplied by the seller, or derives one
+// from the pincode when there is none. When an update changes the pincode but
+// keeps the previous location, the location is derived again so it does not
+// point at the old address.
+func resolveLocation(product *model.Product, previous *model.Product) error {
+    if product.Location != nil {
+        if !product.Location.Valid() {
+            return ErrInvalidLocation
+        }
+        stale := previous != nil && previous.Location != nil &&
+            previous.Pincode != product.Pincode && *previous.Location == *product.Location
+        if !stale {
+            return nil
+        }
+        product.Location = nil
+    }
+
+    if coordinates, ok := geo.LookupPincode(product.Pincode); ok {
+        product.Location = model.NewGeoPoint(coordinates.Latitude, coordinates.Longitude)
+    }
+    return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/realtime"
+)
+
+// publish pushes an event to the connections subscribed to topic. Pushing
+// is best effort: hub may be nil, and failures are logged without failing
+// the change that caused the event, since clients catch up over HTTP.
+func publish(ctx context.Context, hub realtime.Hub, topic string, event realtime.Event) {
+	if hub == nil {
+		return
+	}
+	if err := hub.Publish(ctx, topic, event); err != nil {
+		log.Printf("Failed to publish %s event to %s: %v", event.Type, topic, err)
+	}
+}
+
+// ProductStatusPublisher returns a ProductListener that pushes status
+// changes and deletions of a product to the users watching it.
+func ProductStatusPublisher(hub realtime.Hub) ProductListener {
+	return func(ctx context.Context, event ProductEvent) error {
+		id := event.Product.ID.Hex()
+		switch event.Type {
+		case ProductStatusChanged:
+			status := dto.ProductStatusEvent{
+				ProductID: id,
+				Name:      event.Product.Name,
+				Status:    event.Product.Status.OrDefault(),
+			}
+			if event.Previous != nil {
+				status.PreviousStatus = event.Previous.Status.OrDefault()
+			}
+			publish(ctx, hub, realtime.ProductTopic(id), realtime.Event{Type: realtime.EventProductStatus, Data: status})
+		case ProductDeleted:
+			deleted := dto.ProductDeletedEvent{ProductID: id}
+			publish(ctx, hub, realtime.ProductTopic(id), realtime.Event{Type: realtime.EventProductDeleted, Data: deleted})
+		}
+		return nil
+	}
+}
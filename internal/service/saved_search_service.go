@@ -0,0 +1,261 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/liju-github/internal/dto"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/realtime"
+	"github.com/liju-github/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrSavedSearchNotFound  = repository.ErrSavedSearchNotFound
+	ErrInvalidSavedSearchID = errors.New("invalid saved search id")
+	ErrEmptySearchName      = errors.New("a saved search needs a name")
+	ErrEmptySearch          = errors.New("a saved search needs at least one filter")
+	ErrInvalidPriceRange    = errors.New("min_price cannot be greater than max_price")
+	ErrTooManySearches      = fmt.Errorf("a user can save at most %d searches", model.MaxSavedSearches)
+)
+
+// SavedSearchService lets users save product searches and tells them about
+// new listings that match, either right away or in a daily digest. Matches
+// are found by HandleProductEvent and digests are sent by SendDigests.
+type SavedSearchService struct {
+	SearchRepo     repository.SavedSearchRepository
+	ProductRepo    repository.ProductRepository
+	Notifier       Notifier
+	Hub            realtime.Hub  // May be nil
+	DigestInterval time.Duration // Least time between two digests of a search
+}
+
+// Create saves a search for email. An empty frequency means instant alerts.
+func (service *SavedSearchService) Create(ctx context.Context, email string, search model.SavedSearch) (*model.SavedSearch, error) {
+	if err := normalizeSavedSearch(&search); err != nil {
+		return nil, err
+	}
+	count, err := service.SearchRepo.CountSavedSearches(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if count >= model.MaxSavedSearches {
+		return nil, ErrTooManySearches
+	}
+
+	now := time.Now()
+	search.UserEmail = email
+	search.CreatedAt = now
+	search.UpdatedAt = now
+	search.PendingMatches = nil
+	search.LastDigestAt = nil
+	id, err := service.SearchRepo.AddSavedSearch(ctx, search)
+	if err != nil {
+		return nil, err
+	}
+	search.ID = id
+	return &search, nil
+}
+
+// List returns the searches email saved, newest first.
+func (service *SavedSearchService) List(ctx context.Context, email string) ([]model.SavedSearch, error) {
+	return service.SearchRepo.GetSavedSearches(ctx, email)
+}
+
+// Get returns a search saved by email. Searches of other users are reported
+// as missing.
+func (service *SavedSearchService) Get(ctx context.Context, id, email string) (*model.SavedSearch, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidSavedSearchID
+	}
+	search, err := service.SearchRepo.GetSavedSearch(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if search.UserEmail != email {
+		return nil, ErrSavedSearchNotFound
+	}
+	return search, nil
+}
+
+// Update replaces the name, filters and frequency of a search saved by
+// email. Matches waiting for a digest are dropped.
+func (service *SavedSearchService) Update(ctx context.Context, id, email string, search model.SavedSearch) (*model.SavedSearch, error) {
+	existing, err := service.Get(ctx, id, email)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeSavedSearch(&search); err != nil {
+		return nil, err
+	}
+
+	existing.Name = search.Name
+	existing.Filters = search.Filters
+	existing.Frequency = search.Frequency
+	existing.UpdatedAt = time.Now()
+	existing.PendingMatches = nil
+	updated, err := service.SearchRepo.UpdateSavedSearch(ctx, *existing)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrSavedSearchNotFound
+	}
+	return existing, nil
+}
+
+func (service *SavedSearchService) Delete(ctx context.Context, id, email string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidSavedSearchID
+	}
+	deleted, err := service.SearchRepo.DeleteSavedSearch(ctx, objectID, email)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrSavedSearchNotFound
+	}
+	return nil
+}
+
+// HandleProductEvent matches new listings against the saved searches of
+// other users. A listing is new when it is added as active or when its
+// draft is published. It is meant to be subscribed to ProductEvents.
+func (service *SavedSearchService) HandleProductEvent(ctx context.Context, event ProductEvent) error {
+	product := event.Product
+	published := event.Type == ProductStatusChanged && event.Previous != nil &&
+		event.Previous.Status == model.StatusDraft && product.Status == model.StatusActive
+	if !published && event.Type != ProductCreated {
+		return nil
+	}
+	if product.Status.OrDefault() != model.StatusActive {
+		return nil
+	}
+
+	searches, err := service.SearchRepo.FindMatchingSearches(ctx, product)
+	if err != nil {
+		return err
+	}
+	for _, search := range searches {
+		if !search.Filters.Matches(product) {
+			continue
+		}
+		if search.Frequency == model.AlertDaily {
+			if err := service.SearchRepo.AddPendingMatch(ctx, search.ID, product.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		match := dto.SavedSearchMatch{
+			SearchID:   search.ID.Hex(),
+			SearchName: search.Name,
+			Products:   []dto.ProductSummary{dto.NewProductSummary(product)},
+		}
+		publish(ctx, service.Hub, realtime.UserTopic(search.UserEmail), realtime.Event{Type: realtime.EventSearchMatch, Data: match})
+		message := fmt.Sprintf("%q was just listed for %.2f and matches your search %q.", product.Name, product.Price, search.Name)
+		err := service.notifier().Notify(ctx, model.Notification{
+			UserEmail: search.UserEmail,
+			Category:  model.CategorySavedSearches,
+			Subject:   "New match for a saved search",
+			Message:   message,
+			Link:      "/products/" + product.ID.Hex(),
+		})
+		if err != nil {
+			log.Printf("Failed to tell %s about a match of search %s: %v", search.UserEmail, search.ID.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// SendDigests sends every daily search that has pending matches and no
+// digest within DigestInterval a digest of the matches still active.
+func (service *SavedSearchService) SendDigests(ctx context.Context) error {
+	now := time.Now()
+	searches, err := service.SearchRepo.GetDueDigests(ctx, now.Add(-service.DigestInterval))
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	for _, search := range searches {
+		products, err := service.ProductRepo.GetProductsByIDs(ctx, search.PendingMatches)
+		if err != nil {
+			return err
+		}
+
+		// Matches sold or withdrawn since are left out.
+		var summaries []dto.ProductSummary
+		var names []string
+		for _, product := range products {
+			if product.Status.OrDefault() == model.StatusActive {
+				summaries = append(summaries, dto.NewProductSummary(product))
+				names = append(names, fmt.Sprintf("%q", product.Name))
+			}
+		}
+
+		if len(summaries) > 0 {
+			match := dto.SavedSearchMatch{SearchID: search.ID.Hex(), SearchName: search.Name, Products: summaries}
+			publish(ctx, service.Hub, realtime.UserTopic(search.UserEmail), realtime.Event{Type: realtime.EventSearchMatch, Data: match})
+			message := fmt.Sprintf("%d new listings match your search %q: %s.", len(names), search.Name, strings.Join(names, ", "))
+			err := service.notifier().Notify(ctx, model.Notification{
+				UserEmail: search.UserEmail,
+				Category:  model.CategorySavedSearches,
+				Subject:   "Daily digest of a saved search",
+				Message:   message,
+				Link:      "/saved-searches/" + search.ID.Hex(),
+			})
+			if err != nil {
+				// The matches stay pending and are sent with the next digest.
+				log.Printf("Failed to send %s the digest of search %s: %v", search.UserEmail, search.ID.Hex(), err)
+				continue
+			}
+			sent++
+		}
+		if err := service.SearchRepo.MarkDigestSent(ctx, search.ID, search.PendingMatches, now); err != nil {
+			return err
+		}
+	}
+	if sent > 0 {
+		log.Printf("Sent %d saved search digests", sent)
+	}
+	return nil
+}
+
+func (service *SavedSearchService) notifier() Notifier {
+	if service.Notifier == nil {
+		return LogNotifier{}
+	}
+	return service.Notifier
+}
+
+// normalizeSavedSearch trims the search, fills in the default frequency and
+// checks that it filters on something.
+func normalizeSavedSearch(search *model.SavedSearch) error {
+	search.Name = strings.TrimSpace(search.Name)
+	search.Filters.Category = strings.TrimSpace(search.Filters.Category)
+	search.Filters.State = strings.TrimSpace(search.Filters.State)
+	search.Filters.Keyword = strings.TrimSpace(search.Filters.Keyword)
+	if search.Frequency == "" {
+		search.Frequency = model.AlertInstant
+	}
+
+	if search.Name == "" {
+		return ErrEmptySearchName
+	}
+	filters := search.Filters
+	if filters.Empty() {
+		return ErrEmptySearch
+	}
+	if filters.MinPrice != nil && filters.MaxPrice != nil && *filters.MinPrice > *filters.MaxPrice {
+		return ErrInvalidPriceRange
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/liju-github/internal/auth"
+	"github.com/liju-github/internal/model"
+	"github.com/liju-github/internal/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+)
+
+// TokenPair is what a client receives when it logs in or refreshes.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+// SessionService issues short-lived access tokens and rotating refresh
+// tokens. Every refresh token can be used once; presenting a used one again
+// means it was stolen, so the whole session is revoked.
+type SessionService struct {
+	SessionRepo     repository.SessionRepository
+	UserRepo        repository.UserRepository
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	Keys            *auth.KeySet
+}
+
+// StartSession creates a session for a user who has just logged in.
+func (service *SessionService) StartSession(ctx context.Context, user *model.User) (*TokenPair, error) {
+	now := time.Now()
+	sessionID, err := service.SessionRepo.CreateSession(ctx, model.Session{
+		UserEmail: user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(service.RefreshTokenTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return service.issue(ctx, user, sessionID, now)
+}
+
+// Refresh exchanges a refresh token for a new token pair in the same session.
+func (service *SessionService) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	stored, err := service.SessionRepo.GetRefreshToken(ctx, auth.HashRefreshToken(refreshToken))
+	if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if now.After(stored.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := service.SessionRepo.GetSession(ctx, stored.SessionID)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrSessionRevoked
+	}
+
+	fresh, err := service.SessionRepo.MarkRefreshTokenUsed(ctx, stored.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if stored.UsedAt != nil || !fresh {
+		log.Printf("Refresh token reuse detected for %s, revoking session %s", stored.UserEmail, stored.SessionID.Hex())
+		if err := service.SessionRepo.RevokeSession(ctx, stored.SessionID, "refresh token reuse"); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	user, err := service.UserRepo.GetUserByEmail(ctx, stored.UserEmail)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	if err != nil || user.Suspended {
+		if err := service.SessionRepo.RevokeSession(ctx, stored.SessionID, "user unavailable"); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionRevoked
+	}
+
+	return service.issue(ctx, user, stored.SessionID, now)
+}
+
+// Logout revokes a session so that neither its refresh tokens nor its access
+// tokens are accepted anymore.
+func (service *SessionService) Logout(ctx context.Context, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionRevoked
+	}
+	return service.SessionRepo.RevokeSession(ctx, id, "logout")
+}
+
+// RevokeUserSessions logs a user out everywhere.
+func (service *SessionService) RevokeUserSessions(ctx context.Context, email, reason string) error {
+	return service.SessionRepo.RevokeUserSessions(ctx, email, reason)
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token.
+func (service *SessionService) ParseAccessToken(token string) (*auth.Claims, error) {
+	return service.Keys.ParseJWT(token)
+}
+
+// ValidateSession checks that the session an access token belongs to is
+// still active.
+func (service *SessionService) ValidateSession(ctx context.Context, sessionID string) error {
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return ErrSessionRevoked
+	}
+
+	session, err := service.SessionRepo.GetSession(ctx, id)
+	if errors.Is(err, repository.ErrSessionNotFound) {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	return nil
+}
+
+func (service *SessionService) issue(ctx context.Context, user *model.User, sessionID primitive.ObjectID, now time.Time) (*TokenPair, error) {
+	refreshToken, hash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(service.RefreshTokenTTL)
+	err = service.SessionRepo.AddRefreshToken(ctx, model.RefreshToken{
+		TokenHash: hash,
+		SessionID: sessionID,
+		UserEmail: user.Email,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := service.SessionRepo.ExtendSession(ctx, sessionID, expiresAt); err != nil {
+		return nil, err
+	}
+
+	accessToken, err := service.Keys.GenerateJWT(user.Email, user.Role.OrDefault(), sessionID.Hex(), service.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(service.AccessTokenTTL / time.Second),
+	}, nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	snippetLength  = 160
+	snippetContext = 40
+)
+
+var searchTermPattern = regexp.MustCompile(`"[^"]*"|\S+`)
+
+// searchTerms splits a MongoDB $text search string into the words that should
+// be highlighted. Negated terms are dropped and phrases are kept together.
+func searchTerms(search string) []string {
+	var terms []string
+	for _, term := range searchTermPattern.FindAllString(search, -1) {
+		if strings.HasPrefix(term, "-") {
+			continue
+		}
+		term = strings.TrimSpace(strings.Trim(term, `"`))
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// highlightSnippet returns an HTML-escaped excerpt of the first text that
+// contains one of terms, centred on the first match, with every match wrapped
+// in <mark>. The text search stems words, so a term also matches longer words
+// that start with it.
+func highlightSnippet(terms []string, texts ...string) string {
+	if len(texts) == 0 {
+		return ""
+	}
+	if len(terms) == 0 {
+		return html.EscapeString(truncateRunes(texts[0], 0, snippetLength))
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	pattern := regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\w*`)
+
+	text, start := texts[0], 0
+	for _, candidate := range texts {
+		if loc := pattern.FindStringIndex(candidate); loc != nil {
+			text = candidate
+			start = utf8.RuneCountInString(candidate[:loc[0]]) - snippetContext
+			break
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	excerpt := truncateRunes(text, start, snippetLength)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(excerpt, -1) {
+		b.WriteString(html.EscapeString(excerpt[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(excerpt[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(html.EscapeString(excerpt[last:]))
+	if utf8.RuneCountInString(text) > start+snippetLength {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func truncateRunes(text string, start, length int) string {
+	runes := []rune(text)
+	if start > len(runes) {
+		start = len(runes)
+	}
+	end := start + length
+	if end > len(runes) {
+		end = len(runes)
+	}
+	return string(runes[start:end])
+}
@@ -0,0 +1,9 @@
+package service
+
+import "github.com/liju-github/internal/repository"
+
+// IsTimeout reports whether err means that a database operation ran out of
+// time, so that handlers can answer 504 instead of 500.
+func IsTimeout(err error) bool {
+	return repository.IsTimeout(err)
+}
@@ -9,54 +9,85 @@ import (
 	"github.com/liju-github/internal/utils"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserSuspended      = errors.New("user is suspended")
+	ErrUserNotFound       = repository.ErrUserNotFound
+	ErrDuplicateEmail     = repository.ErrDuplicateEmail
+)
+
 type UserService struct {
-	UserRepo repository.UserRepository
+	UserRepo   repository.UserRepository
+	BcryptCost int // 0 means utils.DefaultPasswordCost
 }
 
-func (service *UserService) RegisterUser(user model.User) error {
+func (service *UserService) RegisterUser(ctx context.Context, user model.User) error {
 	// Hash the user's password
-	hashedPassword, err := utils.HashPassword(user.Password)
+	hashedPassword, err := utils.HashPassword(user.Password, service.BcryptCost)
 	if err != nil {
 		return err
 	}
 	user.Password = hashedPassword
+	user.Role = model.RoleUser
+	user.Suspended = false
 
 	// Check if the user already exists
-	existingUser, err := service.UserRepo.GetUserByEmail(user.Email)
+	existingUser, err := service.UserRepo.GetUserByEmail(ctx, user.Email)
 	if err == nil && existingUser != nil {
-		return errors.New("user already exists") // User exists
+		return ErrDuplicateEmail // User exists
+	}
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return err
 	}
 	// Add the user to the repository
-	return service.UserRepo.AddUser(user)
+	return service.UserRepo.AddUser(ctx, user)
 }
 
-func (service *UserService) Login(email, password string) (*model.User, error) {
+func (service *UserService) Login(ctx context.Context, email, password string) (*model.User, error) {
 	// Retrieve user by email
-	user, err := service.UserRepo.GetUserByEmail(email)
+	user, err := service.UserRepo.GetUserByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidCredentials // Handle email not found
+	}
 	if err != nil {
-		return nil, errors.New("invalid email or password") // Handle email not found
+		return nil, err
 	}
 
 	// Check if the password is correct
 	if !utils.CheckPasswordHash(password, user.Password) {
-		return nil, errors.New("invalid email or password") // Handle wrong password
+		return nil, ErrInvalidCredentials // Handle wrong password
+	}
+
+	if user.Suspended {
+		return nil, ErrUserSuspended
 	}
 
+	user.Role = user.Role.OrDefault()
 	return user, nil
 }
 
-func (service *UserService) AllUsers() ([]model.User, error) {
-	users, err := service.UserRepo.GetAllUsers()
-	if err != nil {
-		return nil, errors.New(err.Error()) // Handle unexpected errors from repository
-	}
-	return users, nil
+func (service *UserService) AllUsers(ctx context.Context, page model.CursorPage) ([]model.User, string, error) {
+	page.Normalize()
+	return service.UserRepo.GetAllUsers(ctx, page)
 }
 
-func (s *UserService) GetUserByEmail(email string) (*model.User, error) {
-	return s.UserRepo.GetUserByEmail(email)
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.UserRepo.GetUserByEmail(ctx, email)
 }
 
 func (service *UserService) UpdateUserImage(ctx context.Context, userEmail string, newImageUrl string) error {
 	return service.UserRepo.UpdateUserImage(ctx, userEmail, newImageUrl)
 }
+
+// BootstrapAdmin makes an existing user an admin. It is used at start-up to
+// create the first admin, who can then promote others.
+func (service *UserService) BootstrapAdmin(ctx context.Context, email string) error {
+	user, err := service.UserRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user.Role == model.RoleAdmin {
+		return nil
+	}
+	return service.UserRepo.UpdateUserRole(ctx, email, model.RoleAdmin, model.SystemActor)
+}
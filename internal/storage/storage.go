@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps uploaded files. Keys are slash separated paths such as
+// "images/ab12.jpg" and never come directly from clients.
+type Storage interface {
+	// Save stores the contents of r under key, replacing any existing file.
+	Save(ctx context.Context, key string, r io.Reader) error
+	// Open returns the file stored under key, or ErrNotFound.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the file stored under key. Deleting a missing file is
+	// not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL the file is served from.
+	URL(key string) string
+}
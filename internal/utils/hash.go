@@ -4,8 +4,14 @@ import (
     "golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-    bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+// DefaultPasswordCost is the bcrypt cost used when none is configured.
+const DefaultPasswordCost = 14
+
+func HashPassword(password string, cost int) (string, error) {
+    if cost == 0 {
+        cost = DefaultPasswordCost
+    }
+    bytes, err := bcrypt.GenerateFromPassword([]byte(password), cost)
     return string(bytes), err
 }
 